@@ -0,0 +1,150 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/eastore-project/eastore/pkg/contract"
+	"github.com/eastore-project/eastore/pkg/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/urfave/cli/v2"
+)
+
+// BalanceCommand returns the CLI command for managing the contract's storage market escrow
+func BalanceCommand() *cli.Command {
+	confirmationsFlag := &cli.Uint64Flag{
+		Name:    "confirmations",
+		Usage:   "number of block confirmations to wait for (default: 1)",
+		Value:   DefaultConfirmations,
+		EnvVars: []string{"CONFIRMATIONS"},
+	}
+
+	return &cli.Command{
+		Name:  "balance",
+		Usage: "Manage the storage market escrow used by non-verified deals",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "add",
+				Usage: "Add funds to the contract's storage market escrow",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "amount",
+						Required: true,
+						Usage:    "Amount to add, e.g. 1.5FIL or 100attoFIL (a bare number is read as FIL)",
+					},
+					confirmationsFlag,
+				},
+				Action: balanceAddAction,
+			},
+			{
+				Name:  "withdraw",
+				Usage: "Withdraw funds from a storage market escrow",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "amount",
+						Required: true,
+						Usage:    "Amount to withdraw, e.g. 1.5FIL or 100attoFIL (a bare number is read as FIL)",
+					},
+					&cli.StringFlag{
+						Name:  "client",
+						Usage: "Address whose escrow is withdrawn from (default: the contract address)",
+					},
+					confirmationsFlag,
+				},
+				Action: balanceWithdrawAction,
+			},
+			{
+				Name:   "show",
+				Usage:  "Show the contract balance and its storage market escrow",
+				Action: balanceShowAction,
+			},
+		},
+	}
+}
+
+func balanceAddAction(cCtx *cli.Context) error {
+	amount, err := utils.ParseFIL(cCtx.String("amount"))
+	if err != nil {
+		return err
+	}
+
+	client, err := newDealClient(cCtx)
+	if err != nil {
+		return err
+	}
+
+	txHash, err := client.AddBalance(cCtx.Context, amount)
+	if err != nil {
+		return fmt.Errorf("failed to add balance: %w", err)
+	}
+	if client.AddBalancePayable() {
+		fmt.Printf("Adding %s from the wallet in transaction: %s\n", utils.FormatFIL(amount), txHash.Hex())
+	} else {
+		fmt.Printf("Adding %s from the contract balance in transaction: %s\n", utils.FormatFIL(amount), txHash.Hex())
+	}
+
+	if _, err := client.WaitForSuccess(cCtx.Context, txHash, cCtx.Uint64("confirmations")); err != nil {
+		return fmt.Errorf("failed to add balance: %w", err)
+	}
+
+	return printBalances(cCtx, client)
+}
+
+func balanceWithdrawAction(cCtx *cli.Context) error {
+	amount, err := utils.ParseFIL(cCtx.String("amount"))
+	if err != nil {
+		return err
+	}
+
+	client, err := newDealClient(cCtx)
+	if err != nil {
+		return err
+	}
+
+	account := client.ContractAddress()
+	if c := cCtx.String("client"); c != "" {
+		if !common.IsHexAddress(c) {
+			return fmt.Errorf("invalid client address %q", c)
+		}
+		account = common.HexToAddress(c)
+	}
+
+	txHash, err := client.WithdrawBalance(cCtx.Context, account, amount)
+	if err != nil {
+		return fmt.Errorf("failed to withdraw balance: %w", err)
+	}
+	fmt.Printf("Withdrawing %s in transaction: %s\n", utils.FormatFIL(amount), txHash.Hex())
+
+	if _, err := client.WaitForSuccess(cCtx.Context, txHash, cCtx.Uint64("confirmations")); err != nil {
+		return fmt.Errorf("failed to withdraw balance: %w", err)
+	}
+
+	return printBalances(cCtx, client)
+}
+
+func balanceShowAction(cCtx *cli.Context) error {
+	client, err := newDealClient(cCtx)
+	if err != nil {
+		return err
+	}
+	return printBalances(cCtx, client)
+}
+
+// printBalances prints the contract's own balance and its storage market escrow
+func printBalances(cCtx *cli.Context, client *contract.DealClient) error {
+	contractBalance, err := client.ContractBalance(cCtx.Context)
+	if err != nil {
+		return err
+	}
+	escrow, err := client.MarketBalance(cCtx.Context, client.ContractAddress())
+	if err != nil {
+		return err
+	}
+
+	return printTable([][2]string{
+		{"Contract", client.ContractAddress().Hex()},
+		{"Contract balance", utils.FormatFIL(contractBalance)},
+		{"Escrow", utils.FormatFIL(escrow.Escrow)},
+		{"Locked", utils.FormatFIL(escrow.Locked)},
+		{"Available", utils.FormatFIL(escrow.Available())},
+	})
+}
@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/eastore-project/eastore/pkg/contract"
+	"github.com/eastore-project/eastore/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// requirePrivateKey returns the wallet's private key, which only commands that sign
+// need; the others run without it
+func requirePrivateKey(cCtx *cli.Context) (string, error) {
+	privateKey := cCtx.String("private-key")
+	if privateKey == "" {
+		return "", fmt.Errorf("this command needs the wallet: pass --private-key or set PRIVATE_KEY")
+	}
+	return privateKey, nil
+}
+
+// newDealClient creates a deal client from the global connection flags
+func newDealClient(cCtx *cli.Context) (*contract.DealClient, error) {
+	privateKey, err := requirePrivateKey(cCtx)
+	if err != nil {
+		return nil, err
+	}
+	client, err := contract.NewDealClient(
+		cCtx.String("rpc-url"),
+		cCtx.String("contract"),
+		privateKey,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create deal client: %w", err)
+	}
+	return client, nil
+}
+
+// ErrorHint returns a suggestion for resolving a contract error, or an empty string
+// if there is nothing to add to the error message
+func ErrorHint(err error) string {
+	var notEnoughBalance *contract.NotEnoughBalanceError
+	var contractErr *contract.ContractError
+	var actorErr *contract.ActorError
+
+	switch {
+	case errors.As(err, &notEnoughBalance):
+		missing := new(big.Int).Sub(notEnoughBalance.Value, notEnoughBalance.Balance)
+		return fmt.Sprintf("the contract is %s short; fund it before retrying, then check with `eastore balance show`", utils.FormatFIL(missing))
+	case errors.As(err, &contractErr) && contractErr.Name == "NotApprovedByGateway":
+		return "the message must be approved by the contract's gateway before it can be executed"
+	case errors.As(err, &actorErr):
+		return "check the deal parameters and the client's market balance or data-cap allowance"
+	default:
+		return ""
+	}
+}
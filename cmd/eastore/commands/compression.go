@@ -0,0 +1,69 @@
+package commands
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/eastore-project/eastore/pkg/encryption"
+	"github.com/urfave/cli/v2"
+)
+
+// DefaultCompression is the default compression applied before encryption
+const DefaultCompression = "none"
+
+// compressionFlag selects the compression applied before encryption
+func compressionFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "compress",
+		Usage:   "Compress the data before encrypting it: none, gzip or zstd (decrypt decompresses transparently)",
+		Value:   DefaultCompression,
+		EnvVars: []string{"COMPRESSION"},
+	}
+}
+
+// parseCompressionFlag returns the compression selected by compressionFlag
+func parseCompressionFlag(cCtx *cli.Context) (encryption.Compression, error) {
+	return encryption.ParseCompression(cCtx.String("compress"))
+}
+
+// printSizeStats reports the size of the input and of its encrypted form, which is
+// what ends up in storage
+func printSizeStats(inputPath, encryptedPath string) error {
+	original, err := pathSize(inputPath)
+	if err != nil {
+		return err
+	}
+	encrypted, err := pathSize(encryptedPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Original size: %d bytes\n", original)
+	if original == 0 {
+		fmt.Printf("Encrypted size: %d bytes\n", encrypted)
+		return nil
+	}
+	fmt.Printf("Encrypted size: %d bytes (%.1f%% of original)\n", encrypted, 100*float64(encrypted)/float64(original))
+	return nil
+}
+
+// pathSize returns the size of a file, or the total size of the files below a directory
+func pathSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure %s: %w", path, err)
+	}
+	return size, nil
+}
@@ -0,0 +1,122 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ipfs/go-cid"
+	"github.com/urfave/cli/v2"
+)
+
+// DealStatusCommand returns the CLI command for querying the on-chain state of a deal
+func DealStatusCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "deal-status",
+		Usage: "Show the on-chain status of a deal by piece CID or proposal ID",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "piece-cid",
+				Usage: "Piece CID of the deal",
+			},
+			&cli.StringFlag{
+				Name:  "proposal-id",
+				Usage: "Hex-encoded proposal ID returned when the deal was proposed",
+			},
+		},
+		Action: dealStatusAction,
+	}
+}
+
+func dealStatusAction(cCtx *cli.Context) error {
+	pieceCidStr := cCtx.String("piece-cid")
+	proposalIDStr := cCtx.String("proposal-id")
+	if (pieceCidStr == "") == (proposalIDStr == "") {
+		return fmt.Errorf("exactly one of --piece-cid or --proposal-id must be provided")
+	}
+
+	client, err := newDealClient(cCtx)
+	if err != nil {
+		return err
+	}
+
+	var pieceCID []byte
+	if pieceCidStr != "" {
+		c, err := cid.Decode(pieceCidStr)
+		if err != nil {
+			return fmt.Errorf("failed to decode piece CID: %w", err)
+		}
+		pieceCID = c.Bytes()
+	} else {
+		proposalID, err := parseProposalID(proposalIDStr)
+		if err != nil {
+			return err
+		}
+		deal, ok, err := client.GetDealRequest(cCtx.Context, proposalID)
+		if err != nil {
+			return fmt.Errorf("failed to get deal request: %w", err)
+		}
+		if !ok {
+			return fmt.Errorf("proposal %s is not known to the contract", proposalID.Hex())
+		}
+		pieceCID = deal.PieceCID
+	}
+
+	info, err := client.GetPieceInfo(cCtx.Context, pieceCID)
+	if err != nil {
+		return fmt.Errorf("failed to get piece info: %w", err)
+	}
+
+	fmt.Printf("Piece CID:   %s\n", formatPieceCID(info.PieceCID))
+	fmt.Printf("Status:      %s\n", info.Status)
+	if info.DealID != 0 {
+		fmt.Printf("Deal ID:     %d\n", info.DealID)
+	} else {
+		fmt.Printf("Deal ID:     -\n")
+	}
+	if info.HasProvider {
+		fmt.Printf("Provider:    %s\n", formatFilAddress(info.Provider))
+	} else {
+		fmt.Printf("Provider:    -\n")
+	}
+	if !info.HasProposalID {
+		fmt.Printf("Proposal ID: -\n")
+		return nil
+	}
+	fmt.Printf("Proposal ID: %s\n", info.ProposalID.Hex())
+
+	deal, ok, err := client.GetDealRequest(cCtx.Context, info.ProposalID)
+	if err != nil {
+		return fmt.Errorf("failed to get deal request: %w", err)
+	}
+	if ok {
+		fmt.Printf("Label:       %s\n", deal.Label)
+		fmt.Printf("Piece size:  %d\n", deal.PieceSize)
+		fmt.Printf("Verified:    %t\n", deal.VerifiedDeal)
+		fmt.Printf("Start epoch: %d\n", deal.StartEpoch)
+		fmt.Printf("End epoch:   %d\n", deal.EndEpoch)
+	}
+
+	return nil
+}
+
+// parseProposalID parses a hex-encoded 32-byte proposal ID
+func parseProposalID(s string) (common.Hash, error) {
+	b, err := hexutil.Decode(s)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to decode proposal ID: %w", err)
+	}
+	if len(b) != common.HashLength {
+		return common.Hash{}, fmt.Errorf("proposal ID must be %d bytes, got %d", common.HashLength, len(b))
+	}
+	return common.BytesToHash(b), nil
+}
+
+// formatPieceCID renders piece CID bytes as a CID string, falling back to hex
+func formatPieceCID(b []byte) string {
+	c, err := cid.Cast(b)
+	if err != nil {
+		return hexutil.Encode(b)
+	}
+	return c.String()
+}
@@ -0,0 +1,346 @@
+package commands
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eastore-project/eastore/pkg/dealdb"
+	"github.com/eastore-project/eastore/pkg/tracker"
+	"github.com/eastore-project/eastore/pkg/types"
+	dealutils "github.com/eastore-project/fildeal/src/deal/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/urfave/cli/v2"
+)
+
+// DealsCommand returns the CLI command for inspecting the local deal database
+func DealsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "deals",
+		Usage: "Inspect deals recorded in the local deal database",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List recorded deals",
+				Action: dealsListAction,
+			},
+			{
+				Name:      "show",
+				Usage:     "Show a recorded deal",
+				ArgsUsage: "<id>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Print the deal as JSON (default: false)",
+					},
+				},
+				Action: dealsShowAction,
+			},
+			{
+				Name:  "export",
+				Usage: "Export all recorded deals",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "Export format (json or csv)",
+						Value: "json",
+					},
+					&cli.StringFlag{
+						Name:  "output",
+						Usage: "Output file path (default: stdout)",
+					},
+				},
+				Action: dealsExportAction,
+			},
+			{
+				Name:  "sync",
+				Usage: "Reconcile recorded deals with their on-chain state",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "update-activation",
+						Usage: "send an updateActivationStatus transaction for each published piece that is not active yet; this costs gas on every sync (default: false)",
+					},
+					&cli.Uint64Flag{
+						Name:  "confirmations",
+						Usage: "number of block confirmations to wait for on updateActivationStatus transactions (default: 1)",
+						Value: DefaultConfirmations,
+					},
+					&cli.BoolFlag{
+						Name:  "watch",
+						Usage: "keep running and sync again every --interval (default: false)",
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "time between syncs in --watch mode (default: 10m)",
+						Value: DefaultSyncInterval,
+					},
+				},
+				Action: dealsSyncAction,
+			},
+		},
+	}
+}
+
+// DefaultSyncInterval is the time between syncs in deals sync --watch mode
+const DefaultSyncInterval = 10 * time.Minute
+
+// newDealRecord creates the local record of a submitted deal
+func newDealRecord(inputPath string, prep *dealutils.DataPrepResult, deal types.DealRequest, txHash common.Hash) *dealdb.Deal {
+	return &dealdb.Deal{
+		InputPath:            inputPath,
+		PayloadCID:           prep.PayloadCid,
+		PieceCID:             prep.PieceCid,
+		PieceSize:            prep.PieceSize,
+		CarSize:              prep.CarSize,
+		BufferURL:            prep.BufferInfo.URL,
+		VerifiedDeal:         deal.VerifiedDeal,
+		StartEpoch:           deal.StartEpoch,
+		EndEpoch:             deal.EndEpoch,
+		StoragePricePerEpoch: deal.StoragePricePerEpoch.String(),
+		ProviderCollateral:   deal.ProviderCollateral.String(),
+		ClientCollateral:     deal.ClientCollateral.String(),
+		TxHash:               txHash.Hex(),
+	}
+}
+
+// recordProposal stores the proposal ID a mined transaction assigned to a recorded deal
+func recordProposal(db *dealdb.DB, id uint64, proposalID common.Hash) error {
+	err := db.Update(id, func(deal *dealdb.Deal) error {
+		deal.ProposalID = proposalID.Hex()
+		deal.Status = types.StatusRequestSubmitted.String()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record proposal ID: %w", err)
+	}
+	return nil
+}
+
+func dealsListAction(cCtx *cli.Context) error {
+	db, err := openDealDB(cCtx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	deals, err := db.List()
+	if err != nil {
+		return err
+	}
+	if len(deals) == 0 {
+		fmt.Println("No deals recorded")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED\tINPUT\tPIECE CID\tSTATE\tSTATUS\tPROPOSAL ID")
+	for _, deal := range deals {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			deal.ID,
+			deal.CreatedAt.Format("2006-01-02 15:04"),
+			deal.InputPath,
+			deal.PieceCID,
+			dealStateLabel(deal),
+			valueOrDash(deal.Status),
+			valueOrDash(deal.ProposalID),
+		)
+	}
+	return w.Flush()
+}
+
+func dealsShowAction(cCtx *cli.Context) error {
+	id, err := strconv.ParseUint(cCtx.Args().First(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid deal ID %q", cCtx.Args().First())
+	}
+
+	db, err := openDealDB(cCtx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	deal, err := db.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if cCtx.Bool("json") {
+		return printJSON(deal)
+	}
+
+	rows := [][2]string{}
+	for i, value := range dealFields(deal) {
+		rows = append(rows, [2]string{dealFieldNames[i], valueOrDash(value)})
+	}
+	return printTable(rows)
+}
+
+func dealsExportAction(cCtx *cli.Context) error {
+	db, err := openDealDB(cCtx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	deals, err := db.List()
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if path := cCtx.String("output"); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch cCtx.String("format") {
+	case "json":
+		if deals == nil {
+			deals = []*dealdb.Deal{}
+		}
+		return writeJSON(out, deals)
+	case "csv":
+		w := csv.NewWriter(out)
+		if err := w.Write(dealFieldNames); err != nil {
+			return err
+		}
+		for _, deal := range deals {
+			if err := w.Write(dealFields(deal)); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		return fmt.Errorf("unsupported export format %q", cCtx.String("format"))
+	}
+}
+
+// dealStateLabel returns the state of a deal for listings, noting an erasure
+func dealStateLabel(deal *dealdb.Deal) string {
+	if deal.Erased() {
+		return string(deal.State) + " (erased)"
+	}
+	return string(deal.State)
+}
+
+// dealFieldNames are the column names used when printing or exporting deals
+var dealFieldNames = []string{
+	"ID", "Created", "Updated", "Input", "Payload CID", "Piece CID", "Piece size",
+	"CAR size", "Buffer URL", "Key ref", "Manifest", "Verified deal", "Start epoch", "End epoch",
+	"Storage price per epoch", "Provider collateral", "Client collateral",
+	"Tx hash", "Proposal ID", "Status", "State", "State note", "Deal ID",
+	"Provider", "Last synced", "Erased", "Erasure record",
+}
+
+// dealFields returns the values of a deal in the order of dealFieldNames
+func dealFields(deal *dealdb.Deal) []string {
+	return []string{
+		strconv.FormatUint(deal.ID, 10),
+		formatTime(deal.CreatedAt),
+		formatTime(deal.UpdatedAt),
+		deal.InputPath,
+		deal.PayloadCID,
+		deal.PieceCID,
+		strconv.FormatUint(deal.PieceSize, 10),
+		strconv.FormatUint(deal.CarSize, 10),
+		deal.BufferURL,
+		deal.KeyRef,
+		deal.Manifest,
+		strconv.FormatBool(deal.VerifiedDeal),
+		strconv.FormatInt(deal.StartEpoch, 10),
+		strconv.FormatInt(deal.EndEpoch, 10),
+		deal.StoragePricePerEpoch,
+		deal.ProviderCollateral,
+		deal.ClientCollateral,
+		deal.TxHash,
+		deal.ProposalID,
+		deal.Status,
+		string(deal.State),
+		deal.StateNote,
+		formatDealID(deal.DealID),
+		deal.Provider,
+		formatTime(deal.LastSyncedAt),
+		formatTime(deal.ErasedAt),
+		deal.ErasureRecord,
+	}
+}
+
+func dealsSyncAction(cCtx *cli.Context) error {
+	client, err := newDealClient(cCtx)
+	if err != nil {
+		return err
+	}
+
+	// The database is opened for each read and write of a sync, so that deals can be
+	// made and shredded while deals sync --watch runs
+	openDB := func() (*dealdb.DB, error) { return openDealDB(cCtx) }
+	t := tracker.New(client, openDB, tracker.Options{
+		UpdateActivation: cCtx.Bool("update-activation"),
+		Confirmations:    cCtx.Uint64("confirmations"),
+	})
+
+	if !cCtx.Bool("watch") {
+		transitions, err := t.Sync(cCtx.Context)
+		printTransitions(transitions)
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(cCtx.Context, os.Interrupt)
+	defer stop()
+
+	err = t.Watch(ctx, cCtx.Duration("interval"), func(transitions []tracker.Transition, err error) {
+		printTransitions(transitions)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sync failed: %v\n", err)
+		}
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func printTransitions(transitions []tracker.Transition) {
+	for _, tr := range transitions {
+		if tr.Note != "" {
+			fmt.Printf("Deal %d: %s -> %s (%s)\n", tr.ID, tr.From, tr.To, tr.Note)
+		} else {
+			fmt.Printf("Deal %d: %s -> %s\n", tr.ID, tr.From, tr.To)
+		}
+	}
+	fmt.Printf("%s: %d deal(s) changed state\n", time.Now().Format("2006-01-02 15:04:05"), len(transitions))
+}
+
+func formatDealID(id uint64) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatUint(id, 10)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02T15:04:05Z07:00")
+}
+
+func valueOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -30,10 +30,26 @@ func DecryptCommand() *cli.Command {
 				EnvVars: []string{"OUT_DIR"},
 			},
 			&cli.StringFlag{
-				Name:     "key",
-				Required: true,
-				Usage:    "Hex-encoded derived key for decryption",
-				EnvVars:  []string{"DECRYPT_KEY"},
+				Name:    "key",
+				Usage:   "Hex-encoded derived key, or a directory key from share-key, for decryption (if not provided, the key is looked up in the key vault or re-derived from --private-key or the passphrase)",
+				EnvVars: []string{"DECRYPT_KEY"},
+			},
+			&cli.StringFlag{
+				Name:  "keys",
+				Usage: "Key manifest of a file encrypted with --detached-keys (default: <input>.keys.json, or the manifest recorded in the key vault)",
+			},
+			&cli.StringFlag{
+				Name:  "manifest",
+				Usage: "Manifest of an encrypted folder, needed to restore obfuscated names (default: <input>.manifest.json)",
+			},
+			passphraseFileFlag(),
+			&cli.Int64Flag{
+				Name:  "offset",
+				Usage: "Decrypt only the plaintext starting at this byte offset, reading just the part of the file that holds it",
+			},
+			&cli.Int64Flag{
+				Name:  "length",
+				Usage: "Number of plaintext bytes to decrypt from --offset (default: to the end)",
 			},
 		},
 		Action: decryptAction,
@@ -44,15 +60,25 @@ func DecryptCommand() *cli.Command {
 func decryptAction(cCtx *cli.Context) error {
 	inputPath := cCtx.String("input")
 	outDir := cCtx.String("out-dir")
-	keyHex := cCtx.String("key")
 
-	// Support both raw hex and hex starting with 0x
-	keyHex = strings.TrimPrefix(keyHex, "0x")
+	info, err := os.Stat(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	ranged := cCtx.IsSet("offset") || cCtx.IsSet("length")
+	if info.IsDir() {
+		if ranged {
+			return fmt.Errorf("--offset and --length only apply to files")
+		}
+		return decryptDir(cCtx, inputPath, outDir)
+	}
+	if cCtx.Int64("offset") < 0 || cCtx.Int64("length") < 0 {
+		return fmt.Errorf("--offset and --length must not be negative")
+	}
 
-	// Decode the hex-encoded key
-	key, err := hex.DecodeString(keyHex)
+	key, err := decryptionKey(cCtx, inputPath)
 	if err != nil {
-		return fmt.Errorf("failed to decode hex key: %w", err)
+		return err
 	}
 
 	// Create output directory if it doesn't exist
@@ -60,22 +86,23 @@ func decryptAction(cCtx *cli.Context) error {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	// Read encrypted file
-	encryptedData, err := os.ReadFile(inputPath)
-	if err != nil {
-		return fmt.Errorf("failed to read encrypted file: %w", err)
-	}
-
 	// Decrypt the file with the provided key
-	decryptedData, err := encryption.DecryptDataWithKey(encryptedData, key)
-	if err != nil {
-		return fmt.Errorf("failed to decrypt data: %w", err)
-	}
-
-	// Write the decrypted file
 	outPath := filepath.Join(outDir, "decrypted_"+filepath.Base(inputPath))
-	if err := os.WriteFile(outPath, decryptedData, 0644); err != nil {
-		return fmt.Errorf("failed to write decrypted file: %w", err)
+	if ranged {
+		offset := cCtx.Int64("offset")
+		n, err := encryption.DecryptFileRange(inputPath, outPath, key, offset, cCtx.Int64("length"))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt file: %w", err)
+		}
+
+		fmt.Printf("Range decrypted successfully\n")
+		fmt.Printf("Encrypted file: %s\n", inputPath)
+		fmt.Printf("Range: bytes %d-%d (%d bytes)\n", offset, offset+n, n)
+		fmt.Printf("Decrypted file: %s\n", outPath)
+		return nil
+	}
+	if err := encryption.DecryptFile(inputPath, outPath, key); err != nil {
+		return fmt.Errorf("failed to decrypt file: %w", err)
 	}
 
 	fmt.Printf("File decrypted successfully\n")
@@ -84,3 +111,138 @@ func decryptAction(cCtx *cli.Context) error {
 
 	return nil
 }
+
+// decryptDir decrypts an encrypted folder, restoring the original names from its
+// manifest when one is available
+func decryptDir(cCtx *cli.Context, inputDir, outDir string) error {
+	unlocker, _, err := newUnlocker(cCtx)
+	if err != nil {
+		return err
+	}
+	if key := cCtx.String("key"); key != "" && unlocker.Scoped == nil {
+		return fmt.Errorf("--key for a folder must be a directory key from share-key, since its files each have their own key")
+	}
+
+	manifestPath := cCtx.String("manifest")
+	if manifestPath == "" {
+		if path := encryption.DirManifestPath(inputDir); fileExists(path) {
+			manifestPath = path
+		}
+	}
+
+	var manifest *encryption.DirManifest
+	if manifestPath != "" {
+		manifest, err = encryption.ReadDirManifest(manifestPath)
+	} else {
+		manifest, err = encryption.ManifestForDir(inputDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	outPath := filepath.Join(outDir, "decrypted_"+filepath.Base(filepath.Clean(inputDir)))
+	count, err := encryption.DecryptDir(inputDir, outPath, manifest, unlocker)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt folder: %w", err)
+	}
+
+	fmt.Printf("Folder decrypted successfully\n")
+	fmt.Printf("Encrypted folder: %s\n", inputDir)
+	fmt.Printf("Files: %d\n", count)
+	fmt.Printf("Decrypted folder: %s\n", outPath)
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// newUnlocker returns the unlocker for the wallet, or for the directory key given
+// with --key. Without --key, keys are first looked up in the key vault. The
+// passphrase is only asked for once a file needs it
+func newUnlocker(cCtx *cli.Context) (*encryption.Unlocker, *vaultLookup, error) {
+	unlocker := &encryption.Unlocker{
+		PrivateKey: cCtx.String("private-key"),
+		Passphrase: func() ([]byte, error) { return readPassphrase(cCtx, false) },
+	}
+	key := cCtx.String("key")
+	if key == "" {
+		vault := &vaultLookup{cCtx: cCtx}
+		unlocker.Known = vault.key
+		return unlocker, vault, nil
+	}
+	if encryption.IsScopedKey(key) {
+		scoped, err := encryption.ParseScopedKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		unlocker.Scoped = scoped
+	}
+	return unlocker, nil, nil
+}
+
+// decryptionKey returns the key given with --key or stored in the key vault, or
+// re-derives it from the wallet or the passphrase using the key-derivation parameters
+// recorded in the file's header
+func decryptionKey(cCtx *cli.Context, inputPath string) ([]byte, error) {
+	unlocker, vault, err := newUnlocker(cCtx)
+	if err != nil {
+		return nil, err
+	}
+	if keyHex := cCtx.String("key"); keyHex != "" && unlocker.Scoped == nil {
+		// Support both raw hex and hex starting with 0x
+		key, err := hex.DecodeString(strings.TrimPrefix(keyHex, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode hex key: %w", err)
+		}
+		return key, nil
+	}
+
+	header, _, err := readFileHeader(inputPath, cCtx.String("keys"), vault)
+	if err != nil {
+		return nil, err
+	}
+	key, err := unlocker.Key(header)
+	if err != nil {
+		if vault != nil && vault.err != nil {
+			return nil, fmt.Errorf("failed to derive decryption key: %w (the key vault could not be opened: %v)", err, vault.err)
+		}
+		return nil, fmt.Errorf("failed to derive decryption key: %w", err)
+	}
+	return key, nil
+}
+
+// readFileHeader reads the header of an encrypted file. If the file's keys are
+// detached, they are loaded from the key manifest, which defaults to the path next
+// to the file or else the one recorded for it in the key vault, if vault is given;
+// its path is returned
+func readFileHeader(inputPath, manifestPath string, vault *vaultLookup) (*encryption.Header, string, error) {
+	header, err := encryption.ReadFileHeader(inputPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read encrypted file header: %w", err)
+	}
+	if header.KDF != encryption.KDFEnvelope || (len(header.Recipients) > 0 && manifestPath == "") {
+		return header, "", nil
+	}
+
+	if manifestPath == "" {
+		manifestPath = encryption.KeyManifestPath(inputPath)
+		if !fileExists(manifestPath) {
+			// make-deal keeps the manifest in the repo and records it in the vault
+			e := vault.entry(header.FileID())
+			if e == nil || e.KeyManifest == "" {
+				return nil, "", fmt.Errorf("%w: pass --keys", encryption.ErrDetachedKeys)
+			}
+			manifestPath = e.KeyManifest
+		}
+	}
+	manifest, err := encryption.ReadKeyManifest(manifestPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := manifest.Apply(header); err != nil {
+		return nil, "", err
+	}
+	return header, manifestPath, nil
+}
@@ -1,6 +1,8 @@
 package commands
 
 import (
+	"crypto/ecdsa"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -13,8 +15,8 @@ import (
 func EncryptCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "encrypt",
-		Usage: "Encrypt a file using AES with a key derived from wallet signature",
-		Flags: []cli.Flag{
+		Usage: "Encrypt a file using AES with a key derived from wallet signature or a passphrase, or to a set of recipients",
+		Flags: append([]cli.Flag{
 			&cli.StringFlag{
 				Name:     "input",
 				Required: true,
@@ -27,7 +29,17 @@ func EncryptCommand() *cli.Command {
 				Value:   "./encrypted",
 				EnvVars: []string{"OUT_DIR"},
 			},
-		},
+			&cli.BoolFlag{
+				Name:  "detached-keys",
+				Usage: "Write the wrapped keys of --recipient encryption to a key manifest next to the file instead of its header, so recipients can change without altering the file",
+			},
+			&cli.BoolFlag{
+				Name:  "obfuscate-names",
+				Usage: "When encrypting a folder, replace file and folder names with random names (the originals are kept in the manifest)",
+			},
+			compressionFlag(),
+			vaultFlag(),
+		}, append(append(recipientFlags(), signingFlags()...), passphraseFlags()...)...),
 		Action: encryptAction,
 	}
 }
@@ -37,21 +49,123 @@ func encryptAction(cCtx *cli.Context) error {
 	outDir := cCtx.String("out-dir")
 	privateKey := cCtx.String("private-key")
 
-	// Use the EncryptFile function
-	encryptedData, hexKey, err := encryption.EncryptFile(inputPath, privateKey)
-	if err != nil {
-		return fmt.Errorf("failed to encrypt file: %w", err)
-	}
-
 	// Create output directory if it doesn't exist
 	if err := os.MkdirAll(outDir, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	// Write the encrypted file
+	compression, err := parseCompressionFlag(cCtx)
+	if err != nil {
+		return err
+	}
+
+	keys := newVaultKeys(cCtx)
+	var recipients []*ecdsa.PublicKey
+	var passphrase []byte
+	if cCtx.Bool("passphrase") {
+		if len(cCtx.StringSlice("recipient")) > 0 {
+			return fmt.Errorf("--passphrase cannot be combined with --recipient")
+		}
+		if passphrase, err = readPassphrase(cCtx, true); err != nil {
+			return err
+		}
+	} else {
+		if recipients, err = resolveRecipients(cCtx); err != nil {
+			return err
+		}
+		// Without recipients, the key is derived from the wallet
+		if recipients == nil {
+			if _, err := requirePrivateKey(cCtx); err != nil {
+				return err
+			}
+		}
+	}
+
+	info, err := os.Stat(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	if info.IsDir() {
+		if cCtx.Bool("detached-keys") {
+			return fmt.Errorf("--detached-keys is not supported for folders")
+		}
+		encryptedDir := filepath.Join(outDir, "encrypted_"+filepath.Base(filepath.Clean(inputPath)))
+		manifest, manifestPath, err := encryptDir(inputPath, encryptedDir, privateKey, encryption.DirOptions{
+			ObfuscateNames: cCtx.Bool("obfuscate-names"),
+			Recipients:     recipients,
+			Passphrase:     passphrase,
+			ScryptParams:   encryption.DefaultScryptParams,
+			Compression:    compression,
+			OnFile: func(inputPath, encryptedPath string, key []byte) error {
+				keys.add(inputPath, encryptedPath, key)
+				return nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Folder encrypted successfully\n")
+		fmt.Printf("Original folder: %s\n", inputPath)
+		fmt.Printf("Files: %d\n", len(manifest.Files))
+		fmt.Printf("Encrypted folder: %s\n", encryptedDir)
+		fmt.Printf("Manifest: %s\n", manifestPath)
+		keys.store(cCtx)
+		return printSizeStats(inputPath, encryptedDir)
+	}
+
+	// Encrypt the file straight into the output directory
 	encryptedFilePath := filepath.Join(outDir, "encrypted_"+filepath.Base(inputPath))
-	if err := os.WriteFile(encryptedFilePath, encryptedData, 0644); err != nil {
-		return fmt.Errorf("failed to write encrypted file: %w", err)
+	if recipients != nil {
+		manifestPath := ""
+		var key []byte
+		if cCtx.Bool("detached-keys") {
+			manifestPath = encryption.KeyManifestPath(encryptedFilePath)
+			key, err = encryption.EncryptFileDetached(inputPath, encryptedFilePath, manifestPath, recipients, compression)
+		} else {
+			key, err = encryption.EncryptFileTo(inputPath, encryptedFilePath, recipients, compression)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to encrypt file: %w", err)
+		}
+
+		fmt.Printf("File encrypted successfully\n")
+		fmt.Printf("Original file: %s\n", inputPath)
+		fmt.Printf("Recipients: %d\n", len(recipients))
+		fmt.Printf("Encrypted file: %s\n", encryptedFilePath)
+		if manifestPath != "" {
+			fmt.Printf("Key manifest: %s\n", manifestPath)
+		}
+		keys.add(inputPath, encryptedFilePath, key)
+		keys.store(cCtx)
+		return printSizeStats(inputPath, encryptedFilePath)
+	}
+	if cCtx.Bool("detached-keys") {
+		return fmt.Errorf("--detached-keys requires --recipient")
+	}
+
+	if passphrase != nil {
+		key, err := encryption.EncryptFileWithPassphrase(inputPath, encryptedFilePath, passphrase, encryption.DefaultScryptParams, compression)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt file: %w", err)
+		}
+
+		fmt.Printf("File encrypted successfully with a passphrase\n")
+		fmt.Printf("Original file: %s\n", inputPath)
+		fmt.Printf("Derived key: %x\n", key)
+		fmt.Printf("Encrypted file: %s\n", encryptedFilePath)
+		keys.add(inputPath, encryptedFilePath, key)
+		keys.store(cCtx)
+		return printSizeStats(inputPath, encryptedFilePath)
+	}
+
+	signing, err := keySigning(cCtx)
+	if err != nil {
+		return err
+	}
+	hexKey, err := encryption.EncryptFile(inputPath, encryptedFilePath, privateKey, signing, compression)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt file: %w", err)
 	}
 
 	fmt.Printf("File encrypted successfully\n")
@@ -59,5 +173,26 @@ func encryptAction(cCtx *cli.Context) error {
 	fmt.Printf("Derived key: %s\n", hexKey)
 	fmt.Printf("Encrypted file: %s\n", encryptedFilePath)
 
-	return nil
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode derived key: %w", err)
+	}
+	keys.add(inputPath, encryptedFilePath, key)
+	keys.store(cCtx)
+	return printSizeStats(inputPath, encryptedFilePath)
+}
+
+// encryptDir encrypts a folder into encryptedDir and writes its manifest next to it,
+// outside the encrypted tree. Returns the manifest and its path
+func encryptDir(inputDir, encryptedDir, privateKey string, opts encryption.DirOptions) (*encryption.DirManifest, string, error) {
+	manifest, err := encryption.EncryptDir(inputDir, encryptedDir, privateKey, opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encrypt folder: %w", err)
+	}
+
+	manifestPath := encryption.DirManifestPath(encryptedDir)
+	if err := encryption.WriteDirManifest(manifestPath, manifest); err != nil {
+		return nil, "", err
+	}
+	return manifest, manifestPath, nil
 }
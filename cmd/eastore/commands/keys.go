@@ -0,0 +1,562 @@
+package commands
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/eastore-project/eastore/pkg/encryption"
+	"github.com/eastore-project/eastore/pkg/keyvault"
+	"github.com/eastore-project/eastore/pkg/utils"
+	"github.com/urfave/cli/v2"
+)
+
+// KeysCommand returns the CLI command for managing the local key vault
+func KeysCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "keys",
+		Usage: "Manage the data keys stored in the local key vault",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "list",
+				Usage:  "List stored keys and the CIDs they are indexed by",
+				Action: keysListAction,
+			},
+			{
+				Name:      "export",
+				Usage:     "Export stored keys in plaintext JSON, all of them or those matching a CID, file ID or key reference",
+				ArgsUsage: "[id]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "output",
+						Usage: "Output file path (default: stdout)",
+					},
+				},
+				Action: keysExportAction,
+			},
+			{
+				Name:      "import",
+				Usage:     "Import keys from a JSON file written by keys export",
+				ArgsUsage: "<file>",
+				Action:    keysImportAction,
+			},
+			{
+				Name:      "delete",
+				Usage:     "Delete the keys matching a CID, file ID or key reference",
+				ArgsUsage: "<id>",
+				Action:    keysDeleteAction,
+			},
+			{
+				Name:      "split",
+				Usage:     "Split a key into M-of-N Shamir shares for custodians, given with --key or looked up in the vault by CID, file ID or key reference",
+				ArgsUsage: "[id]",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "key",
+						Usage: "Hex-encoded key to split instead of a key from the vault",
+					},
+					&cli.IntFlag{
+						Name:     "threshold",
+						Aliases:  []string{"m"},
+						Required: true,
+						Usage:    "Number of shares needed to recover the key",
+					},
+					&cli.IntFlag{
+						Name:     "shares",
+						Aliases:  []string{"n"},
+						Required: true,
+						Usage:    "Number of shares to create (at most 255)",
+					},
+					&cli.StringFlag{
+						Name:  "out-dir",
+						Usage: "Write each share to its own file in this directory instead of printing them",
+					},
+				},
+				Action: keysSplitAction,
+			},
+			{
+				Name:      "combine",
+				Usage:     "Recover a key from Shamir shares given as text or as files written by keys split",
+				ArgsUsage: "<share-or-file>...",
+				Action:    keysCombineAction,
+			},
+		},
+	}
+}
+
+// vaultFlag disables storing keys in the key vault
+func vaultFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:  "no-vault",
+		Usage: "Do not store the data keys in the local key vault",
+	}
+}
+
+func keysListAction(cCtx *cli.Context) error {
+	vault, err := openKeyVault(cCtx, false)
+	if err != nil {
+		return err
+	}
+	if vault == nil || len(vault.Entries()) == 0 {
+		fmt.Println("No keys stored")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY REF\tCREATED\tPATH\tPLAINTEXT CID\tPAYLOAD CID\tPIECE CID")
+	for _, e := range vault.Entries() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.KeyRef,
+			e.CreatedAt.Format("2006-01-02 15:04"),
+			valueOrDash(e.Path),
+			valueOrDash(e.PlaintextCID),
+			valueOrDash(e.PayloadCID),
+			valueOrDash(e.PieceCID),
+		)
+	}
+	return w.Flush()
+}
+
+func keysExportAction(cCtx *cli.Context) error {
+	vault, err := openKeyVault(cCtx, false)
+	if err != nil {
+		return err
+	}
+
+	entries := []*keyvault.Entry{}
+	if vault != nil {
+		entries = vault.Entries()
+	}
+	if id := cCtx.Args().First(); id != "" {
+		if vault == nil {
+			return keyvault.ErrNotFound
+		}
+		if entries = vault.Lookup(id); len(entries) == 0 {
+			return fmt.Errorf("%w: %s", keyvault.ErrNotFound, id)
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if path := cCtx.String("output"); path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+func keysImportAction(cCtx *cli.Context) error {
+	path := cCtx.Args().First()
+	if path == "" {
+		return fmt.Errorf("missing file to import")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read keys: %w", err)
+	}
+	var entries []*keyvault.Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse keys: %w", err)
+	}
+
+	vault, err := openKeyVault(cCtx, true)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := vault.Put(e); err != nil {
+			return err
+		}
+	}
+	if err := vault.Save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d keys into %s\n", len(entries), vault.Path())
+	return nil
+}
+
+func keysDeleteAction(cCtx *cli.Context) error {
+	id := cCtx.Args().First()
+	if id == "" {
+		return fmt.Errorf("missing CID, file ID or key reference of the keys to delete")
+	}
+
+	vault, err := openKeyVault(cCtx, false)
+	if err != nil {
+		return err
+	}
+	if vault == nil {
+		return fmt.Errorf("%w: %s", keyvault.ErrNotFound, id)
+	}
+	deleted := vault.Delete(id)
+	if len(deleted) == 0 {
+		return fmt.Errorf("%w: %s", keyvault.ErrNotFound, id)
+	}
+	if err := vault.Save(); err != nil {
+		return err
+	}
+
+	for _, e := range deleted {
+		fmt.Printf("Deleted key %s (%s)\n", e.KeyRef, valueOrDash(e.Path))
+	}
+	return nil
+}
+
+func keysSplitAction(cCtx *cli.Context) error {
+	key, err := keyToSplit(cCtx)
+	if err != nil {
+		return err
+	}
+	n, threshold := cCtx.Int("shares"), cCtx.Int("threshold")
+	shares, err := encryption.SplitKey(key, n, threshold)
+	if err != nil {
+		return fmt.Errorf("failed to split key: %w", err)
+	}
+	ref := encryption.KeyID(key)
+
+	outDir := cCtx.String("out-dir")
+	if outDir == "" {
+		fmt.Printf("Key %s split into %d shares, any %d of which recover it:\n", ref, n, threshold)
+		for _, s := range shares {
+			fmt.Println(s)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(outDir, 0700); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	for _, s := range shares {
+		path := filepath.Join(outDir, fmt.Sprintf("%s-share-%d-of-%d.txt", ref, s.Index, n))
+		content := fmt.Sprintf("# Eastore key share %d of %d for key %s; any %d shares recover the key\n%s\n", s.Index, n, ref, threshold, s)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			return fmt.Errorf("failed to write key share: %w", err)
+		}
+		fmt.Printf("Share %d: %s\n", s.Index, path)
+	}
+	fmt.Printf("Key %s split into %d shares, any %d of which recover it\n", ref, n, threshold)
+	return nil
+}
+
+// keyToSplit returns the key given with --key, or the single vault key matching the
+// argument
+func keyToSplit(cCtx *cli.Context) ([]byte, error) {
+	id := cCtx.Args().First()
+	if keyHex := cCtx.String("key"); keyHex != "" {
+		if id != "" {
+			return nil, fmt.Errorf("give either --key or the ID of a key in the vault, not both")
+		}
+		key, err := hex.DecodeString(strings.TrimPrefix(keyHex, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode hex key: %w", err)
+		}
+		return key, nil
+	}
+	if id == "" {
+		return nil, fmt.Errorf("missing key: give --key or the CID, file ID or key reference of a key in the vault")
+	}
+
+	vault, err := openKeyVault(cCtx, false)
+	if err != nil {
+		return nil, err
+	}
+	if vault == nil {
+		return nil, fmt.Errorf("%w: %s", keyvault.ErrNotFound, id)
+	}
+	entries := vault.Lookup(id)
+	switch len(entries) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", keyvault.ErrNotFound, id)
+	case 1:
+		return entries[0].Key, nil
+	}
+	var refs []string
+	for _, e := range entries {
+		refs = append(refs, e.KeyRef)
+	}
+	return nil, fmt.Errorf("%s matches %d keys (%s); give the key reference of one", id, len(entries), strings.Join(refs, ", "))
+}
+
+func keysCombineAction(cCtx *cli.Context) error {
+	if cCtx.NArg() == 0 {
+		return fmt.Errorf("missing key shares")
+	}
+
+	var shares []*encryption.KeyShare
+	for _, arg := range cCtx.Args().Slice() {
+		parsed, err := readKeyShares(arg)
+		if err != nil {
+			return err
+		}
+		shares = append(shares, parsed...)
+	}
+
+	key, err := encryption.CombineKey(shares)
+	if err != nil {
+		return fmt.Errorf("failed to combine key shares: %w", err)
+	}
+	fmt.Printf("Recovered key %s from %d shares\n", encryption.KeyID(key), len(shares))
+	fmt.Printf("Key: %x\n", key)
+	return nil
+}
+
+// readKeyShares parses a share given as text, or the shares in a file written by
+// keys split, skipping blank and # comment lines
+func readKeyShares(arg string) ([]*encryption.KeyShare, error) {
+	if !fileExists(arg) {
+		share, err := encryption.ParseKeyShare(arg)
+		if err != nil {
+			return nil, err
+		}
+		return []*encryption.KeyShare{share}, nil
+	}
+
+	data, err := os.ReadFile(arg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key shares: %w", err)
+	}
+	var shares []*encryption.KeyShare
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		share, err := encryption.ParseKeyShare(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", arg, err)
+		}
+		shares = append(shares, share)
+	}
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("%s holds no key shares", arg)
+	}
+	return shares, nil
+}
+
+// newVaultEntry returns the vault entry for the key of a file that was just encrypted
+// from inputPath into encryptedPath
+func newVaultEntry(inputPath, encryptedPath string, key []byte) (*keyvault.Entry, error) {
+	header, err := encryption.ReadFileHeader(encryptedPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encrypted file header: %w", err)
+	}
+
+	entry := keyvault.NewEntry(key)
+	entry.FileID = header.FileID()
+	entry.KDF = header.KDF.String()
+	if abs, err := filepath.Abs(inputPath); err == nil {
+		entry.Path = abs
+	}
+	// Without recipients in the header, the wrapped keys are in a key manifest
+	if header.KDF == encryption.KDFEnvelope && len(header.Recipients) == 0 {
+		if abs, err := filepath.Abs(encryption.KeyManifestPath(encryptedPath)); err == nil {
+			entry.KeyManifest = abs
+		}
+	}
+
+	// The key of a wallet-signature file was derived from its plaintext CID
+	if header.KDF == encryption.KDFWalletSignature {
+		entry.PlaintextCID = string(header.Context)
+	} else {
+		c, err := utils.CalculateFileCID(inputPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to calculate file CID: %w", err)
+		}
+		entry.PlaintextCID = c.String()
+	}
+	return entry, nil
+}
+
+// vaultKeys collects the keys of encrypted files to store them in the key vault,
+// unless --no-vault is set
+type vaultKeys struct {
+	disabled bool
+	entries  []*keyvault.Entry
+	// vault is the vault opened to link keys that are already stored, if any
+	vault *keyvault.Vault
+}
+
+func newVaultKeys(cCtx *cli.Context) *vaultKeys {
+	return &vaultKeys{disabled: cCtx.Bool("no-vault")}
+}
+
+// add records the key of a file that was just encrypted. As with store, a failure
+// is reported without failing the command
+func (v *vaultKeys) add(inputPath, encryptedPath string, key []byte) {
+	if v.disabled {
+		return
+	}
+	entry, err := newVaultEntry(inputPath, encryptedPath, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: the key of %s will not be stored in the key vault: %v\n", inputPath, err)
+		return
+	}
+	v.entries = append(v.entries, entry)
+}
+
+// link finds the stored keys of the Eastore files at inputPath, a file or a folder
+// of files that were encrypted earlier, so that the CIDs of the deal they are stored
+// in can be recorded with them. It returns the keys found; as with store, a failure
+// is reported without failing the command
+func (v *vaultKeys) link(cCtx *cli.Context, inputPath string) []*keyvault.Entry {
+	if v.disabled {
+		return nil
+	}
+	fileIDs, err := encryptedFileIDs(inputPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: the keys of %s will not be linked to the deal: %v\n", inputPath, err)
+		return nil
+	}
+	// Plain data has no keys, and the vault is not unlocked for it
+	if len(fileIDs) == 0 {
+		return nil
+	}
+
+	if v.vault == nil {
+		if v.vault, err = openKeyVault(cCtx, false); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: the keys of %s will not be linked to the deal: %v\n", inputPath, err)
+			return nil
+		}
+		if v.vault == nil {
+			return nil
+		}
+	}
+
+	var linked []*keyvault.Entry
+	for _, e := range v.vault.Entries() {
+		if e.FileID != "" && fileIDs[e.FileID] {
+			// A copy is stored, so that only the deal CIDs are merged into the entry
+			entry := *e
+			linked = append(linked, &entry)
+		}
+	}
+	v.entries = append(v.entries, linked...)
+	return linked
+}
+
+// encryptedFileIDs returns the file IDs of the Eastore files at path, a file or a
+// folder; other files, such as the manifest of an encrypted folder, are skipped
+func encryptedFileIDs(path string) (map[string]bool, error) {
+	fileIDs := map[string]bool{}
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		header, err := encryption.ReadFileHeader(p)
+		if err != nil {
+			// Files without a valid Eastore header hold no keys to link
+			return nil
+		}
+		fileIDs[header.FileID()] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return fileIDs, nil
+}
+
+// setKeyManifest records the path of the key manifest holding the wrapped keys of
+// the files
+func (v *vaultKeys) setKeyManifest(path string) {
+	for _, e := range v.entries {
+		e.KeyManifest = path
+	}
+}
+
+// setDeal records the payload and piece CIDs of the deal the files are stored in
+func (v *vaultKeys) setDeal(payloadCID, pieceCID string) {
+	for _, e := range v.entries {
+		e.PayloadCID = payloadCID
+		e.PieceCID = pieceCID
+	}
+}
+
+// store saves the collected keys to the key vault, creating it if needed. The data
+// is already encrypted at this point, so a failure is reported without failing the
+// command
+func (v *vaultKeys) store(cCtx *cli.Context) {
+	if v.disabled || len(v.entries) == 0 {
+		return
+	}
+	if err := v.save(cCtx); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: keys were not stored in the key vault: %v\n", err)
+	}
+}
+
+func (v *vaultKeys) save(cCtx *cli.Context) error {
+	vault := v.vault
+	if vault == nil {
+		var err error
+		if vault, err = openKeyVault(cCtx, true); err != nil {
+			return err
+		}
+	}
+	for _, e := range v.entries {
+		if err := vault.Put(e); err != nil {
+			return err
+		}
+	}
+	if err := vault.Save(); err != nil {
+		return err
+	}
+
+	if v.vault != nil {
+		fmt.Printf("Deal CIDs recorded for %d keys in vault: %s\n", len(v.entries), vault.Path())
+	} else if len(v.entries) == 1 {
+		fmt.Printf("Key stored in vault: %s\n", vault.Path())
+	} else {
+		fmt.Printf("%d keys stored in vault: %s\n", len(v.entries), vault.Path())
+	}
+	return nil
+}
+
+// vaultLookup finds the keys of encrypted files in the key vault, which is opened the
+// first time a key is looked up
+type vaultLookup struct {
+	cCtx   *cli.Context
+	vault  *keyvault.Vault
+	opened bool
+	// err is the error opening the vault, if any
+	err error
+}
+
+// key returns the key stored for the file with the given header, or nil
+func (l *vaultLookup) key(header *encryption.Header) []byte {
+	if e := l.entry(header.FileID()); e != nil {
+		return e.Key
+	}
+	return nil
+}
+
+// entry returns the vault entry of the file with the given ID, or nil
+func (l *vaultLookup) entry(fileID string) *keyvault.Entry {
+	if l == nil {
+		return nil
+	}
+	if !l.opened {
+		l.opened = true
+		l.vault, l.err = openKeyVault(l.cCtx, false)
+	}
+	if l.vault == nil {
+		return nil
+	}
+	for _, e := range l.vault.Lookup(fileID) {
+		if e.FileID == fileID {
+			return e
+		}
+	}
+	return nil
+}
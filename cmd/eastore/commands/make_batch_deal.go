@@ -0,0 +1,252 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eastore-project/eastore/pkg/contract"
+	"github.com/eastore-project/eastore/pkg/types"
+	dealutils "github.com/eastore-project/fildeal/src/deal/utils"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	DefaultBatchSize   = 20
+	DefaultMaxBatchGas = 5_000_000_000
+)
+
+// MakeBatchDealCommand returns the CLI command for making deals for many inputs in batches
+func MakeBatchDealCommand() *cli.Command {
+	flags := []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "input",
+			Usage:   "Input file or folder path (can be repeated)",
+			EnvVars: []string{"INPUT_PATHS"},
+		},
+		&cli.StringFlag{
+			Name:    "input-dir",
+			Usage:   "Directory whose top-level entries are each submitted as a separate deal; hidden entries, whose names start with a dot, are skipped",
+			EnvVars: []string{"INPUT_DIR"},
+		},
+		&cli.IntFlag{
+			Name:    "batch-size",
+			Usage:   "maximum number of deals submitted in a single transaction (default: 20)",
+			Value:   DefaultBatchSize,
+			EnvVars: []string{"BATCH_SIZE"},
+		},
+		&cli.Uint64Flag{
+			Name:    "max-batch-gas",
+			Usage:   "maximum estimated gas for a single batch transaction; larger batches are split (default: 5000000000)",
+			Value:   DefaultMaxBatchGas,
+			EnvVars: []string{"MAX_BATCH_GAS"},
+		},
+	}
+	flags = append(flags, dealFlags()...)
+	flags = append(flags, vaultFlag())
+	flags = append(flags,
+		&cli.Uint64Flag{
+			Name:    "confirmations",
+			Usage:   "number of block confirmations to wait for on each batch (default: 1)",
+			Value:   DefaultConfirmations,
+			EnvVars: []string{"CONFIRMATIONS"},
+		},
+		&cli.DurationFlag{
+			Name:    "wait-timeout",
+			Usage:   "how long to wait for each batch transaction (default: 30m)",
+			Value:   DefaultWaitTimeout,
+			EnvVars: []string{"WAIT_TIMEOUT"},
+		},
+	)
+
+	return &cli.Command{
+		Name:   "make-batch-deal",
+		Usage:  "Submit deal proposals for many inputs in batched transactions",
+		Flags:  flags,
+		Action: makeBatchDealAction,
+	}
+}
+
+// batchEntry links a prepared input to the deal request made for it
+type batchEntry struct {
+	input    string
+	prep     *dealutils.DataPrepResult
+	deal     types.DealRequest
+	keyRef   string
+	recordID uint64
+}
+
+func makeBatchDealAction(cCtx *cli.Context) error {
+	inputs, err := batchInputs(cCtx)
+	if err != nil {
+		return err
+	}
+	if len(inputs) == 0 {
+		return fmt.Errorf("no inputs given, use --input or --input-dir")
+	}
+
+	batchSize := cCtx.Int("batch-size")
+	if batchSize < 1 {
+		return fmt.Errorf("batch size must be at least 1")
+	}
+
+	outDir := cCtx.String("outdir")
+	if outDir == "" {
+		outDir, err = os.MkdirTemp("", "eastore-deal-*")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		defer os.RemoveAll(outDir)
+	} else {
+		if err := os.MkdirAll(outDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	params, err := parseDealParams(cCtx)
+	if err != nil {
+		return err
+	}
+
+	// Prepare every input before sending anything so that a bad input does
+	// not leave a partially submitted set of batches behind
+	entries := make([]batchEntry, 0, len(inputs))
+	keys := newVaultKeys(cCtx)
+	for i, input := range inputs {
+		prepResult, err := prepareData(cCtx, input, outDir, true)
+		if err != nil {
+			return fmt.Errorf("failed to prepare data for %s: %w", input, err)
+		}
+
+		// Inputs that were encrypted earlier are linked to their deals through
+		// their stored keys
+		var keyRef string
+		linked := keys.link(cCtx, input)
+		for _, e := range linked {
+			e.PayloadCID = prepResult.PayloadCid
+			e.PieceCID = prepResult.PieceCid
+		}
+		if len(linked) == 1 {
+			keyRef = linked[0].KeyRef
+		}
+
+		deal, err := params.dealRequest(prepResult)
+		if err != nil {
+			return fmt.Errorf("failed to create deal request for %s: %w", input, err)
+		}
+
+		fmt.Printf("[%d/%d] Prepared %s (piece CID: %s, piece size: %d)\n",
+			i+1, len(inputs), input, prepResult.PieceCid, prepResult.PieceSize)
+		entries = append(entries, batchEntry{input: input, prep: prepResult, deal: deal, keyRef: keyRef})
+	}
+	keys.store(cCtx)
+
+	client, err := newDealClient(cCtx)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDealDB(cCtx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for len(entries) > 0 {
+		n, err := nextBatchSize(cCtx.Context, client, entries, batchSize, cCtx.Uint64("max-batch-gas"))
+		if err != nil {
+			return err
+		}
+		batch := entries[:n]
+		entries = entries[n:]
+
+		deals := make([]types.DealRequest, len(batch))
+		for i, entry := range batch {
+			deals[i] = entry.deal
+		}
+
+		txHash, err := client.MakeBatchDealProposal(cCtx.Context, deals)
+		if err != nil {
+			return fmt.Errorf("failed to make batch deal proposal: %w", err)
+		}
+		fmt.Printf("Batch of %d deal proposals submitted in transaction: %s\n", len(batch), txHash.Hex())
+
+		for i := range batch {
+			record := newDealRecord(batch[i].input, batch[i].prep, batch[i].deal, txHash)
+			record.KeyRef = batch[i].keyRef
+			if err := db.Add(record); err != nil {
+				return fmt.Errorf("failed to record deal for %s: %w", batch[i].input, err)
+			}
+			batch[i].recordID = record.ID
+		}
+
+		ctx, cancel := context.WithTimeout(cCtx.Context, cCtx.Duration("wait-timeout"))
+		result, err := client.WaitForProposals(ctx, txHash, cCtx.Uint64("confirmations"))
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to wait for transaction %s: %w", txHash.Hex(), err)
+		}
+		if len(result.Proposals) != len(batch) {
+			return fmt.Errorf("expected %d proposal IDs in transaction %s, got %d", len(batch), txHash.Hex(), len(result.Proposals))
+		}
+
+		fmt.Printf("Batch included in block %d (gas used: %d)\n", result.BlockNumber, result.GasUsed)
+		for i, entry := range batch {
+			fmt.Printf("  %s: proposal ID %s (local ID %d)\n", entry.input, result.Proposals[i].ID.Hex(), entry.recordID)
+			if err := recordProposal(db, entry.recordID, result.Proposals[i].ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// batchInputs collects the input paths from the --input and --input-dir flags,
+// skipping the hidden entries of --input-dir
+func batchInputs(cCtx *cli.Context) ([]string, error) {
+	inputs := cCtx.StringSlice("input")
+
+	if inputDir := cCtx.String("input-dir"); inputDir != "" {
+		dirEntries, err := os.ReadDir(inputDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input directory: %w", err)
+		}
+		for _, entry := range dirEntries {
+			// Hidden entries, such as .DS_Store, are not data to store
+			if strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			inputs = append(inputs, filepath.Join(inputDir, entry.Name()))
+		}
+	}
+
+	return inputs, nil
+}
+
+// nextBatchSize returns how many of the pending entries fit in the next
+// transaction, halving the batch until its gas estimate is within maxGas
+func nextBatchSize(ctx context.Context, client *contract.DealClient, entries []batchEntry, batchSize int, maxGas uint64) (int, error) {
+	n := min(batchSize, len(entries))
+
+	for {
+		deals := make([]types.DealRequest, n)
+		for i := range deals {
+			deals[i] = entries[i].deal
+		}
+
+		gas, err := client.EstimateBatchDealProposalGas(ctx, deals)
+		if err != nil {
+			return 0, fmt.Errorf("failed to estimate gas for batch of %d deals: %w", n, err)
+		}
+		if maxGas == 0 || gas <= maxGas {
+			return n, nil
+		}
+		if n == 1 {
+			return 0, fmt.Errorf("deal for %s needs %d gas, above the batch limit of %d", entries[0].input, gas, maxGas)
+		}
+		n /= 2
+	}
+}
@@ -1,17 +1,24 @@
 package commands
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
 	"fmt"
 	"math/big"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/eastore-project/eastore/pkg/chain"
 	"github.com/eastore-project/eastore/pkg/contract"
+	"github.com/eastore-project/eastore/pkg/dataprep"
 	"github.com/eastore-project/eastore/pkg/encryption"
 	"github.com/eastore-project/eastore/pkg/types"
+	"github.com/eastore-project/eastore/pkg/utils"
 	"github.com/eastore-project/fildeal/src/buffer"
 	dealutils "github.com/eastore-project/fildeal/src/deal/utils"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ipfs/go-cid"
 	"github.com/urfave/cli/v2"
 )
@@ -26,121 +33,200 @@ const (
 	DefaultRemoveUnsealed       = false
 	DefaultVerifiedDeal         = true
 	DefaultEncrypted            = false
+	DefaultConfirmations        = 1
+	DefaultWaitTimeout          = 30 * time.Minute
 )
 
 // MakeDealCommand returns the CLI command for making a new deal
 func MakeDealCommand() *cli.Command {
-	return &cli.Command{
-		Name:  "make-deal",
-		Usage: "Submit a new deal proposal",
-		Flags: []cli.Flag{
-			&cli.StringFlag{
-				Name:     "input",
-				Required: true,
-				Usage:    "Input file or folder path",
-				EnvVars:  []string{"INPUT_PATH"},
-			},
-			&cli.StringFlag{
-				Name:    "outdir",
-				Usage:   "Output directory for CAR files (if not provided, uses temp dir and cleans up after)",
-				EnvVars: []string{"OUT_DIR"},
-			},
-			&cli.StringFlag{
-				Name:    "buffer-type",
-				Usage:   "Buffer type (lighthouse or local)",
-				Value:   "local",
-				EnvVars: []string{"BUFFER_TYPE"},
-			},
-			&cli.StringFlag{
-				Name:    "buffer-api-key",
-				Usage:   "Buffer service API key",
-				EnvVars: []string{"BUFFER_API_KEY"},
-			},
-			&cli.StringFlag{
-				Name:    "buffer-url",
-				Usage:   "Buffer service base URL",
-				EnvVars: []string{"BUFFER_URL"},
-			},
-			&cli.Int64Flag{
-				Name:    "duration",
-				Usage:   "duration of the deal in epochs (default: 518400)",
-				Value:   DefaultDuration,
-				EnvVars: []string{"DEAL_DURATION"},
-			},
-			&cli.Int64Flag{
-				Name:    "start-epoch-offset",
-				Usage:   "start epoch by when the deal should be proved by provider on-chain after current chain head (default: 1000)",
-				Value:   DefaultStartEpochHeadOffset,
-				EnvVars: []string{"DEAL_START_EPOCH_OFFSET"},
-			},
-			&cli.Int64Flag{
-				Name:    "start-epoch",
-				Usage:   "start epoch by when the deal should be proved by provider on-chain (overrides offset)",
-				EnvVars: []string{"DEAL_START_EPOCH"},
-			},
-			&cli.StringFlag{
-				Name:    "storage-price",
-				Usage:   "storage price in attoFIL per epoch per GiB (default: 0)",
-				Value:   DefaultStoragePrice,
-				EnvVars: []string{"STORAGE_PRICE"},
-			},
-			&cli.StringFlag{
-				Name:    "provider-collateral",
-				Usage:   "deal collateral that storage miner must put in escrow; if empty, the min collateral for the given piece size will be used (default: 0)",
-				Value:   DefaultProviderCollateral,
-				EnvVars: []string{"PROVIDER_COLLATERAL"},
-			},
-			// should be calculated for mainnet
-			&cli.StringFlag{
-				Name:    "client-collateral",
-				Usage:   "Client collateral in attoFil",
-				Value:   DefaultClientCollateral,
-				EnvVars: []string{"CLIENT_COLLATERAL"},
-			},
-			&cli.BoolFlag{
-				Name:    "skip-ipni",
-				Usage:   "indicates that deal index should not be announced to the IPNI(Network Indexer) (default: false)",
-				Value:   DefaultSkipIPNI,
-				EnvVars: []string{"SKIP_IPNI"},
-			},
-			&cli.BoolFlag{
-				Name:    "remove-unsealed",
-				Usage:   "indicates that an unsealed copy of the sector in not required for fast retrieval (default: false)",
-				Value:   DefaultRemoveUnsealed,
-				EnvVars: []string{"REMOVE_UNSEALED"},
-			},
-			&cli.BoolFlag{
-				Name:    "verified-deal",
-				Usage:   "whether the deal funds should come from verified client data-cap (default: true)",
-				Value:   DefaultVerifiedDeal,
-				EnvVars: []string{"VERIFIED_DEAL"},
-			},
-			&cli.BoolFlag{
-				Name:    "encrypted",
-				Usage:   "Whether to encrypt the file before making the deal (default: false)",
-				Value:   DefaultEncrypted,
-				EnvVars: []string{"ENCRYPTED"},
-			},
-			&cli.StringFlag{
-				Name:    "encrypted-out-dir",
-				Usage:   "Output directory for encrypted files (if not provided, uses temp dir and cleans up after)",
-				EnvVars: []string{"ENCRYPTED_OUT_DIR"},
-			},
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:     "input",
+			Required: true,
+			Usage:    "Input file or folder path",
+			EnvVars:  []string{"INPUT_PATH"},
+		},
+	}
+	flags = append(flags, dealFlags()...)
+	flags = append(flags,
+		&cli.BoolFlag{
+			Name:    "encrypted",
+			Usage:   "Whether to encrypt the file before making the deal (default: false)",
+			Value:   DefaultEncrypted,
+			EnvVars: []string{"ENCRYPTED"},
+		},
+		&cli.StringFlag{
+			Name:    "encrypted-out-dir",
+			Usage:   "Output directory for encrypted files (if not provided, uses temp dir and cleans up after)",
+			EnvVars: []string{"ENCRYPTED_OUT_DIR"},
+		},
+	)
+	flags = append(flags,
+		&cli.BoolFlag{
+			Name:  "detached-keys",
+			Usage: "Write the wrapped keys of --recipient encryption to a key manifest kept in the repo instead of the file header, so recipients can change without altering the stored file and its key can be destroyed with shred",
+		},
+		&cli.BoolFlag{
+			Name:  "obfuscate-names",
+			Usage: "When encrypting a folder, replace file and folder names with random names (the originals are kept in the manifest)",
+		},
+	)
+	flags = append(flags, compressionFlag(), vaultFlag())
+	flags = append(flags, recipientFlags()...)
+	flags = append(flags, signingFlags()...)
+	flags = append(flags, passphraseFlags()...)
+	flags = append(flags,
+		&cli.BoolFlag{
+			Name:    "dry-run",
+			Usage:   "prepare the data and simulate the proposal without uploading the CAR file to the buffer, storing keys or broadcasting a transaction (default: false)",
+			EnvVars: []string{"DRY_RUN"},
+		},
+		&cli.BoolFlag{
+			Name:    "wait",
+			Usage:   "wait for the transaction to be mined and report the proposal ID (default: false)",
+			EnvVars: []string{"WAIT"},
+		},
+		&cli.Uint64Flag{
+			Name:    "confirmations",
+			Usage:   "number of block confirmations to wait for when --wait is set (default: 1)",
+			Value:   DefaultConfirmations,
+			EnvVars: []string{"CONFIRMATIONS"},
 		},
+		&cli.DurationFlag{
+			Name:    "wait-timeout",
+			Usage:   "how long to wait for the transaction when --wait is set (default: 30m)",
+			Value:   DefaultWaitTimeout,
+			EnvVars: []string{"WAIT_TIMEOUT"},
+		},
+	)
+
+	return &cli.Command{
+		Name:   "make-deal",
+		Usage:  "Submit a new deal proposal",
+		Flags:  flags,
 		Action: makeDealAction,
 	}
 }
 
+// dealFlags returns the data preparation and deal parameter flags shared by
+// the deal submission commands
+func dealFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "outdir",
+			Usage:   "Output directory for CAR files (if not provided, uses temp dir and cleans up after)",
+			EnvVars: []string{"OUT_DIR"},
+		},
+		&cli.IntFlag{
+			Name:    "car-version",
+			Usage:   "CAR version to write: 1, or 2 for a CARv2 that also holds an index of its blocks",
+			Value:   dataprep.DefaultCarVersion,
+			EnvVars: []string{"CAR_VERSION"},
+		},
+		&cli.BoolFlag{
+			Name:    "no-wrap",
+			Usage:   "make a single input file the root of the DAG instead of wrapping it in a directory, which changes its payload and piece CIDs (default: false)",
+			EnvVars: []string{"NO_WRAP"},
+		},
+		&cli.StringFlag{
+			Name:    "buffer-type",
+			Usage:   "Buffer type (lighthouse or local)",
+			Value:   "local",
+			EnvVars: []string{"BUFFER_TYPE"},
+		},
+		&cli.StringFlag{
+			Name:    "buffer-api-key",
+			Usage:   "Buffer service API key",
+			EnvVars: []string{"BUFFER_API_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "buffer-url",
+			Usage:   "Buffer service base URL",
+			EnvVars: []string{"BUFFER_URL"},
+		},
+		&cli.Int64Flag{
+			Name:    "duration",
+			Usage:   "duration of the deal in epochs (default: 518400)",
+			Value:   DefaultDuration,
+			EnvVars: []string{"DEAL_DURATION"},
+		},
+		&cli.Int64Flag{
+			Name:    "start-epoch-offset",
+			Usage:   "start epoch by when the deal should be proved by provider on-chain after current chain head (default: 1000)",
+			Value:   DefaultStartEpochHeadOffset,
+			EnvVars: []string{"DEAL_START_EPOCH_OFFSET"},
+		},
+		&cli.Int64Flag{
+			Name:    "start-epoch",
+			Usage:   "start epoch by when the deal should be proved by provider on-chain (overrides offset)",
+			EnvVars: []string{"DEAL_START_EPOCH"},
+		},
+		&cli.StringFlag{
+			Name:    "storage-price",
+			Usage:   "storage price in attoFIL per epoch per GiB (default: 0)",
+			Value:   DefaultStoragePrice,
+			EnvVars: []string{"STORAGE_PRICE"},
+		},
+		&cli.StringFlag{
+			Name:    "provider-collateral",
+			Usage:   "deal collateral that storage miner must put in escrow; if empty, the min collateral for the given piece size will be used (default: 0)",
+			Value:   DefaultProviderCollateral,
+			EnvVars: []string{"PROVIDER_COLLATERAL"},
+		},
+		// should be calculated for mainnet
+		&cli.StringFlag{
+			Name:    "client-collateral",
+			Usage:   "Client collateral in attoFil",
+			Value:   DefaultClientCollateral,
+			EnvVars: []string{"CLIENT_COLLATERAL"},
+		},
+		&cli.BoolFlag{
+			Name:    "skip-ipni",
+			Usage:   "indicates that deal index should not be announced to the IPNI(Network Indexer) (default: false)",
+			Value:   DefaultSkipIPNI,
+			EnvVars: []string{"SKIP_IPNI"},
+		},
+		&cli.BoolFlag{
+			Name:    "remove-unsealed",
+			Usage:   "indicates that an unsealed copy of the sector in not required for fast retrieval (default: false)",
+			Value:   DefaultRemoveUnsealed,
+			EnvVars: []string{"REMOVE_UNSEALED"},
+		},
+		&cli.BoolFlag{
+			Name:    "verified-deal",
+			Usage:   "whether the deal funds should come from verified client data-cap (default: true)",
+			Value:   DefaultVerifiedDeal,
+			EnvVars: []string{"VERIFIED_DEAL"},
+		},
+	}
+}
+
 func makeDealAction(cCtx *cli.Context) error {
 	inputPath := cCtx.String("input")
+	originalInputPath := inputPath
 	outDir := cCtx.String("outdir")
 	isEncrypted := cCtx.Bool("encrypted")
 	encryptedOutDir := cCtx.String("encrypted-out-dir")
+	if !isEncrypted && len(cCtx.StringSlice("recipient")) > 0 {
+		return fmt.Errorf("--recipient requires --encrypted")
+	}
+	if !isEncrypted && cCtx.Bool("passphrase") {
+		return fmt.Errorf("--passphrase requires --encrypted")
+	}
+	detached := cCtx.Bool("detached-keys")
+	if !isEncrypted && detached {
+		return fmt.Errorf("--detached-keys requires --encrypted")
+	}
 
 	// Handle temporary directories
 	useTempMain := outDir == ""
 	useTempEncrypted := encryptedOutDir == ""
 	var tempDirs []string
+	var keyRef string
+	var dirManifest *encryption.DirManifest
+	// keyManifest is the key manifest of a file encrypted with --detached-keys
+	var keyManifest string
+	keys := newVaultKeys(cCtx)
 	var err error
 
 	// Setup main output directory
@@ -165,7 +251,11 @@ func makeDealAction(cCtx *cli.Context) error {
 
 	// If encryption is requested, encrypt the file first
 	if isEncrypted {
-		privateKey := cCtx.String("private-key")
+		// The wallet is needed for the deal itself in any case
+		privateKey, err := requirePrivateKey(cCtx)
+		if err != nil {
+			return err
+		}
 
 		// Setup encrypted output directory
 		if useTempEncrypted {
@@ -180,44 +270,332 @@ func makeDealAction(cCtx *cli.Context) error {
 			}
 		}
 
-		// Use the EncryptFile function
-		encryptedData, hexKey, err := encryption.EncryptFile(inputPath, privateKey)
+		compression, err := parseCompressionFlag(cCtx)
 		if err != nil {
-			return fmt.Errorf("failed to encrypt file: %w", err)
+			return err
 		}
 
-		// Write encrypted file
-		encryptedFilePath := filepath.Join(encryptedOutDir, "encrypted_"+filepath.Base(inputPath))
-		if err := os.WriteFile(encryptedFilePath, encryptedData, 0644); err != nil {
-			return fmt.Errorf("failed to write encrypted file: %w", err)
+		var recipients []*ecdsa.PublicKey
+		var passphrase []byte
+		if cCtx.Bool("passphrase") {
+			if len(cCtx.StringSlice("recipient")) > 0 {
+				return fmt.Errorf("--passphrase cannot be combined with --recipient")
+			}
+			if passphrase, err = readPassphrase(cCtx, true); err != nil {
+				return err
+			}
+		} else if recipients, err = resolveRecipients(cCtx); err != nil {
+			return err
+		}
+		if detached && recipients == nil {
+			return fmt.Errorf("--detached-keys requires --recipient")
 		}
 
-		fmt.Printf("File encrypted successfully with key: %s\n", hexKey)
+		info, err := os.Stat(inputPath)
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+
+		if detached && info.IsDir() {
+			return fmt.Errorf("--detached-keys is not supported for folders")
+		}
+
+		// Encrypt the input straight into the encrypted output directory
+		encryptedPath := filepath.Join(encryptedOutDir, "encrypted_"+filepath.Base(filepath.Clean(inputPath)))
+		switch {
+		case info.IsDir():
+			dirManifest, _, err = encryptDir(inputPath, encryptedPath, privateKey, encryption.DirOptions{
+				ObfuscateNames: cCtx.Bool("obfuscate-names"),
+				Recipients:     recipients,
+				Passphrase:     passphrase,
+				ScryptParams:   encryption.DefaultScryptParams,
+				Compression:    compression,
+				OnFile: func(inputPath, encryptedPath string, key []byte) error {
+					keys.add(inputPath, encryptedPath, key)
+					return nil
+				},
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Folder encrypted successfully (%d files)\n", len(dirManifest.Files))
+		case recipients != nil:
+			var key []byte
+			if detached {
+				keyManifest = encryption.KeyManifestPath(encryptedPath)
+				key, err = encryption.EncryptFileDetached(inputPath, encryptedPath, keyManifest, recipients, compression)
+			} else {
+				key, err = encryption.EncryptFileTo(inputPath, encryptedPath, recipients, compression)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to encrypt file: %w", err)
+			}
+			keyRef = encryption.KeyID(key)
+			keys.add(inputPath, encryptedPath, key)
+
+			fmt.Printf("File encrypted successfully to %d recipients\n", len(recipients))
+		case passphrase != nil:
+			key, err := encryption.EncryptFileWithPassphrase(inputPath, encryptedPath, passphrase, encryption.DefaultScryptParams, compression)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt file: %w", err)
+			}
+			keyRef = encryption.KeyID(key)
+			keys.add(inputPath, encryptedPath, key)
+
+			fmt.Printf("File encrypted successfully with a passphrase\n")
+		default:
+			signing, err := keySigning(cCtx)
+			if err != nil {
+				return err
+			}
+			hexKey, err := encryption.EncryptFile(inputPath, encryptedPath, privateKey, signing, compression)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt file: %w", err)
+			}
+
+			key, err := hex.DecodeString(hexKey)
+			if err != nil {
+				return fmt.Errorf("failed to decode derived key: %w", err)
+			}
+			keyRef = encryption.KeyID(key)
+			keys.add(inputPath, encryptedPath, key)
+
+			fmt.Printf("File encrypted successfully with key: %s\n", hexKey)
+		}
 		if !useTempEncrypted {
 			fmt.Printf("Encrypted file directory: %s\n", encryptedOutDir)
 		}
+		if err := printSizeStats(inputPath, encryptedPath); err != nil {
+			return err
+		}
 
 		// Update input path to use encrypted file for the deal
-		inputPath = encryptedFilePath
-	}
-
-	// Create data prep config
-	config := &buffer.Config{
-		Type:    cCtx.String("buffer-type"),
-		ApiKey:  cCtx.String("buffer-api-key"),
-		BaseURL: cCtx.String("buffer-url"),
+		inputPath = encryptedPath
+	} else if linked := keys.link(cCtx, inputPath); len(linked) == 1 {
+		// Data that was encrypted earlier is linked to the deal through its stored key
+		keyRef = linked[0].KeyRef
 	}
 
 	// Prepare data using our dataprep package
-	prepResult, err := dealutils.PrepareData(
-		inputPath,
-		outDir,
-		config,
-	)
+	dryRun := cCtx.Bool("dry-run")
+	prepResult, err := prepareData(cCtx, inputPath, outDir, !dryRun)
 	if err != nil {
 		return fmt.Errorf("failed to prepare data: %w", err)
 	}
+	// A dry run leaves the key vault and the repo untouched
+	if !dryRun {
+		keys.setDeal(prepResult.PayloadCid, prepResult.PieceCid)
+		if keyManifest != "" {
+			// The encrypted file may be temporary, and the only copy of its wrapped
+			// keys is kept in the repo with the vault, where shred can destroy it
+			path, err := saveKeyManifest(cCtx, keyRef, keyManifest)
+			if err != nil {
+				return err
+			}
+			keys.setKeyManifest(path)
+			fmt.Printf("Key manifest saved to: %s\n", path)
+		}
+		keys.store(cCtx)
+	}
+
+	params, err := parseDealParams(cCtx)
+	if err != nil {
+		return err
+	}
+
+	dealRequest, err := params.dealRequest(prepResult)
+	if err != nil {
+		return err
+	}
+
+	client, err := newDealClient(cCtx)
+	if err != nil {
+		return err
+	}
 
+	if dryRun {
+		return simulateDeal(cCtx, client, dealRequest)
+	}
+
+	db, err := openDealDB(cCtx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	txHash, err := client.MakeDealProposal(cCtx.Context, dealRequest)
+	if err != nil {
+		return fmt.Errorf("failed to make deal proposal: %w", err)
+	}
+
+	fmt.Printf("Deal proposal submitted in transaction: %s\n", txHash.Hex())
+
+	record := newDealRecord(originalInputPath, prepResult, dealRequest, txHash)
+	record.KeyRef = keyRef
+	if dirManifest != nil {
+		// The encrypted folder may be temporary, so its manifest is kept in the repo
+		if record.Manifest, err = saveDirManifest(cCtx, prepResult.PieceCid, dirManifest); err != nil {
+			return err
+		}
+		fmt.Printf("Folder manifest saved to: %s\n", record.Manifest)
+	}
+	if err := db.Add(record); err != nil {
+		return fmt.Errorf("failed to record deal: %w", err)
+	}
+	fmt.Printf("Deal recorded locally with ID: %d\n", record.ID)
+
+	if !cCtx.Bool("wait") {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(cCtx.Context, cCtx.Duration("wait-timeout"))
+	defer cancel()
+
+	result, err := client.WaitForProposals(ctx, txHash, cCtx.Uint64("confirmations"))
+	if err != nil {
+		return fmt.Errorf("failed to wait for deal proposal: %w", err)
+	}
+	if len(result.Proposals) == 0 {
+		return fmt.Errorf("transaction %s did not emit a DealProposalCreate event", txHash.Hex())
+	}
+
+	fmt.Printf("Deal proposal included in block %d (gas used: %d)\n", result.BlockNumber, result.GasUsed)
+	fmt.Printf("Proposal ID: %s\n", result.Proposals[0].ID.Hex())
+	return recordProposal(db, record.ID, result.Proposals[0].ID)
+}
+
+// saveDirManifest stores the manifest of an encrypted folder in the repo, named after
+// the piece it was stored in
+func saveDirManifest(cCtx *cli.Context, pieceCID string, manifest *encryption.DirManifest) (string, error) {
+	path, err := repoPath(cCtx, filepath.Join("manifests", pieceCID+".json"))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+	if err := encryption.WriteDirManifest(path, manifest); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// saveKeyManifest moves the key manifest at path into the repo, named after the key
+// it wraps, and returns its new path
+func saveKeyManifest(cCtx *cli.Context, keyRef, path string) (string, error) {
+	manifest, err := encryption.ReadKeyManifest(path)
+	if err != nil {
+		return "", err
+	}
+	repoManifest, err := repoPath(cCtx, filepath.Join("manifests", keyRef+".keys.json"))
+	if err != nil {
+		return "", err
+	}
+	if abs, err := filepath.Abs(repoManifest); err == nil {
+		repoManifest = abs
+	}
+	if err := os.MkdirAll(filepath.Dir(repoManifest), 0700); err != nil {
+		return "", fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+	if err := encryption.WriteKeyManifest(repoManifest, manifest); err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("failed to remove key manifest: %w", err)
+	}
+	return repoManifest, nil
+}
+
+// simulateDeal prints what make-deal would send and the simulated outcome
+func simulateDeal(cCtx *cli.Context, client *contract.DealClient, dealRequest types.DealRequest) error {
+	sim, err := client.SimulateDealProposal(cCtx.Context, dealRequest)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Dry run: the deal proposal was not broadcast\n\n")
+	if err := printTable(dealRequestRows(newDealRequestView(dealRequest))); err != nil {
+		return err
+	}
+	fmt.Println()
+
+	if err := printTable([][2]string{
+		{"Proposal ID (simulated)", sim.ProposalID.Hex()},
+		{"Estimated gas", fmt.Sprint(sim.Gas)},
+		{"Gas fee cap", sim.GasFeeCap.String() + " attoFIL"},
+		{"Gas tip cap", sim.GasTipCap.String() + " attoFIL"},
+		{"Maximum fee", utils.FormatFIL(sim.MaxFee())},
+	}); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nCalldata: %s\n", hexutil.Encode(sim.Calldata))
+	return nil
+}
+
+// dealParams holds the deal terms that are shared by every proposal made in
+// a single command invocation
+type dealParams struct {
+	startEpoch         int64
+	endEpoch           int64
+	storagePrice       *big.Int
+	providerCollateral *big.Int
+	clientCollateral   *big.Int
+	verifiedDeal       bool
+	skipIPNI           bool
+	removeUnsealed     bool
+}
+
+// prepareData writes the CAR file of the input to outDir and stores it in the
+// configured buffer. Without upload, nothing is sent to the buffer and the local
+// path of the CAR file is used as its location
+func prepareData(cCtx *cli.Context, inputPath, outDir string, upload bool) (*dealutils.DataPrepResult, error) {
+	res, err := dataprep.Prepare(cCtx.Context, inputPath, outDir, dataprep.Options{
+		CarVersion: cCtx.Int("car-version"),
+		NoWrap:     cCtx.Bool("no-wrap"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := bufferConfig(cCtx)
+	var buf buffer.Buffer
+	switch {
+	case !upload:
+		buf = buffer.NewLocalBuffer()
+	case cfg.Type == "lighthouse":
+		buf = buffer.NewLighthouseBuffer(cfg.ApiKey, cfg.BaseURL)
+	default:
+		buf = buffer.NewLocalBuffer()
+	}
+	info, err := buf.Store(res.CarPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store in buffer: %w", err)
+	}
+
+	return &dealutils.DataPrepResult{
+		PieceCid:   res.PieceCID.String(),
+		PayloadCid: res.PayloadCID.String(),
+		PieceSize:  res.PieceSize,
+		CarSize:    res.CarSize,
+		LocalPath:  res.CarPath,
+		BufferInfo: info,
+	}, nil
+}
+
+// bufferConfig builds the buffer configuration from the command flags
+func bufferConfig(cCtx *cli.Context) *buffer.Config {
+	return &buffer.Config{
+		Type:    cCtx.String("buffer-type"),
+		ApiKey:  cCtx.String("buffer-api-key"),
+		BaseURL: cCtx.String("buffer-url"),
+	}
+}
+
+// parseDealParams reads the deal terms from the command flags, fetching the
+// chain head when no explicit start epoch is given
+func parseDealParams(cCtx *cli.Context) (*dealParams, error) {
 	// Calculate epochs
 	startEpoch := cCtx.Int64("start-epoch")
 	duration := cCtx.Int64("duration")
@@ -226,65 +604,59 @@ func makeDealAction(cCtx *cli.Context) error {
 		// Fetch chain head and add offset
 		head, err := chain.GetChainHead(cCtx.Context, cCtx.String("rpc-url"))
 		if err != nil {
-			return fmt.Errorf("failed to get chain head: %w", err)
+			return nil, fmt.Errorf("failed to get chain head: %w", err)
 		}
 		startEpoch = head + cCtx.Int64("start-epoch-offset")
 	}
 
-	endEpoch := startEpoch + duration
-
-	// Create deal request using prep result
 	storagePrice, ok := new(big.Int).SetString(cCtx.String("storage-price"), 10)
 	if !ok {
-		return fmt.Errorf("invalid storage price format")
+		return nil, fmt.Errorf("invalid storage price format")
 	}
 	providerCollateral, ok := new(big.Int).SetString(cCtx.String("provider-collateral"), 10)
 	if !ok {
-		return fmt.Errorf("invalid provider collateral format")
+		return nil, fmt.Errorf("invalid provider collateral format")
 	}
 	clientCollateral, ok := new(big.Int).SetString(cCtx.String("client-collateral"), 10)
 	if !ok {
-		return fmt.Errorf("invalid client collateral format")
+		return nil, fmt.Errorf("invalid client collateral format")
 	}
 
+	return &dealParams{
+		startEpoch:         startEpoch,
+		endEpoch:           startEpoch + duration,
+		storagePrice:       storagePrice,
+		providerCollateral: providerCollateral,
+		clientCollateral:   clientCollateral,
+		verifiedDeal:       cCtx.Bool("verified-deal"),
+		skipIPNI:           cCtx.Bool("skip-ipni"),
+		removeUnsealed:     cCtx.Bool("remove-unsealed"),
+	}, nil
+}
+
+// dealRequest creates a deal request for the prepared data
+func (p *dealParams) dealRequest(prepResult *dealutils.DataPrepResult) (types.DealRequest, error) {
 	c, err := cid.Decode(prepResult.PieceCid)
 	if err != nil {
-		return fmt.Errorf("failed to decode piece CID: %w", err)
+		return types.DealRequest{}, fmt.Errorf("failed to decode piece CID: %w", err)
 	}
 
-	dealRequest := types.DealRequest{
+	return types.DealRequest{
 		PieceCID:             c.Bytes(),
 		PieceSize:            prepResult.PieceSize,
-		VerifiedDeal:         cCtx.Bool("verified-deal"),
+		VerifiedDeal:         p.verifiedDeal,
 		Label:                prepResult.PayloadCid,
-		StartEpoch:           startEpoch,
-		EndEpoch:             endEpoch,
-		StoragePricePerEpoch: storagePrice,
-		ProviderCollateral:   providerCollateral,
-		ClientCollateral:     clientCollateral,
+		StartEpoch:           p.startEpoch,
+		EndEpoch:             p.endEpoch,
+		StoragePricePerEpoch: p.storagePrice,
+		ProviderCollateral:   p.providerCollateral,
+		ClientCollateral:     p.clientCollateral,
 		ExtraParamsVersion:   1,
 		ExtraParams: types.ExtraParamsV1{
 			LocationRef:        prepResult.BufferInfo.URL,
 			CarSize:            prepResult.CarSize,
-			SkipIPNIAnnounce:   cCtx.Bool("skip-ipni"),
-			RemoveUnsealedCopy: cCtx.Bool("remove-unsealed"),
+			SkipIPNIAnnounce:   p.skipIPNI,
+			RemoveUnsealedCopy: p.removeUnsealed,
 		},
-	}
-
-	client, err := contract.NewDealClient(
-		cCtx.String("rpc-url"),
-		cCtx.String("contract"),
-		cCtx.String("private-key"),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to create deal client: %w", err)
-	}
-
-	txHash, err := client.MakeDealProposal(cCtx.Context, dealRequest)
-	if err != nil {
-		return fmt.Errorf("failed to make deal proposal: %w", err)
-	}
-
-	fmt.Printf("Deal proposal submitted in transaction: %s\n", txHash.Hex())
-	return nil
+	}, nil
 }
@@ -0,0 +1,89 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/eastore-project/eastore/pkg/types"
+)
+
+// dealRequestView is the printable form of a deal request
+type dealRequestView struct {
+	PieceCID             string `json:"piece_cid"`
+	PieceSize            uint64 `json:"piece_size"`
+	VerifiedDeal         bool   `json:"verified_deal"`
+	Label                string `json:"label"`
+	StartEpoch           int64  `json:"start_epoch"`
+	EndEpoch             int64  `json:"end_epoch"`
+	StoragePricePerEpoch string `json:"storage_price_per_epoch"`
+	ProviderCollateral   string `json:"provider_collateral"`
+	ClientCollateral     string `json:"client_collateral"`
+	ExtraParamsVersion   uint64 `json:"extra_params_version"`
+	LocationRef          string `json:"location_ref"`
+	CarSize              uint64 `json:"car_size"`
+	SkipIPNIAnnounce     bool   `json:"skip_ipni_announce"`
+	RemoveUnsealedCopy   bool   `json:"remove_unsealed_copy"`
+}
+
+func newDealRequestView(deal types.DealRequest) dealRequestView {
+	return dealRequestView{
+		PieceCID:             formatPieceCID(deal.PieceCID),
+		PieceSize:            deal.PieceSize,
+		VerifiedDeal:         deal.VerifiedDeal,
+		Label:                deal.Label,
+		StartEpoch:           deal.StartEpoch,
+		EndEpoch:             deal.EndEpoch,
+		StoragePricePerEpoch: deal.StoragePricePerEpoch.String(),
+		ProviderCollateral:   deal.ProviderCollateral.String(),
+		ClientCollateral:     deal.ClientCollateral.String(),
+		ExtraParamsVersion:   deal.ExtraParamsVersion,
+		LocationRef:          deal.ExtraParams.LocationRef,
+		CarSize:              deal.ExtraParams.CarSize,
+		SkipIPNIAnnounce:     deal.ExtraParams.SkipIPNIAnnounce,
+		RemoveUnsealedCopy:   deal.ExtraParams.RemoveUnsealedCopy,
+	}
+}
+
+// printTable prints key/value rows with aligned values
+func printTable(rows [][2]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s:\t%s\n", row[0], row[1])
+	}
+	return w.Flush()
+}
+
+// dealRequestRows returns the table rows describing a deal request
+func dealRequestRows(view dealRequestView) [][2]string {
+	return [][2]string{
+		{"Piece CID", view.PieceCID},
+		{"Piece size", fmt.Sprint(view.PieceSize)},
+		{"Verified deal", fmt.Sprint(view.VerifiedDeal)},
+		{"Label", view.Label},
+		{"Start epoch", fmt.Sprint(view.StartEpoch)},
+		{"End epoch", fmt.Sprint(view.EndEpoch)},
+		{"Storage price per epoch", view.StoragePricePerEpoch},
+		{"Provider collateral", view.ProviderCollateral},
+		{"Client collateral", view.ClientCollateral},
+		{"Extra params version", fmt.Sprint(view.ExtraParamsVersion)},
+		{"Location ref", view.LocationRef},
+		{"CAR size", fmt.Sprint(view.CarSize)},
+		{"Skip IPNI announce", fmt.Sprint(view.SkipIPNIAnnounce)},
+		{"Remove unsealed copy", fmt.Sprint(view.RemoveUnsealedCopy)},
+	}
+}
+
+// printJSON prints v as indented JSON
+func printJSON(v interface{}) error {
+	return writeJSON(os.Stdout, v)
+}
+
+// writeJSON writes v to w as indented JSON
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
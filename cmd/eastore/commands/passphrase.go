@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+// passphraseEnv is the environment variable read for the passphrase before prompting
+const passphraseEnv = "EASTORE_PASSPHRASE"
+
+// vaultPassphraseEnv is the environment variable read for the key vault passphrase
+// before prompting
+const vaultPassphraseEnv = "EASTORE_VAULT_PASSPHRASE"
+
+// passphraseFlags are the flags for encrypting with a passphrase instead of the wallet
+func passphraseFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "passphrase",
+			Usage: "Derive the key from a passphrase (scrypt) instead of the wallet, read from --passphrase-file, " + passphraseEnv + " or the terminal",
+		},
+		passphraseFileFlag(),
+	}
+}
+
+func passphraseFileFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "passphrase-file",
+		Usage: "File whose first line is the passphrase",
+	}
+}
+
+// readPassphrase returns the passphrase from --passphrase-file or the environment,
+// or prompts for it on the terminal, twice if confirm is set
+func readPassphrase(cCtx *cli.Context, confirm bool) ([]byte, error) {
+	return readSecret(cCtx.String("passphrase-file"), passphraseEnv, "passphrase", confirm)
+}
+
+// readVaultPassphrase returns the key vault passphrase from the environment, or
+// prompts for it on the terminal
+func readVaultPassphrase(confirm bool) ([]byte, error) {
+	return readSecret("", vaultPassphraseEnv, "vault passphrase", confirm)
+}
+
+// readSecret returns the first line of path, if set, or the value of the environment
+// variable env, or prompts for the secret called name on the terminal
+func readSecret(path, env, name string, confirm bool) ([]byte, error) {
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase file: %w", err)
+		}
+		defer f.Close()
+
+		line, err := bufio.NewReader(f).ReadString('\n')
+		if line = strings.TrimRight(line, "\r\n"); line == "" {
+			if err != nil {
+				return nil, fmt.Errorf("failed to read passphrase file: %w", err)
+			}
+			return nil, fmt.Errorf("passphrase file %s is empty", path)
+		}
+		return []byte(line), nil
+	}
+	if passphrase := os.Getenv(env); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("no %s given: set %s", name, env)
+	}
+	passphrase, err := promptPassphrase(fd, strings.ToUpper(name[:1])+name[1:]+": ")
+	if err != nil {
+		return nil, err
+	}
+	if confirm {
+		again, err := promptPassphrase(fd, "Repeat "+name+": ")
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(passphrase, again) {
+			return nil, fmt.Errorf("%ss do not match", name)
+		}
+	}
+	return passphrase, nil
+}
+
+func promptPassphrase(fd int, prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+	return passphrase, nil
+}
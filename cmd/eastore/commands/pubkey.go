@@ -0,0 +1,28 @@
+package commands
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/urfave/cli/v2"
+)
+
+// PubkeyCommand returns the CLI command for showing the wallet's public key
+func PubkeyCommand() *cli.Command {
+	return &cli.Command{
+		Name:   "pubkey",
+		Usage:  "Show the wallet's address and public key, which others need to encrypt data to it",
+		Action: pubkeyAction,
+	}
+}
+
+func pubkeyAction(cCtx *cli.Context) error {
+	pub, err := walletPublicKey(cCtx)
+	if err != nil {
+		return err
+	}
+
+	return printTable([][2]string{
+		{"Address", crypto.PubkeyToAddress(*pub).Hex()},
+		{"Public key", hexutil.Encode(crypto.FromECDSAPub(pub))},
+	})
+}
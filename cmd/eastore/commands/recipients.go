@@ -0,0 +1,139 @@
+package commands
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/eastore-project/eastore/pkg/encryption"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/urfave/cli/v2"
+)
+
+// recipientFlags are the flags that select the recipients of envelope encryption
+func recipientFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "recipient",
+			Usage: "Encrypt to this recipient, given as a hex public key or as an address listed in --pubkeys (can be repeated)",
+		},
+		&cli.StringFlag{
+			Name:  "pubkeys",
+			Usage: "JSON file mapping addresses to known public keys (default: <repo>/pubkeys.json)",
+		},
+		&cli.BoolFlag{
+			Name:  "exclude-self",
+			Usage: "Do not add the encrypting wallet to the recipients",
+		},
+	}
+}
+
+// resolveRecipients returns the public keys selected with --recipient, including
+// the encrypting wallet unless --exclude-self is set. It returns nil if no
+// recipients were given, in which case the wallet-derived key is used instead
+func resolveRecipients(cCtx *cli.Context) ([]*ecdsa.PublicKey, error) {
+	specs := cCtx.StringSlice("recipient")
+	if len(specs) == 0 {
+		if cCtx.Bool("exclude-self") {
+			return nil, errors.New("--exclude-self requires at least one --recipient")
+		}
+		return nil, nil
+	}
+
+	recipients, err := resolvePublicKeys(cCtx, specs)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cCtx.Bool("exclude-self") {
+		self, err := walletPublicKey(cCtx)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, self)
+	}
+	return recipients, nil
+}
+
+// resolvePublicKeys resolves hex public keys, and addresses listed in the pubkeys file
+func resolvePublicKeys(cCtx *cli.Context, specs []string) ([]*ecdsa.PublicKey, error) {
+	var known map[common.Address]*ecdsa.PublicKey
+	var pubs []*ecdsa.PublicKey
+	for _, spec := range specs {
+		if !common.IsHexAddress(spec) {
+			pub, err := encryption.ParsePublicKey(spec)
+			if err != nil {
+				return nil, err
+			}
+			pubs = append(pubs, pub)
+			continue
+		}
+
+		if known == nil {
+			var err error
+			if known, err = loadKnownPubkeys(cCtx); err != nil {
+				return nil, err
+			}
+		}
+		pub, ok := known[common.HexToAddress(spec)]
+		if !ok {
+			return nil, fmt.Errorf("no known public key for recipient %s; add it to the pubkeys file or pass the public key", spec)
+		}
+		pubs = append(pubs, pub)
+	}
+	return pubs, nil
+}
+
+// loadKnownPubkeys reads the file mapping addresses to public keys
+func loadKnownPubkeys(cCtx *cli.Context) (map[common.Address]*ecdsa.PublicKey, error) {
+	path := cCtx.String("pubkeys")
+	if path == "" {
+		var err error
+		if path, err = repoPath(cCtx, "pubkeys.json"); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pubkeys file: %w", err)
+	}
+	var entries map[string]string
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse pubkeys file %s: %w", path, err)
+	}
+
+	known := make(map[common.Address]*ecdsa.PublicKey, len(entries))
+	for addr, hexKey := range entries {
+		if !common.IsHexAddress(addr) {
+			return nil, fmt.Errorf("invalid address %q in pubkeys file", addr)
+		}
+		pub, err := encryption.ParsePublicKey(hexKey)
+		if err != nil {
+			return nil, err
+		}
+		address := common.HexToAddress(addr)
+		if derived := crypto.PubkeyToAddress(*pub); derived != address {
+			return nil, fmt.Errorf("public key for %s in pubkeys file belongs to %s", address.Hex(), derived.Hex())
+		}
+		known[address] = pub
+	}
+	return known, nil
+}
+
+// walletPublicKey returns the public key of --private-key
+func walletPublicKey(cCtx *cli.Context) (*ecdsa.PublicKey, error) {
+	hexKey, err := requirePrivateKey(cCtx)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(hexKey, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return &privateKey.PublicKey, nil
+}
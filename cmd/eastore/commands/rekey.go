@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/eastore-project/eastore/pkg/encryption"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/urfave/cli/v2"
+)
+
+// RekeyCommand returns the CLI command for changing the recipients of an encrypted file
+func RekeyCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "rekey",
+		Usage: "Add or revoke recipients of a file encrypted with --recipient, without re-encrypting its data",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "input",
+				Required: true,
+				Usage:    "Encrypted file path",
+				EnvVars:  []string{"INPUT_PATH"},
+			},
+			&cli.StringFlag{
+				Name:  "keys",
+				Usage: "Key manifest to update for a file encrypted with --detached-keys (default: <input>.keys.json, or the manifest recorded in the key vault)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "add",
+				Usage: "Recipient to add, given as a hex public key or as an address listed in --pubkeys (can be repeated)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "revoke",
+				Usage: "Address of a recipient to remove (can be repeated)",
+			},
+			&cli.BoolFlag{
+				Name:  "rewrap",
+				Usage: "Wrap the same data key again for every remaining recipient under fresh ephemeral keys; this is not a key rotation, and anyone who already holds the data key can still decrypt (default: false)",
+			},
+			&cli.StringFlag{
+				Name:  "pubkeys",
+				Usage: "JSON file mapping addresses to known public keys (default: <repo>/pubkeys.json)",
+			},
+		},
+		Action: rekeyAction,
+	}
+}
+
+func rekeyAction(cCtx *cli.Context) error {
+	inputPath := cCtx.String("input")
+
+	add, err := resolvePublicKeys(cCtx, cCtx.StringSlice("add"))
+	if err != nil {
+		return err
+	}
+	var revoke []common.Address
+	for _, addr := range cCtx.StringSlice("revoke") {
+		if !common.IsHexAddress(addr) {
+			return fmt.Errorf("invalid address %q", addr)
+		}
+		revoke = append(revoke, common.HexToAddress(addr))
+	}
+	if len(add) == 0 && len(revoke) == 0 && !cCtx.Bool("rewrap") {
+		return fmt.Errorf("nothing to do: pass --add, --revoke or --rewrap")
+	}
+
+	vault := &vaultLookup{cCtx: cCtx}
+	header, manifestPath, err := readFileHeader(inputPath, cCtx.String("keys"), vault)
+	if err != nil {
+		return err
+	}
+
+	// Only a current recipient can unwrap the data key to wrap it for others
+	privateKey, err := requirePrivateKey(cCtx)
+	if err != nil {
+		return err
+	}
+	key, err := encryption.DeriveKey(header, privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	if manifestPath == "" {
+		if err := checkNotInDeal(cCtx, vault, header, key); err != nil {
+			return err
+		}
+	}
+	if err := header.Rekey(key, add, revoke, cCtx.Bool("rewrap")); err != nil {
+		return err
+	}
+
+	if manifestPath != "" {
+		if err := encryption.WriteKeyManifest(manifestPath, encryption.NewKeyManifest(header)); err != nil {
+			return err
+		}
+		fmt.Printf("Key manifest updated: %s\n", manifestPath)
+	} else {
+		if err := encryption.RewriteFileHeader(inputPath, header); err != nil {
+			return fmt.Errorf("failed to rewrite header: %w", err)
+		}
+		fmt.Printf("Header updated: %s\n", inputPath)
+		fmt.Printf("Note: the encrypted data is unchanged, but the file and its piece CID now differ; use --detached-keys to keep them fixed\n")
+	}
+
+	fmt.Printf("Key generation: %d\n", header.KeyGeneration)
+	fmt.Printf("Recipients:\n")
+	for _, r := range header.Recipients {
+		fmt.Printf("  %s\n", r.Address().Hex())
+	}
+	return nil
+}
+
+// checkNotInDeal refuses to rewrite the header of a file whose data is already stored
+// in a deal, found by its key reference in the deal database or by the piece CID
+// recorded for it in the key vault. The new header would change the file and its
+// piece CID, while the stored copy kept its current recipients
+func checkNotInDeal(cCtx *cli.Context, vault *vaultLookup, header *encryption.Header, key []byte) error {
+	db, err := openDealDB(cCtx)
+	if err != nil {
+		return err
+	}
+	deals, err := db.List()
+	db.Close()
+	if err != nil {
+		return err
+	}
+
+	pieceCID := ""
+	if e := vault.entry(header.FileID()); e != nil {
+		pieceCID = e.PieceCID
+	}
+	keyRef := encryption.KeyID(key)
+	stored := ""
+	for _, deal := range deals {
+		if deal.KeyRef == keyRef || (pieceCID != "" && deal.PieceCID == pieceCID) {
+			stored = fmt.Sprintf("deal %d (%s)", deal.ID, deal.PieceCID)
+			break
+		}
+	}
+	if stored == "" && pieceCID != "" {
+		stored = "piece " + pieceCID
+	}
+	if stored == "" {
+		return nil
+	}
+	return fmt.Errorf("the file is stored in %s, and rewriting its header would change its piece CID while the stored copy keeps its current recipients; encrypt it with --detached-keys to change recipients without changing the stored data", stored)
+}
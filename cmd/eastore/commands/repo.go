@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eastore-project/eastore/pkg/dealdb"
+	"github.com/eastore-project/eastore/pkg/encryption"
+	"github.com/eastore-project/eastore/pkg/keyvault"
+	"github.com/urfave/cli/v2"
+)
+
+// DefaultRepo is the default directory for local Eastore state
+const DefaultRepo = "~/.eastore"
+
+// repoPath returns the path of a file inside the local repo directory
+func repoPath(cCtx *cli.Context, name string) (string, error) {
+	repo := cCtx.String("repo")
+	if repo == "" {
+		repo = DefaultRepo
+	}
+
+	if repo == "~" || strings.HasPrefix(repo, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve home directory: %w", err)
+		}
+		repo = filepath.Join(home, strings.TrimPrefix(repo, "~"))
+	}
+
+	return filepath.Join(repo, name), nil
+}
+
+// openDealDB opens the deal database in the local repo
+func openDealDB(cCtx *cli.Context) (*dealdb.DB, error) {
+	path, err := repoPath(cCtx, "deals.db")
+	if err != nil {
+		return nil, err
+	}
+	return dealdb.Open(path)
+}
+
+// openKeyVault opens the key vault in the local repo, unlocking it with the wallet or
+// the vault passphrase as recorded in its header. If there is no vault yet, it is
+// created when create is set, unlocked by the wallet if --private-key is given and by
+// a new passphrase otherwise; if create is not set, nil is returned
+func openKeyVault(cCtx *cli.Context, create bool) (*keyvault.Vault, error) {
+	path, err := repoPath(cCtx, keyvault.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyvault.Exists(path) {
+		return keyvault.Open(path, &encryption.Unlocker{
+			PrivateKey: cCtx.String("private-key"),
+			Passphrase: func() ([]byte, error) { return readVaultPassphrase(false) },
+		})
+	}
+	if !create {
+		return nil, nil
+	}
+
+	if privateKey := cCtx.String("private-key"); privateKey != "" {
+		return keyvault.CreateWithWallet(path, privateKey)
+	}
+	passphrase, err := readVaultPassphrase(true)
+	if err != nil {
+		return nil, err
+	}
+	return keyvault.CreateWithPassphrase(path, passphrase)
+}
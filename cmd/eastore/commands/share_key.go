@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/eastore-project/eastore/pkg/encryption"
+	"github.com/urfave/cli/v2"
+)
+
+// ShareKeyCommand returns the CLI command for deriving the key of an encrypted directory
+func ShareKeyCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "share-key",
+		Usage: "Derive the key of a directory of an encrypted folder, which unlocks only the files below it",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "manifest",
+				Required: true,
+				Usage:    "Manifest of the encrypted folder",
+			},
+			&cli.StringFlag{
+				Name:  "path",
+				Usage: "Original path of the directory to share, relative to the folder (default: the whole folder)",
+			},
+		},
+		Action: shareKeyAction,
+	}
+}
+
+func shareKeyAction(cCtx *cli.Context) error {
+	manifest, err := encryption.ReadDirManifest(cCtx.String("manifest"))
+	if err != nil {
+		return err
+	}
+	if manifest.Dataset == "" {
+		return fmt.Errorf("the folder was not encrypted with the wallet's key hierarchy")
+	}
+
+	encPath := ""
+	if p := path.Clean(cCtx.String("path")); p != "." {
+		for _, dir := range manifest.Dirs {
+			if dir.Path == p {
+				encPath = dir.EncryptedPath
+				break
+			}
+		}
+		if encPath == "" {
+			return fmt.Errorf("directory %q is not in the manifest", p)
+		}
+	}
+
+	privateKey, err := requirePrivateKey(cCtx)
+	if err != nil {
+		return err
+	}
+	root, err := encryption.RootKey(privateKey)
+	if err != nil {
+		return err
+	}
+	dataset, err := encryption.DatasetKey(root, manifest.Dataset)
+	if err != nil {
+		return err
+	}
+	key, err := dataset.Dir(encPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(key.String())
+	return nil
+}
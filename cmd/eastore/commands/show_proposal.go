@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/eastore-project/eastore/pkg/utils"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/urfave/cli/v2"
+)
+
+// ShowProposalCommand returns the CLI command for decoding a proposal stored by the contract
+func ShowProposalCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "show-proposal",
+		Usage: "Decode the deal proposal and extra params the contract stores for a proposal ID",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "proposal-id",
+				Required: true,
+				Usage:    "Hex-encoded proposal ID",
+			},
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the proposal as JSON (default: false)",
+			},
+		},
+		Action: showProposalAction,
+	}
+}
+
+// storedProposalView is the printable form of a stored proposal
+type storedProposalView struct {
+	ProposalID string `json:"proposal_id"`
+	Client     string `json:"client"`
+	Provider   string `json:"provider"`
+	dealRequestView
+}
+
+func showProposalAction(cCtx *cli.Context) error {
+	proposalID, err := parseProposalID(cCtx.String("proposal-id"))
+	if err != nil {
+		return err
+	}
+
+	client, err := newDealClient(cCtx)
+	if err != nil {
+		return err
+	}
+
+	proposal, err := client.GetStoredProposal(cCtx.Context, proposalID)
+	if err != nil {
+		return fmt.Errorf("failed to get proposal: %w", err)
+	}
+
+	view := storedProposalView{
+		ProposalID:      proposal.ProposalID.Hex(),
+		Client:          formatFilAddress(proposal.Client),
+		Provider:        formatFilAddress(proposal.Provider),
+		dealRequestView: newDealRequestView(proposal.Deal),
+	}
+
+	if cCtx.Bool("json") {
+		return printJSON(view)
+	}
+
+	rows := [][2]string{
+		{"Proposal ID", view.ProposalID},
+		{"Client", view.Client},
+		{"Provider", view.Provider},
+	}
+	return printTable(append(rows, dealRequestRows(view.dealRequestView)...))
+}
+
+// formatFilAddress renders Filecoin address bytes, falling back to hex
+func formatFilAddress(b []byte) string {
+	addr, err := utils.FormatFilAddress(b)
+	if err != nil {
+		return hexutil.Encode(b)
+	}
+	return addr
+}
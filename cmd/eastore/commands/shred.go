@@ -0,0 +1,346 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/eastore-project/eastore/pkg/dealdb"
+	"github.com/eastore-project/eastore/pkg/keyvault"
+	"github.com/eastore-project/eastore/pkg/shredlog"
+	"github.com/urfave/cli/v2"
+)
+
+// ShredCommand returns the CLI command for destroying the keys of stored data
+func ShredCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "shred",
+		Usage:     "Destroy the data keys matching a CID, file ID or key reference, so that stored deals can no longer be decrypted",
+		ArgsUsage: "<id>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "yes",
+				Usage: "Destroy the keys; without it, shred only shows what would be destroyed (default: false)",
+			},
+			&cli.BoolFlag{
+				Name:  "force",
+				Usage: "Also delete keys that can be derived again from the wallet, a passphrase or a file header; their deals are not marked as erased (default: false)",
+			},
+		},
+		Action: shredAction,
+		Subcommands: []*cli.Command{
+			{
+				Name:   "log",
+				Usage:  "Verify the hash chain of the shred log and list its records",
+				Action: shredLogAction,
+			},
+		},
+	}
+}
+
+// shredPlan is what a shred destroys
+type shredPlan struct {
+	entries []*keyvault.Entry
+	// manifests are the existing key manifests holding wrapped copies of the keys
+	manifests []string
+	// deals are the deals whose keys are all destroyed
+	deals []*dealdb.Deal
+	// partial are the deals that keep other keys
+	partial []*dealdb.Deal
+	// derivable are the deals whose keys can be derived again
+	derivable []*dealdb.Deal
+}
+
+func shredAction(cCtx *cli.Context) error {
+	id := cCtx.Args().First()
+	if id == "" {
+		return fmt.Errorf("missing CID, file ID or key reference of the keys to shred")
+	}
+
+	logPath, err := repoPath(cCtx, shredlog.FileName)
+	if err != nil {
+		return err
+	}
+	// A broken log is reported before anything is destroyed
+	records, err := shredlog.Read(logPath)
+	if err != nil {
+		return err
+	}
+	if _, err := shredlog.Verify(records); err != nil {
+		return err
+	}
+
+	vault, err := openKeyVault(cCtx, false)
+	if err != nil {
+		return err
+	}
+	if vault == nil {
+		return fmt.Errorf("%w: %s (there is no key vault)", keyvault.ErrNotFound, id)
+	}
+	db, err := openDealDB(cCtx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	plan, err := planShred(vault, db, id)
+	if err != nil {
+		return err
+	}
+	printShredPlan(plan)
+
+	var derivable []string
+	for _, e := range plan.entries {
+		if !e.Erasable() {
+			derivable = append(derivable, e.KeyRef)
+		}
+	}
+	if len(derivable) > 0 && !cCtx.Bool("force") {
+		return fmt.Errorf("keys %s can be derived again from the wallet, a passphrase or a file header, so destroying them does not erase the data; pass --force to delete the stored copies anyway", strings.Join(derivable, ", "))
+	}
+	if !cCtx.Bool("yes") {
+		fmt.Println("\nNothing was destroyed; pass --yes to destroy these keys. This cannot be undone.")
+		return nil
+	}
+
+	// The shred is logged before anything is destroyed, and completed afterwards
+	pending := newShredRecord(id, plan)
+	if err := shredlog.Append(logPath, pending); err != nil {
+		return fmt.Errorf("failed to write the shred log, nothing was destroyed: %w", err)
+	}
+
+	done := &shredlog.Record{Request: id, Status: shredlog.StatusDone, Completes: pending.Seq}
+	if err := destroyKeys(vault, id, plan); err != nil {
+		done.Status = shredlog.StatusFailed
+		done.Error = err.Error()
+		if logErr := shredlog.Append(logPath, done); logErr != nil {
+			return fmt.Errorf("%w; the failure of shred log record %d was not logged: %v", err, pending.Seq, logErr)
+		}
+		return fmt.Errorf("shred log record %d failed: %w", pending.Seq, err)
+	}
+	if err := shredlog.Append(logPath, done); err != nil {
+		return fmt.Errorf("keys were destroyed as logged in shred log record %d, but its completion was not logged: %w", pending.Seq, err)
+	}
+
+	now := time.Now().UTC()
+	for _, deal := range plan.deals {
+		err := db.Update(deal.ID, func(d *dealdb.Deal) error {
+			d.ErasedAt = now
+			d.ErasureRecord = done.Hash
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to mark deal %d as erased: %w", deal.ID, err)
+		}
+	}
+
+	fmt.Printf("\nDestroyed %d keys\n", len(plan.entries))
+	fmt.Printf("Shred log records: %d, completed by %d (%s)\n", pending.Seq, done.Seq, done.Hash)
+	return nil
+}
+
+// newShredRecord returns the pending shred log record of a plan
+func newShredRecord(id string, plan *shredPlan) *shredlog.Record {
+	record := &shredlog.Record{Request: id, Manifests: plan.manifests, Status: shredlog.StatusPending}
+	for _, e := range plan.entries {
+		record.Keys = append(record.Keys, shredlog.Key{
+			KeyRef:       e.KeyRef,
+			FileID:       e.FileID,
+			PlaintextCID: e.PlaintextCID,
+			PayloadCID:   e.PayloadCID,
+			PieceCID:     e.PieceCID,
+			KDF:          e.KDF,
+			Erasable:     e.Erasable(),
+		})
+	}
+	for _, deal := range plan.deals {
+		record.Deals = append(record.Deals, deal.ID)
+	}
+	return record
+}
+
+// destroyKeys destroys the key manifests of a plan and deletes its keys from the vault
+func destroyKeys(vault *keyvault.Vault, id string, plan *shredPlan) error {
+	for _, path := range plan.manifests {
+		if err := destroyFile(path); err != nil {
+			return err
+		}
+	}
+	vault.Delete(id)
+	return vault.Save()
+}
+
+// planShred finds the keys matching id, their key manifests and the deals they
+// belong to. A deal is erased when all of its keys are destroyed and none of them
+// can be derived again
+func planShred(vault *keyvault.Vault, db *dealdb.DB, id string) (*shredPlan, error) {
+	plan := &shredPlan{entries: vault.Lookup(id)}
+	if len(plan.entries) == 0 {
+		return nil, fmt.Errorf("%w: %s", keyvault.ErrNotFound, id)
+	}
+	for _, e := range plan.entries {
+		if e.KeyManifest != "" && fileExists(e.KeyManifest) {
+			plan.manifests = append(plan.manifests, e.KeyManifest)
+		}
+	}
+
+	deals, err := db.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, deal := range deals {
+		shredded, erasable := 0, true
+		for _, e := range plan.entries {
+			if dealHasKey(deal, e) {
+				shredded++
+				erasable = erasable && e.Erasable()
+			}
+		}
+		if shredded == 0 {
+			continue
+		}
+
+		kept := false
+		for _, e := range vault.Entries() {
+			if dealHasKey(deal, e) && !e.Matches(id) {
+				kept = true
+			}
+		}
+		switch {
+		case kept:
+			plan.partial = append(plan.partial, deal)
+		case erasable:
+			plan.deals = append(plan.deals, deal)
+		default:
+			plan.derivable = append(plan.derivable, deal)
+		}
+	}
+	return plan, nil
+}
+
+// dealHasKey reports whether the key of the entry encrypts data stored in the deal
+func dealHasKey(deal *dealdb.Deal, e *keyvault.Entry) bool {
+	return (deal.KeyRef != "" && deal.KeyRef == e.KeyRef) ||
+		(deal.PieceCID != "" && deal.PieceCID == e.PieceCID)
+}
+
+func printShredPlan(plan *shredPlan) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY REF\tKDF\tERASABLE\tPATH\tPIECE CID")
+	for _, e := range plan.entries {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n",
+			e.KeyRef,
+			valueOrDash(e.KDF),
+			e.Erasable(),
+			valueOrDash(e.Path),
+			valueOrDash(e.PieceCID),
+		)
+	}
+	w.Flush()
+
+	for _, path := range plan.manifests {
+		fmt.Printf("Key manifest: %s\n", path)
+	}
+	for _, deal := range plan.deals {
+		fmt.Printf("Deal %d (%s) will be marked as erased\n", deal.ID, deal.PieceCID)
+	}
+	for _, deal := range plan.partial {
+		fmt.Printf("Deal %d (%s) keeps the keys of its other files\n", deal.ID, deal.PieceCID)
+	}
+	for _, deal := range plan.derivable {
+		fmt.Printf("Deal %d (%s) is not erased, since its keys can be derived again\n", deal.ID, deal.PieceCID)
+	}
+}
+
+// destroyFile overwrites a file with zeros and removes it. A file that is already
+// gone is not an error
+func destroyFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	info, err := f.Stat()
+	if err == nil {
+		_, err = f.Write(make([]byte, info.Size()))
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to overwrite %s: %w", path, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	return nil
+}
+
+func shredLogAction(cCtx *cli.Context) error {
+	path, err := repoPath(cCtx, shredlog.FileName)
+	if err != nil {
+		return err
+	}
+	records, err := shredlog.Read(path)
+	if err != nil {
+		return err
+	}
+	head, err := shredlog.Verify(records)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Println("No keys shredded")
+		return nil
+	}
+
+	incomplete := map[uint64]bool{}
+	for _, r := range shredlog.Incomplete(records) {
+		incomplete[r.Seq] = true
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SEQ\tTIME\tREQUEST\tSTATUS\tKEYS\tERASED DEALS\tHASH")
+	for _, r := range records {
+		status := r.Status
+		switch {
+		case incomplete[r.Seq]:
+			status = "incomplete"
+		case r.Completes != 0:
+			status = fmt.Sprintf("%s (record %d)", r.Status, r.Completes)
+		}
+		var keys, deals []string
+		for _, k := range r.Keys {
+			keys = append(keys, k.KeyRef)
+		}
+		for _, id := range r.Deals {
+			deals = append(deals, fmt.Sprint(id))
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Seq,
+			r.Time.Format("2006-01-02 15:04"),
+			r.Request,
+			valueOrDash(status),
+			valueOrDash(strings.Join(keys, ",")),
+			valueOrDash(strings.Join(deals, ",")),
+			r.Hash,
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nHash chain verified: %d records, head %s\n", len(records), head)
+	if len(incomplete) > 0 {
+		fmt.Printf("%d shreds were logged but never completed; their keys may not have been destroyed\n", len(incomplete))
+	}
+	return nil
+}
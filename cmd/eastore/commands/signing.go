@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/eastore-project/eastore/pkg/chain"
+	"github.com/eastore-project/eastore/pkg/encryption"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/urfave/cli/v2"
+)
+
+// DefaultSigningScheme is the default way of signing the message an encryption key is
+// derived from. Without a chain ID for its domain, personal-sign is used instead
+const DefaultSigningScheme = "eip712"
+
+// signingFlags are the flags that select how encryption keys are signed for
+func signingFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "signing",
+			Usage:   "How the wallet signs the file CID to derive its key: eip712 (typed data in the Eastore domain) or personal-sign (legacy); by default, eip712 falls back to personal-sign when neither --chain-id nor --rpc-url is given",
+			Value:   DefaultSigningScheme,
+			EnvVars: []string{"SIGNING_SCHEME"},
+		},
+		&cli.Uint64Flag{
+			Name:    "chain-id",
+			Usage:   "Chain ID of the EIP-712 signing domain (default: fetched from --rpc-url)",
+			EnvVars: []string{"CHAIN_ID"},
+		},
+	}
+}
+
+// keySigning returns how to sign for encryption keys, as selected by signingFlags.
+// The EIP-712 domain is made of the chain ID and the --contract address, if set. If
+// the scheme was not chosen explicitly and no chain ID is available, it falls back to
+// personal-sign, so that offline use keeps working
+func keySigning(cCtx *cli.Context) (*encryption.KeySigning, error) {
+	switch cCtx.String("signing") {
+	case "personal-sign":
+		return &encryption.KeySigning{Scheme: encryption.SchemePersonalSign}, nil
+	case "eip712":
+	default:
+		return nil, fmt.Errorf("unsupported signing scheme %q", cCtx.String("signing"))
+	}
+
+	signing := &encryption.KeySigning{
+		Scheme:  encryption.SchemeEIP712,
+		ChainID: cCtx.Uint64("chain-id"),
+	}
+	if signing.ChainID == 0 {
+		if cCtx.String("rpc-url") == "" {
+			if cCtx.IsSet("signing") {
+				return nil, fmt.Errorf("EIP-712 signing needs the chain ID: pass --chain-id or --rpc-url, or use --signing personal-sign")
+			}
+			fmt.Fprintln(os.Stderr, "Warning: no --chain-id or --rpc-url for EIP-712 signing; deriving the key with personal-sign instead")
+			return &encryption.KeySigning{Scheme: encryption.SchemePersonalSign}, nil
+		}
+		chainID, err := chain.GetChainID(cCtx.Context, cCtx.String("rpc-url"))
+		if err != nil {
+			return nil, err
+		}
+		signing.ChainID = chainID
+	}
+
+	if contract := cCtx.String("contract"); contract != "" {
+		if !common.IsHexAddress(contract) {
+			return nil, fmt.Errorf("invalid contract address %q", contract)
+		}
+		signing.Contract = common.HexToAddress(contract)
+	}
+	return signing, nil
+}
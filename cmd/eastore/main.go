@@ -19,10 +19,9 @@ func main() {
 		Version: version,
 		Flags: []cli.Flag{
 			&cli.StringFlag{
-				Name:     "private-key",
-				EnvVars:  []string{"PRIVATE_KEY"},
-				Required: true,
-				Usage:    "Private key for signing transactions",
+				Name:    "private-key",
+				EnvVars: []string{"PRIVATE_KEY"},
+				Usage:   "Private key for signing transactions and deriving encryption keys",
 			},
 			&cli.StringFlag{
 				Name:    "rpc-url",
@@ -34,16 +33,35 @@ func main() {
 				EnvVars: []string{"EASTORE_CONTRACT_ADDRESS"},
 				Usage:   "Eastore contract address",
 			},
+			&cli.StringFlag{
+				Name:    "repo",
+				EnvVars: []string{"EASTORE_REPO"},
+				Value:   commands.DefaultRepo,
+				Usage:   "Directory for local state such as the deal database",
+			},
 		},
 		Commands: []*cli.Command{
 			commands.VersionCommand(version),
 			commands.MakeDealCommand(),
+			commands.MakeBatchDealCommand(),
+			commands.DealStatusCommand(),
+			commands.ShowProposalCommand(),
+			commands.BalanceCommand(),
+			commands.DealsCommand(),
 			commands.EncryptCommand(),
 			commands.DecryptCommand(),
+			commands.RekeyCommand(),
+			commands.ShareKeyCommand(),
+			commands.PubkeyCommand(),
+			commands.KeysCommand(),
+			commands.ShredCommand(),
 		},
 	}
 
 	if err := app.Run(os.Args); err != nil {
+		if hint := commands.ErrorHint(err); hint != "" {
+			log.Fatalf("%v\nhint: %s", err, hint)
+		}
 		log.Fatal(err)
 	}
 }
@@ -22,3 +22,19 @@ func GetChainHead(ctx context.Context, rpcURL string) (int64, error) {
 
 	return header.Number.Int64(), nil
 }
+
+// GetChainID fetches the chain ID of the network
+func GetChainID(ctx context.Context, rpcURL string) (uint64, error) {
+	client, err := ethclient.Dial(rpcURL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to Ethereum client: %w", err)
+	}
+	defer client.Close()
+
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch chain ID: %w", err)
+	}
+
+	return chainID.Uint64(), nil
+}
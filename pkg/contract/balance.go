@@ -0,0 +1,106 @@
+package contract
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/filecoin-project/go-address"
+)
+
+// MarketBalance is the storage market escrow of an account
+type MarketBalance struct {
+	Escrow *big.Int
+	Locked *big.Int
+}
+
+// Available returns the part of the escrow that is not locked in deals
+func (b *MarketBalance) Available() *big.Int {
+	return new(big.Int).Sub(b.Escrow, b.Locked)
+}
+
+// AddBalancePayable reports whether the contract's addBalance accepts FIL with the
+// call. If it does not, addBalance moves FIL from the contract's own balance instead
+func (d *DealClient) AddBalancePayable() bool {
+	return d.abi.Methods["addBalance"].IsPayable()
+}
+
+// AddBalance adds value attoFIL to the contract's storage market escrow. If addBalance
+// is payable, the value is sent from the wallet with the call; otherwise the contract
+// funds the escrow from its own balance, and an amount above that balance is refused
+// before any transaction is sent
+func (d *DealClient) AddBalance(ctx context.Context, value *big.Int) (common.Hash, error) {
+	opts := *d.auth
+	opts.Context = ctx
+	if d.AddBalancePayable() {
+		opts.Value = value
+	} else {
+		balance, err := d.ContractBalance(ctx)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		if balance.Cmp(value) < 0 {
+			return common.Hash{}, fmt.Errorf("the contract's addBalance is not payable, so the amount cannot be sent from the wallet; it moves FIL from the contract's own balance of %s attoFIL, which is below %s attoFIL: send FIL to the contract %s first", balance, value, d.contractAddr.Hex())
+		}
+	}
+
+	tx, err := d.contract.Transact(&opts, "addBalance", value)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to send transaction: %w", d.wrapError(err))
+	}
+	return tx.Hash(), nil
+}
+
+// WithdrawBalance withdraws value attoFIL from the storage market escrow of client
+func (d *DealClient) WithdrawBalance(ctx context.Context, client common.Address, value *big.Int) (common.Hash, error) {
+	d.auth.Context = ctx
+
+	tx, err := d.contract.Transact(d.auth, "withdrawBalance", client, value)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to send transaction: %w", d.wrapError(err))
+	}
+	return tx.Hash(), nil
+}
+
+// ContractAddress returns the address of the DealClient contract
+func (d *DealClient) ContractAddress() common.Address {
+	return d.contractAddr
+}
+
+// ContractBalance returns the FIL held by the contract itself, which is what a
+// non-payable addBalance moves into escrow
+func (d *DealClient) ContractBalance(ctx context.Context) (*big.Int, error) {
+	balance, err := d.client.BalanceAt(ctx, d.contractAddr, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contract balance: %w", err)
+	}
+	return balance, nil
+}
+
+// MarketBalance returns the storage market escrow of an account, queried through
+// the Filecoin JSON-RPC API of the node
+func (d *DealClient) MarketBalance(ctx context.Context, account common.Address) (*MarketBalance, error) {
+	filAddr, err := address.NewDelegatedAddress(10, account.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert %s to a Filecoin address: %w", account.Hex(), err)
+	}
+
+	var res struct {
+		Escrow string
+		Locked string
+	}
+	if err := d.client.Client().CallContext(ctx, &res, "Filecoin.StateMarketBalance", filAddr.String(), nil); err != nil {
+		return nil, fmt.Errorf("failed to get market balance: %w", err)
+	}
+
+	escrow, ok := new(big.Int).SetString(res.Escrow, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid escrow balance %q", res.Escrow)
+	}
+	locked, ok := new(big.Int).SetString(res.Locked, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid locked balance %q", res.Locked)
+	}
+	return &MarketBalance{Escrow: escrow, Locked: locked}, nil
+}
@@ -75,3 +75,12 @@ func (c *DealClient) SignMessage(message string) ([]byte, error) {
 
 	return signature, nil
 }
+
+// ChainHead returns the current chain head epoch
+func (c *DealClient) ChainHead(ctx context.Context) (int64, error) {
+	head, err := c.client.BlockNumber(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch chain head: %w", err)
+	}
+	return int64(head), nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"github.com/eastore-project/eastore/pkg/types"
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 )
 
@@ -14,7 +15,36 @@ func (d *DealClient) MakeDealProposal(ctx context.Context, deal types.DealReques
 
 	tx, err := d.contract.Transact(d.auth, "makeDealProposal", deal)
 	if err != nil {
-		return common.Hash{}, fmt.Errorf("failed to send transaction: %w", err)
+		return common.Hash{}, fmt.Errorf("failed to send transaction: %w", d.wrapError(err))
 	}
 	return tx.Hash(), nil
 }
+
+// MakeBatchDealProposal sends multiple deal proposals to the smart contract in a single transaction
+func (d *DealClient) MakeBatchDealProposal(ctx context.Context, deals []types.DealRequest) (common.Hash, error) {
+	d.auth.Context = ctx
+
+	tx, err := d.contract.Transact(d.auth, "makeBatchDealProposal", deals)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to send transaction: %w", d.wrapError(err))
+	}
+	return tx.Hash(), nil
+}
+
+// EstimateBatchDealProposalGas estimates the gas needed to submit the deals in a single batch transaction
+func (d *DealClient) EstimateBatchDealProposalGas(ctx context.Context, deals []types.DealRequest) (uint64, error) {
+	input, err := d.abi.Pack("makeBatchDealProposal", deals)
+	if err != nil {
+		return 0, fmt.Errorf("failed to pack batch deal proposal: %w", err)
+	}
+
+	gas, err := d.client.EstimateGas(ctx, ethereum.CallMsg{
+		From: d.auth.From,
+		To:   &d.contractAddr,
+		Data: input,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to estimate gas: %w", d.wrapError(err))
+	}
+	return gas, nil
+}
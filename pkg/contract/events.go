@@ -0,0 +1,199 @@
+package contract
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+// receiptPollInterval is how often the client polls for a transaction receipt
+const receiptPollInterval = 5 * time.Second
+
+// DealProposalEvent is a decoded DealProposalCreate event
+type DealProposalEvent struct {
+	ID       common.Hash
+	Size     uint64
+	Verified bool
+	Price    *big.Int
+}
+
+// ProposalReceipt summarises a mined deal proposal transaction
+type ProposalReceipt struct {
+	TxHash      common.Hash
+	BlockNumber uint64
+	GasUsed     uint64
+	Proposals   []DealProposalEvent
+}
+
+// RevertError is returned when a transaction was mined but reverted. Err holds the
+// decoded revert reason, or nil if the node could not provide it
+type RevertError struct {
+	TxHash common.Hash
+	Err    error
+}
+
+func (e *RevertError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("transaction %s reverted", e.TxHash.Hex())
+	}
+	return fmt.Sprintf("transaction %s reverted: %v", e.TxHash.Hex(), e.Err)
+}
+
+func (e *RevertError) Unwrap() error {
+	return e.Err
+}
+
+// WaitForReceipt polls the chain until the transaction is mined and buried under
+// the given number of confirmations. A confirmation depth of 0 or 1 returns as soon
+// as the transaction is included in a block
+func (d *DealClient) WaitForReceipt(ctx context.Context, txHash common.Hash, confirmations uint64) (*ethtypes.Receipt, error) {
+	ticker := time.NewTicker(receiptPollInterval)
+	defer ticker.Stop()
+
+	for {
+		receipt, err := d.confirmedReceipt(ctx, txHash, confirmations)
+		if err != nil {
+			return nil, err
+		}
+		if receipt != nil {
+			return receipt, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// TransactionReceipt returns the receipt of a mined transaction. The error wraps
+// ethereum.NotFound if the transaction is still pending
+func (d *DealClient) TransactionReceipt(ctx context.Context, txHash common.Hash) (*ethtypes.Receipt, error) {
+	receipt, err := d.client.TransactionReceipt(ctx, txHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction receipt: %w", err)
+	}
+	return receipt, nil
+}
+
+// confirmedReceipt returns the receipt of the transaction if it has reached the
+// requested confirmation depth, or nil if the caller should keep waiting
+func (d *DealClient) confirmedReceipt(ctx context.Context, txHash common.Hash, confirmations uint64) (*ethtypes.Receipt, error) {
+	receipt, err := d.client.TransactionReceipt(ctx, txHash)
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transaction receipt: %w", err)
+	}
+
+	if confirmations <= 1 {
+		return receipt, nil
+	}
+
+	head, err := d.client.BlockNumber(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chain head: %w", err)
+	}
+	if head+1 < receipt.BlockNumber.Uint64()+confirmations {
+		return nil, nil
+	}
+	return receipt, nil
+}
+
+// WaitForSuccess waits for a transaction to be confirmed and checks that it succeeded.
+// A reverted transaction is reported as a *RevertError carrying the decoded revert
+// reason when the node can provide it
+func (d *DealClient) WaitForSuccess(ctx context.Context, txHash common.Hash, confirmations uint64) (*ethtypes.Receipt, error) {
+	receipt, err := d.WaitForReceipt(ctx, txHash, confirmations)
+	if err != nil {
+		return nil, err
+	}
+
+	if receipt.Status != ethtypes.ReceiptStatusSuccessful {
+		return nil, &RevertError{
+			TxHash: txHash,
+			Err:    d.revertReason(ctx, txHash, receipt.BlockNumber),
+		}
+	}
+	return receipt, nil
+}
+
+// WaitForProposals waits for a deal proposal transaction to be confirmed and decodes
+// the proposals it created
+func (d *DealClient) WaitForProposals(ctx context.Context, txHash common.Hash, confirmations uint64) (*ProposalReceipt, error) {
+	receipt, err := d.WaitForSuccess(ctx, txHash, confirmations)
+	if err != nil {
+		return nil, err
+	}
+
+	proposals, err := d.ProposalEvents(receipt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProposalReceipt{
+		TxHash:      txHash,
+		BlockNumber: receipt.BlockNumber.Uint64(),
+		GasUsed:     receipt.GasUsed,
+		Proposals:   proposals,
+	}, nil
+}
+
+// ProposalEvents decodes the DealProposalCreate events in a receipt, in the order
+// the contract emitted them
+func (d *DealClient) ProposalEvents(receipt *ethtypes.Receipt) ([]DealProposalEvent, error) {
+	event := d.abi.Events["DealProposalCreate"]
+
+	var proposals []DealProposalEvent
+	for _, log := range receipt.Logs {
+		if log.Address != d.contractAddr || len(log.Topics) != 3 || log.Topics[0] != event.ID {
+			continue
+		}
+
+		var data struct {
+			Size  uint64
+			Price *big.Int
+		}
+		if err := d.abi.UnpackIntoInterface(&data, event.Name, log.Data); err != nil {
+			return nil, fmt.Errorf("failed to decode DealProposalCreate data: %w", err)
+		}
+
+		// id and verified are indexed and therefore carried in the topics
+		proposals = append(proposals, DealProposalEvent{
+			ID:       log.Topics[1],
+			Size:     data.Size,
+			Verified: log.Topics[2].Big().Sign() != 0,
+			Price:    data.Price,
+		})
+	}
+	return proposals, nil
+}
+
+// revertReason replays a reverted transaction against the state before its block
+// to recover the revert reason. It returns nil if the reason is unknown
+func (d *DealClient) revertReason(ctx context.Context, txHash common.Hash, blockNumber *big.Int) error {
+	tx, _, err := d.client.TransactionByHash(ctx, txHash)
+	if err != nil {
+		return nil
+	}
+
+	_, err = d.client.CallContract(ctx, ethereum.CallMsg{
+		From:  d.auth.From,
+		To:    tx.To(),
+		Gas:   tx.Gas(),
+		Value: tx.Value(),
+		Data:  tx.Data(),
+	}, new(big.Int).Sub(blockNumber, big.NewInt(1)))
+	if err == nil {
+		return nil
+	}
+	return d.wrapError(err)
+}
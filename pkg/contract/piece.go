@@ -0,0 +1,126 @@
+package contract
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/eastore-project/eastore/pkg/types"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// PieceInfo is the on-chain state the contract tracks for a piece
+type PieceInfo struct {
+	PieceCID      []byte
+	Status        types.DealStatus
+	DealID        uint64
+	Provider      []byte
+	HasProvider   bool
+	ProposalID    common.Hash
+	HasProposalID bool
+}
+
+// PieceStatus returns the status the contract records for a piece
+func (d *DealClient) PieceStatus(ctx context.Context, pieceCID []byte) (types.DealStatus, error) {
+	var status uint8
+	if err := d.call(ctx, &status, "pieceStatus", pieceCID); err != nil {
+		return 0, err
+	}
+	return types.DealStatus(status), nil
+}
+
+// PieceDealID returns the on-chain deal ID published for a piece, or zero if none
+func (d *DealClient) PieceDealID(ctx context.Context, pieceCID []byte) (uint64, error) {
+	var dealID uint64
+	if err := d.call(ctx, &dealID, "pieceDeals", pieceCID); err != nil {
+		return 0, err
+	}
+	return dealID, nil
+}
+
+// PieceProvider returns the Filecoin address bytes of the provider that took the piece
+func (d *DealClient) PieceProvider(ctx context.Context, pieceCID []byte) ([]byte, bool, error) {
+	var out struct {
+		Provider []byte
+		Valid    bool
+	}
+	if err := d.call(ctx, &out, "pieceProviders", pieceCID); err != nil {
+		return nil, false, err
+	}
+	return out.Provider, out.Valid, nil
+}
+
+// PieceRequest returns the proposal ID of the deal request made for a piece
+func (d *DealClient) PieceRequest(ctx context.Context, pieceCID []byte) (common.Hash, bool, error) {
+	var out struct {
+		RequestId [32]byte
+		Valid     bool
+	}
+	if err := d.call(ctx, &out, "pieceRequests", pieceCID); err != nil {
+		return common.Hash{}, false, err
+	}
+	return out.RequestId, out.Valid, nil
+}
+
+// GetPieceInfo collects everything the contract tracks for a piece
+func (d *DealClient) GetPieceInfo(ctx context.Context, pieceCID []byte) (*PieceInfo, error) {
+	info := &PieceInfo{PieceCID: pieceCID}
+
+	var err error
+	if info.Status, err = d.PieceStatus(ctx, pieceCID); err != nil {
+		return nil, err
+	}
+	if info.DealID, err = d.PieceDealID(ctx, pieceCID); err != nil {
+		return nil, err
+	}
+	if info.Provider, info.HasProvider, err = d.PieceProvider(ctx, pieceCID); err != nil {
+		return nil, err
+	}
+	if info.ProposalID, info.HasProposalID, err = d.PieceRequest(ctx, pieceCID); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// GetDealRequest returns the deal request stored by the contract for a proposal ID.
+// The boolean result is false if the contract does not know the proposal
+func (d *DealClient) GetDealRequest(ctx context.Context, proposalID common.Hash) (types.DealRequest, bool, error) {
+	var idx struct {
+		Idx   *big.Int
+		Valid bool
+	}
+	if err := d.call(ctx, &idx, "dealRequestIdx", proposalID); err != nil {
+		return types.DealRequest{}, false, err
+	}
+	if !idx.Valid {
+		return types.DealRequest{}, false, nil
+	}
+
+	var deal types.DealRequest
+	if err := d.call(ctx, &deal, "dealRequests", idx.Idx); err != nil {
+		return types.DealRequest{}, false, err
+	}
+	return deal, true, nil
+}
+
+// call invokes a view method of the contract and unpacks its outputs into out
+func (d *DealClient) call(ctx context.Context, out interface{}, method string, args ...interface{}) error {
+	results := []interface{}{out}
+	if err := d.contract.Call(&bind.CallOpts{Context: ctx}, &results, method, args...); err != nil {
+		return fmt.Errorf("failed to call %s: %w", method, d.wrapError(err))
+	}
+	return nil
+}
+
+// UpdateActivationStatus asks the contract to refresh the activation status of a piece
+// from the storage market actor
+func (d *DealClient) UpdateActivationStatus(ctx context.Context, pieceCID []byte) (common.Hash, error) {
+	d.auth.Context = ctx
+
+	tx, err := d.contract.Transact(d.auth, "updateActivationStatus", pieceCID)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("failed to send transaction: %w", d.wrapError(err))
+	}
+	return tx.Hash(), nil
+}
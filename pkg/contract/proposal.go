@@ -0,0 +1,237 @@
+package contract
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/eastore-project/eastore/pkg/types"
+	"github.com/ethereum/go-ethereum/common"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// dealProposalFields is the number of fields in a CBOR-encoded market deal proposal
+const dealProposalFields = 11
+
+// extraParamsV1Fields is the number of fields in CBOR-encoded version 1 extra params
+const extraParamsV1Fields = 4
+
+// StoredProposal is a deal proposal as the contract hands it to the market actor
+type StoredProposal struct {
+	ProposalID common.Hash
+	Deal       types.DealRequest
+	Client     []byte
+	Provider   []byte
+}
+
+// GetStoredProposal fetches the CBOR-encoded deal proposal and extra params the
+// contract stores for a proposal ID and decodes them
+func (d *DealClient) GetStoredProposal(ctx context.Context, proposalID common.Hash) (*StoredProposal, error) {
+	var proposalData []byte
+	if err := d.call(ctx, &proposalData, "getDealProposal", proposalID); err != nil {
+		return nil, err
+	}
+
+	var extraParamsData []byte
+	if err := d.call(ctx, &extraParamsData, "getExtraParams", proposalID); err != nil {
+		return nil, err
+	}
+
+	proposal, err := DecodeDealProposal(proposalData)
+	if err != nil {
+		return nil, err
+	}
+	proposal.ProposalID = proposalID
+
+	extraParams, err := DecodeExtraParamsV1(extraParamsData)
+	if err != nil {
+		return nil, err
+	}
+	proposal.Deal.ExtraParamsVersion = 1
+	proposal.Deal.ExtraParams = extraParams
+
+	return proposal, nil
+}
+
+// DecodeDealProposal decodes a deal proposal serialized by the contract in the
+// market actor's CBOR encoding
+func DecodeDealProposal(data []byte) (*StoredProposal, error) {
+	cr := cbg.NewCborReader(bytes.NewReader(data))
+
+	if err := readArrayHeader(cr, dealProposalFields); err != nil {
+		return nil, fmt.Errorf("failed to decode deal proposal: %w", err)
+	}
+
+	proposal := &StoredProposal{}
+	deal := &proposal.Deal
+
+	pieceCID, err := cbg.ReadCid(cr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode piece CID: %w", err)
+	}
+	deal.PieceCID = pieceCID.Bytes()
+
+	if deal.PieceSize, err = readUint(cr); err != nil {
+		return nil, fmt.Errorf("failed to decode piece size: %w", err)
+	}
+	if deal.VerifiedDeal, err = readBool(cr); err != nil {
+		return nil, fmt.Errorf("failed to decode verified deal: %w", err)
+	}
+	if proposal.Client, err = cbg.ReadByteArray(cr, cbg.ByteArrayMaxLen); err != nil {
+		return nil, fmt.Errorf("failed to decode client: %w", err)
+	}
+	if proposal.Provider, err = cbg.ReadByteArray(cr, cbg.ByteArrayMaxLen); err != nil {
+		return nil, fmt.Errorf("failed to decode provider: %w", err)
+	}
+	if deal.Label, err = readLabel(cr); err != nil {
+		return nil, fmt.Errorf("failed to decode label: %w", err)
+	}
+	if deal.StartEpoch, err = readInt(cr); err != nil {
+		return nil, fmt.Errorf("failed to decode start epoch: %w", err)
+	}
+	if deal.EndEpoch, err = readInt(cr); err != nil {
+		return nil, fmt.Errorf("failed to decode end epoch: %w", err)
+	}
+	if deal.StoragePricePerEpoch, err = readBigInt(cr); err != nil {
+		return nil, fmt.Errorf("failed to decode storage price: %w", err)
+	}
+	if deal.ProviderCollateral, err = readBigInt(cr); err != nil {
+		return nil, fmt.Errorf("failed to decode provider collateral: %w", err)
+	}
+	if deal.ClientCollateral, err = readBigInt(cr); err != nil {
+		return nil, fmt.Errorf("failed to decode client collateral: %w", err)
+	}
+
+	return proposal, nil
+}
+
+// DecodeExtraParamsV1 decodes version 1 extra params serialized by the contract
+func DecodeExtraParamsV1(data []byte) (types.ExtraParamsV1, error) {
+	cr := cbg.NewCborReader(bytes.NewReader(data))
+
+	var params types.ExtraParamsV1
+	if err := readArrayHeader(cr, extraParamsV1Fields); err != nil {
+		return params, fmt.Errorf("failed to decode extra params: %w", err)
+	}
+
+	var err error
+	if params.LocationRef, err = cbg.ReadString(cr); err != nil {
+		return params, fmt.Errorf("failed to decode location ref: %w", err)
+	}
+	if params.CarSize, err = readUint(cr); err != nil {
+		return params, fmt.Errorf("failed to decode car size: %w", err)
+	}
+	if params.SkipIPNIAnnounce, err = readBool(cr); err != nil {
+		return params, fmt.Errorf("failed to decode skip IPNI announce: %w", err)
+	}
+	if params.RemoveUnsealedCopy, err = readBool(cr); err != nil {
+		return params, fmt.Errorf("failed to decode remove unsealed copy: %w", err)
+	}
+
+	return params, nil
+}
+
+func readArrayHeader(cr *cbg.CborReader, fields uint64) error {
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return err
+	}
+	if maj != cbg.MajArray {
+		return fmt.Errorf("expected array, got major type %d", maj)
+	}
+	if extra != fields {
+		return fmt.Errorf("expected %d fields, got %d", fields, extra)
+	}
+	return nil
+}
+
+func readUint(cr *cbg.CborReader) (uint64, error) {
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return 0, err
+	}
+	if maj != cbg.MajUnsignedInt {
+		return 0, fmt.Errorf("expected unsigned integer, got major type %d", maj)
+	}
+	return extra, nil
+}
+
+func readInt(cr *cbg.CborReader) (int64, error) {
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return 0, err
+	}
+	if extra > 1<<63-1 {
+		return 0, fmt.Errorf("integer overflows int64")
+	}
+	switch maj {
+	case cbg.MajUnsignedInt:
+		return int64(extra), nil
+	case cbg.MajNegativeInt:
+		return -1 - int64(extra), nil
+	default:
+		return 0, fmt.Errorf("expected integer, got major type %d", maj)
+	}
+}
+
+func readBool(cr *cbg.CborReader) (bool, error) {
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return false, err
+	}
+	if maj != cbg.MajOther {
+		return false, fmt.Errorf("expected bool, got major type %d", maj)
+	}
+	switch extra {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	default:
+		return false, fmt.Errorf("expected bool, got simple value %d", extra)
+	}
+}
+
+// readLabel reads a deal label, which the market actor encodes as either a text or a byte string
+func readLabel(cr *cbg.CborReader) (string, error) {
+	maj, extra, err := cr.ReadHeader()
+	if err != nil {
+		return "", err
+	}
+	if maj != cbg.MajTextString && maj != cbg.MajByteString {
+		return "", fmt.Errorf("expected string, got major type %d", maj)
+	}
+	if extra > cbg.MaxLength {
+		return "", fmt.Errorf("label too long: %d", extra)
+	}
+
+	buf := make([]byte, extra)
+	if _, err := io.ReadFull(cr, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// readBigInt reads a Filecoin big integer: a byte string holding a sign byte
+// followed by the big-endian magnitude, with the empty string meaning zero
+func readBigInt(cr *cbg.CborReader) (*big.Int, error) {
+	b, err := cbg.ReadByteArray(cr, cbg.ByteArrayMaxLen)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return new(big.Int), nil
+	}
+
+	v := new(big.Int).SetBytes(b[1:])
+	switch b[0] {
+	case 0:
+		return v, nil
+	case 1:
+		return v.Neg(v), nil
+	default:
+		return nil, fmt.Errorf("invalid big integer sign byte %d", b[0])
+	}
+}
@@ -0,0 +1,213 @@
+package contract
+
+import (
+	"bytes"
+	"math"
+	gobig "math/big"
+	"testing"
+
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/filecoin-project/go-state-types/big"
+	"github.com/filecoin-project/go-state-types/builtin/v13/market"
+	"github.com/ipfs/go-cid"
+)
+
+var testPieceCID = cid.MustParse("baga6ea4seaqawvphrkrcvzk2wtew5kzw6d6htwcormmkq76ppd6kot5avgv5sby")
+
+func stringLabel(t *testing.T, s string) market.DealLabel {
+	t.Helper()
+	l, err := market.NewLabelFromString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func bytesLabel(t *testing.T, b []byte) market.DealLabel {
+	t.Helper()
+	l, err := market.NewLabelFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func mustBig(t *testing.T, s string) big.Int {
+	t.Helper()
+	v, err := big.FromString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// testProposals returns deal proposals as the market actor encodes them, covering
+// both label kinds and zero, negative and large big integers
+func testProposals(t *testing.T) map[string]market.DealProposal {
+	client, err := address.NewIDAddress(1001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider, err := address.NewIDAddress(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delegated, err := address.NewDelegatedAddress(10, bytes.Repeat([]byte{0xab}, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]market.DealProposal{
+		"string label": {
+			PieceCID:             testPieceCID,
+			PieceSize:            abi.PaddedPieceSize(4194304),
+			VerifiedDeal:         true,
+			Client:               client,
+			Provider:             provider,
+			Label:                stringLabel(t, "bafybeicugjouvcmd6z25qithimswdhu6hgzakiygjuezdu2bxbfjp4goha"),
+			StartEpoch:           abi.ChainEpoch(1000),
+			EndEpoch:             abi.ChainEpoch(519400),
+			StoragePricePerEpoch: big.Zero(),
+			ProviderCollateral:   big.Zero(),
+			ClientCollateral:     big.Zero(),
+		},
+		"bytes label": {
+			PieceCID:             testPieceCID,
+			PieceSize:            abi.PaddedPieceSize(2048),
+			Client:               delegated,
+			Provider:             provider,
+			Label:                bytesLabel(t, []byte{0x00, 0xff, 0xfe, 'a', 0x80}),
+			StartEpoch:           abi.ChainEpoch(0),
+			EndEpoch:             abi.ChainEpoch(1),
+			StoragePricePerEpoch: big.NewInt(1),
+			ProviderCollateral:   big.NewInt(255),
+			ClientCollateral:     big.NewInt(256),
+		},
+		"empty label": {
+			PieceCID:             testPieceCID,
+			PieceSize:            abi.PaddedPieceSize(1 << 35),
+			Client:               client,
+			Provider:             provider,
+			Label:                stringLabel(t, ""),
+			StartEpoch:           abi.ChainEpoch(23),
+			EndEpoch:             abi.ChainEpoch(24),
+			StoragePricePerEpoch: big.Zero(),
+			ProviderCollateral:   big.Zero(),
+			ClientCollateral:     big.Zero(),
+		},
+		"negative and big integers": {
+			PieceCID:             testPieceCID,
+			PieceSize:            abi.PaddedPieceSize(math.MaxUint64),
+			VerifiedDeal:         true,
+			Client:               client,
+			Provider:             provider,
+			Label:                stringLabel(t, "label"),
+			StartEpoch:           abi.ChainEpoch(math.MinInt64),
+			EndEpoch:             abi.ChainEpoch(math.MaxInt64),
+			StoragePricePerEpoch: big.NewInt(-1),
+			ProviderCollateral:   mustBig(t, "1606938044258990275541962092341162602522202993782792835301376"),
+			ClientCollateral:     mustBig(t, "-1267650600228229401496703205381"),
+		},
+	}
+}
+
+func encodeProposal(t *testing.T, p market.DealProposal) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := p.MarshalCBOR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func checkBigInt(t *testing.T, name string, got *gobig.Int, want big.Int) {
+	t.Helper()
+	if got == nil || got.Cmp(want.Int) != 0 {
+		t.Fatalf("%s = %v, want %v", name, got, want)
+	}
+}
+
+func TestDecodeDealProposal(t *testing.T) {
+	for name, want := range testProposals(t) {
+		t.Run(name, func(t *testing.T) {
+			got, err := DecodeDealProposal(encodeProposal(t, want))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			deal := got.Deal
+			if !bytes.Equal(deal.PieceCID, want.PieceCID.Bytes()) {
+				t.Fatalf("piece CID = %x, want %s", deal.PieceCID, want.PieceCID)
+			}
+			if deal.PieceSize != uint64(want.PieceSize) {
+				t.Fatalf("piece size = %d, want %d", deal.PieceSize, want.PieceSize)
+			}
+			if deal.VerifiedDeal != want.VerifiedDeal {
+				t.Fatalf("verified deal = %t, want %t", deal.VerifiedDeal, want.VerifiedDeal)
+			}
+			if !bytes.Equal(got.Client, want.Client.Bytes()) {
+				t.Fatalf("client = %x, want %x", got.Client, want.Client.Bytes())
+			}
+			if !bytes.Equal(got.Provider, want.Provider.Bytes()) {
+				t.Fatalf("provider = %x, want %x", got.Provider, want.Provider.Bytes())
+			}
+
+			var label string
+			if want.Label.IsString() {
+				label, err = want.Label.ToString()
+			} else {
+				var b []byte
+				b, err = want.Label.ToBytes()
+				label = string(b)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deal.Label != label {
+				t.Fatalf("label = %q, want %q", deal.Label, label)
+			}
+
+			if deal.StartEpoch != int64(want.StartEpoch) || deal.EndEpoch != int64(want.EndEpoch) {
+				t.Fatalf("epochs = %d-%d, want %d-%d", deal.StartEpoch, deal.EndEpoch, want.StartEpoch, want.EndEpoch)
+			}
+			checkBigInt(t, "storage price", deal.StoragePricePerEpoch, want.StoragePricePerEpoch)
+			checkBigInt(t, "provider collateral", deal.ProviderCollateral, want.ProviderCollateral)
+			checkBigInt(t, "client collateral", deal.ClientCollateral, want.ClientCollateral)
+		})
+	}
+}
+
+func TestDecodeDealProposalTruncated(t *testing.T) {
+	for name, p := range testProposals(t) {
+		t.Run(name, func(t *testing.T) {
+			data := encodeProposal(t, p)
+			for n := 0; n < len(data); n++ {
+				if _, err := DecodeDealProposal(data[:n]); err == nil {
+					t.Fatalf("decoding the first %d of %d bytes succeeded", n, len(data))
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeDealProposalInvalid(t *testing.T) {
+	p := testProposals(t)["string label"]
+	data := encodeProposal(t, p)
+
+	// The client collateral is last, and zero is encoded as an empty byte string
+	// (0x40). A one-byte value with an invalid sign byte must not decode
+	if data[len(data)-1] != 0x40 {
+		t.Fatalf("client collateral is encoded as %#x, want an empty byte string", data[len(data)-1])
+	}
+	invalidSign := append(append([]byte{}, data[:len(data)-1]...), 0x41, 0x02)
+	if _, err := DecodeDealProposal(invalidSign); err == nil {
+		t.Fatal("decoding a big integer with sign byte 2 succeeded")
+	}
+
+	// A proposal of another shape, such as a bare array of ten fields, is refused
+	short := append([]byte{0x8a}, data[1:]...)
+	if _, err := DecodeDealProposal(short); err == nil {
+		t.Fatal("decoding an array of ten fields succeeded")
+	}
+}
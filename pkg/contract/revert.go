@@ -0,0 +1,139 @@
+package contract
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// NotEnoughBalanceError is returned when the contract does not hold enough FIL
+// for the requested operation
+type NotEnoughBalanceError struct {
+	Balance *big.Int
+	Value   *big.Int
+}
+
+func (e *NotEnoughBalanceError) Error() string {
+	return fmt.Sprintf("not enough balance: escrow balance %s < required %s attoFIL", e.Balance, e.Value)
+}
+
+// ActorError is returned when a call from the contract to a Filecoin actor fails
+type ActorError struct {
+	Code *big.Int
+}
+
+func (e *ActorError) Error() string {
+	return fmt.Sprintf("filecoin actor call failed with exit code %s", e.Code)
+}
+
+// InvalidCodecError is returned when an actor response uses an unexpected codec
+type InvalidCodecError struct {
+	Codec uint64
+}
+
+func (e *InvalidCodecError) Error() string {
+	return fmt.Sprintf("actor returned data with unsupported codec 0x%x", e.Codec)
+}
+
+// ContractError is a custom error of the contract that carries no arguments, such as
+// NotApprovedByGateway or ActorNotFound
+type ContractError struct {
+	Name string
+}
+
+func (e *ContractError) Error() string {
+	if hint, ok := contractErrorHints[e.Name]; ok {
+		return fmt.Sprintf("%s: %s", e.Name, hint)
+	}
+	return e.Name
+}
+
+// contractErrorHints explains the argument-less custom errors of the contract
+var contractErrorHints = map[string]string{
+	"ActorNotFound":           "the target Filecoin actor does not exist",
+	"FailToCallActor":         "the call to the Filecoin actor could not be made",
+	"InvalidAddress":          "an address passed to the contract is invalid",
+	"InvalidResponseLength":   "the Filecoin actor returned a malformed response",
+	"NegativeValueNotAllowed": "the value must not be negative",
+	"NotApprovedByGateway":    "the cross-chain message was not approved by the gateway",
+}
+
+// DecodeError decodes revert data returned by the contract into a typed error. It
+// handles the custom errors in the contract ABI as well as Error(string) reverts,
+// and returns nil if the data matches none of them
+func DecodeError(contractABI abi.ABI, data []byte) error {
+	if len(data) < 4 {
+		return nil
+	}
+
+	if reason, err := abi.UnpackRevert(data); err == nil {
+		return errors.New(reason)
+	}
+
+	for _, abiErr := range contractABI.Errors {
+		if !bytes.Equal(data[:4], abiErr.ID[:4]) {
+			continue
+		}
+
+		// The ABI can declare an error more than once, in which case go-ethereum
+		// renames the copies (InvalidAddress0, ...); the signature keeps the name
+		name, _, _ := strings.Cut(abiErr.Sig, "(")
+		args, err := abiErr.Inputs.Unpack(data[4:])
+		if err != nil {
+			return fmt.Errorf("%s (undecodable arguments: %w)", name, err)
+		}
+
+		switch name {
+		case "NotEnoughBalance":
+			return &NotEnoughBalanceError{
+				Balance: args[0].(*big.Int),
+				Value:   args[1].(*big.Int),
+			}
+		case "ActorError":
+			return &ActorError{Code: args[0].(*big.Int)}
+		case "InvalidCodec":
+			return &InvalidCodecError{Codec: args[0].(uint64)}
+		default:
+			return &ContractError{Name: name}
+		}
+	}
+	return nil
+}
+
+// wrapError replaces a JSON-RPC error carrying revert data with the typed contract error
+// it decodes to, keeping the original error for anything it cannot decode
+func (d *DealClient) wrapError(err error) error {
+	data, ok := revertData(err)
+	if !ok {
+		return err
+	}
+	if decoded := DecodeError(d.abi, data); decoded != nil {
+		return decoded
+	}
+	return err
+}
+
+// revertData extracts the raw revert data from a JSON-RPC error, if the node returned any
+func revertData(err error) ([]byte, bool) {
+	var dataErr rpc.DataError
+	if !errors.As(err, &dataErr) {
+		return nil, false
+	}
+
+	hexData, ok := dataErr.ErrorData().(string)
+	if !ok {
+		return nil, false
+	}
+
+	data, err := hexutil.Decode(hexData)
+	if err != nil || len(data) == 0 {
+		return nil, false
+	}
+	return data, true
+}
@@ -0,0 +1,78 @@
+package contract
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/eastore-project/eastore/pkg/types"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Simulation is the outcome of executing a deal proposal without broadcasting it
+type Simulation struct {
+	Calldata   []byte
+	ProposalID common.Hash
+	Gas        uint64
+	GasTipCap  *big.Int
+	GasFeeCap  *big.Int
+}
+
+// MaxFee returns the most the transaction can cost in attoFIL at the estimated gas
+func (s *Simulation) MaxFee() *big.Int {
+	return new(big.Int).Mul(new(big.Int).SetUint64(s.Gas), s.GasFeeCap)
+}
+
+// SimulateDealProposal encodes a deal proposal, executes it with eth_call and
+// estimates its gas and fee, without sending a transaction
+func (d *DealClient) SimulateDealProposal(ctx context.Context, deal types.DealRequest) (*Simulation, error) {
+	input, err := d.abi.Pack("makeDealProposal", deal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack deal proposal: %w", err)
+	}
+
+	msg := ethereum.CallMsg{
+		From: d.auth.From,
+		To:   &d.contractAddr,
+		Data: input,
+	}
+
+	output, err := d.client.CallContract(ctx, msg, nil)
+	if err != nil {
+		return nil, fmt.Errorf("simulation failed: %w", d.wrapError(err))
+	}
+
+	var proposalID [32]byte
+	if err := d.abi.UnpackIntoInterface(&proposalID, "makeDealProposal", output); err != nil {
+		return nil, fmt.Errorf("failed to decode simulation result: %w", err)
+	}
+
+	gas, err := d.client.EstimateGas(ctx, msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to estimate gas: %w", d.wrapError(err))
+	}
+
+	gasTipCap, err := d.client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to suggest gas tip cap: %w", err)
+	}
+	head, err := d.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chain head: %w", err)
+	}
+
+	// Mirror the fee cap the transactor would use: the tip plus twice the base fee
+	gasFeeCap := new(big.Int).Set(gasTipCap)
+	if head.BaseFee != nil {
+		gasFeeCap.Add(gasFeeCap, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+	}
+
+	return &Simulation{
+		Calldata:   input,
+		ProposalID: proposalID,
+		Gas:        gas,
+		GasTipCap:  gasTipCap,
+		GasFeeCap:  gasFeeCap,
+	}, nil
+}
@@ -0,0 +1,240 @@
+package dataprep
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	carv1 "github.com/ipld/go-car"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-varint"
+)
+
+// ErrInputChanged is returned when an input file changes between building its DAG
+// and writing it to the CAR
+var ErrInputChanged = errors.New("input changed while it was being prepared")
+
+// carWriter writes the blocks of a DAG built by a builder as a CAR. Internal nodes
+// come from memory and raw leaves are read again from the input files; in a
+// depth-first walk, the leaves of a file appear in file order, so each file is read
+// sequentially
+type carWriter struct {
+	ctx   context.Context
+	b     *builder
+	w     io.Writer
+	seen  map[cid.Cid]bool
+	index bool
+	// offset is the position in the CARv1 data, as recorded in the index
+	offset  uint64
+	records []index.Record
+}
+
+// writeCar writes the DAG below root as a CARv1, or as a CARv2 with a multihash
+// index after the CARv1 data
+func writeCar(ctx context.Context, b *builder, root cid.Cid, version int, w io.Writer) error {
+	header := &carv1.CarHeader{Roots: []cid.Cid{root}, Version: 1}
+	cw := &carWriter{ctx: ctx, b: b, w: w, seen: map[cid.Cid]bool{}, index: version == 2}
+
+	if version == 2 {
+		headerSize, err := carv1.HeaderSize(header)
+		if err != nil {
+			return err
+		}
+		dataSize := headerSize + b.dataSize(root)
+		if _, err := w.Write(carv2.Pragma); err != nil {
+			return err
+		}
+		if _, err := carv2.NewHeader(dataSize).WriteTo(w); err != nil {
+			return err
+		}
+		cw.offset = headerSize
+		if err := carv1.WriteHeader(header, w); err != nil {
+			return fmt.Errorf("failed to write CAR header: %w", err)
+		}
+		if err := cw.walk(root, nil); err != nil {
+			return err
+		}
+		if cw.offset != dataSize {
+			return fmt.Errorf("CAR data is %d bytes instead of the %d recorded in its header", cw.offset, dataSize)
+		}
+
+		idx := index.NewMultihashSorted()
+		if err := idx.Load(cw.records); err != nil {
+			return fmt.Errorf("failed to build CAR index: %w", err)
+		}
+		if _, err := index.WriteTo(idx, w); err != nil {
+			return fmt.Errorf("failed to write CAR index: %w", err)
+		}
+		return nil
+	}
+
+	if err := carv1.WriteHeader(header, w); err != nil {
+		return fmt.Errorf("failed to write CAR header: %w", err)
+	}
+	return cw.walk(root, nil)
+}
+
+// walk writes the block c and the blocks below it that were not written yet. Inside
+// a file, f is positioned at the data of c
+func (cw *carWriter) walk(c cid.Cid, f io.ReadSeeker) error {
+	if f == nil {
+		if path, ok := cw.b.files[c]; ok && !cw.seen[c] {
+			return cw.walkFile(c, path)
+		}
+	}
+	if cw.seen[c] {
+		if f == nil {
+			return nil
+		}
+		// The data of a repeated block still has to be skipped in the file
+		size, err := cw.b.fileSize(c)
+		if err != nil {
+			return err
+		}
+		_, err = f.Seek(int64(size), io.SeekCurrent)
+		return err
+	}
+	cw.seen[c] = true
+
+	if c.Prefix().Codec == cid.Raw {
+		if f == nil {
+			return fmt.Errorf("raw block %s is not part of a file", c)
+		}
+		if err := cw.ctx.Err(); err != nil {
+			return err
+		}
+		data := make([]byte, cw.b.dag.raw[c])
+		if _, err := io.ReadFull(f, data); err != nil {
+			return fmt.Errorf("%w: %v", ErrInputChanged, err)
+		}
+		sum, err := c.Prefix().Sum(data)
+		if err != nil {
+			return err
+		}
+		if !sum.Equals(c) {
+			return ErrInputChanged
+		}
+		return cw.writeBlock(c, data)
+	}
+
+	node, ok := cw.b.dag.nodes[c]
+	if !ok {
+		return fmt.Errorf("block %s is missing from the DAG", c)
+	}
+	if err := cw.writeBlock(c, node.RawData()); err != nil {
+		return err
+	}
+	if f != nil {
+		// Data held in the node itself precedes the data of its children
+		if err := cw.checkNodeData(c, f); err != nil {
+			return err
+		}
+	}
+	for _, l := range node.Links() {
+		if err := cw.walk(l.Cid, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkFile writes the DAG of the file at path, whose root is c
+func (cw *carWriter) walkFile(c cid.Cid, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer f.Close()
+
+	if err := cw.walk(c, f); err != nil {
+		if errors.Is(err, ErrInputChanged) {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return err
+	}
+	if n, _ := f.Read(make([]byte, 1)); n > 0 {
+		return fmt.Errorf("%s: %w", path, ErrInputChanged)
+	}
+	return nil
+}
+
+func (cw *carWriter) checkNodeData(c cid.Cid, f io.Reader) error {
+	fsNode, err := unixfs.ExtractFSNode(cw.b.dag.nodes[c])
+	if err != nil || len(fsNode.Data()) == 0 {
+		return nil
+	}
+	data := make([]byte, len(fsNode.Data()))
+	if _, err := io.ReadFull(f, data); err != nil || !bytes.Equal(data, fsNode.Data()) {
+		return ErrInputChanged
+	}
+	return nil
+}
+
+// writeBlock writes a CAR section: the varint length of the CID and data, the CID
+// and the data
+func (cw *carWriter) writeBlock(c cid.Cid, data []byte) error {
+	if cw.index {
+		cw.records = append(cw.records, index.Record{Cid: c, Offset: cw.offset})
+	}
+	n := uint64(len(c.Bytes()) + len(data))
+	if _, err := cw.w.Write(varint.ToUvarint(n)); err != nil {
+		return err
+	}
+	if _, err := cw.w.Write(c.Bytes()); err != nil {
+		return err
+	}
+	if _, err := cw.w.Write(data); err != nil {
+		return err
+	}
+	cw.offset += sectionSize(c, len(data))
+	return nil
+}
+
+// sectionSize returns the size of the CAR section of a block
+func sectionSize(c cid.Cid, size int) uint64 {
+	n := uint64(len(c.Bytes()) + size)
+	return uint64(varint.UvarintSize(n)) + n
+}
+
+// dataSize returns the size of the CAR sections of the unique blocks below root,
+// without reading the input files
+func (b *builder) dataSize(root cid.Cid) uint64 {
+	seen := map[cid.Cid]bool{}
+	var size uint64
+	var walk func(c cid.Cid)
+	walk = func(c cid.Cid) {
+		if seen[c] {
+			return
+		}
+		seen[c] = true
+		if n, ok := b.dag.raw[c]; ok {
+			size += sectionSize(c, n)
+			return
+		}
+		node := b.dag.nodes[c]
+		size += sectionSize(c, len(node.RawData()))
+		for _, l := range node.Links() {
+			walk(l.Cid)
+		}
+	}
+	walk(root)
+	return size
+}
+
+// fileSize returns the number of file bytes held by the block c and its children
+func (b *builder) fileSize(c cid.Cid) (uint64, error) {
+	if n, ok := b.dag.raw[c]; ok {
+		return uint64(n), nil
+	}
+	fsNode, err := unixfs.ExtractFSNode(b.dag.nodes[c])
+	if err != nil {
+		return 0, fmt.Errorf("failed to read file node %s: %w", c, err)
+	}
+	return fsNode.FileSize(), nil
+}
@@ -0,0 +1,236 @@
+package dataprep
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	chunker "github.com/ipfs/boxo/chunker"
+	"github.com/ipfs/boxo/ipld/unixfs/importer/balanced"
+	"github.com/ipfs/boxo/ipld/unixfs/importer/helpers"
+	"github.com/ipfs/boxo/ipld/unixfs/importer/trickle"
+	uio "github.com/ipfs/boxo/ipld/unixfs/io"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	"github.com/multiformats/go-multihash"
+)
+
+// cidBuilder builds the CIDv1 of the dag-pb nodes, with SHA2-256 hashes
+var cidBuilder = cid.V1Builder{Codec: cid.DagProtobuf, MhType: multihash.SHA2_256, MhLength: -1}
+
+// memDAG is a DAG service that keeps the dag-pb nodes of a UnixFS DAG in memory.
+// Raw leaves only have their size recorded, since their data stays in the input
+// files and is read again when the CAR is written; this keeps memory use to about
+// one node per MaxLinks chunks
+type memDAG struct {
+	mu    sync.Mutex
+	nodes map[cid.Cid]format.Node
+	raw   map[cid.Cid]int
+}
+
+func newMemDAG() *memDAG {
+	return &memDAG{nodes: map[cid.Cid]format.Node{}, raw: map[cid.Cid]int{}}
+}
+
+func (d *memDAG) Get(_ context.Context, c cid.Cid) (format.Node, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if n, ok := d.nodes[c]; ok {
+		return n, nil
+	}
+	return nil, format.ErrNotFound{Cid: c}
+}
+
+func (d *memDAG) GetMany(ctx context.Context, cids []cid.Cid) <-chan *format.NodeOption {
+	out := make(chan *format.NodeOption, len(cids))
+	for _, c := range cids {
+		n, err := d.Get(ctx, c)
+		out <- &format.NodeOption{Node: n, Err: err}
+	}
+	close(out)
+	return out
+}
+
+func (d *memDAG) Add(_ context.Context, n format.Node) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if n.Cid().Prefix().Codec == cid.Raw {
+		d.raw[n.Cid()] = len(n.RawData())
+	} else {
+		d.nodes[n.Cid()] = n
+	}
+	return nil
+}
+
+func (d *memDAG) AddMany(ctx context.Context, nodes []format.Node) error {
+	for _, n := range nodes {
+		if err := d.Add(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *memDAG) Remove(_ context.Context, c cid.Cid) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.nodes, c)
+	delete(d.raw, c)
+	return nil
+}
+
+func (d *memDAG) RemoveMany(ctx context.Context, cids []cid.Cid) error {
+	for _, c := range cids {
+		if err := d.Remove(ctx, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// builder imports files and directories into a UnixFS DAG
+type builder struct {
+	dag  *memDAG
+	opts Options
+	// files maps the root of each file DAG to the path of a file with that content
+	files map[cid.Cid]string
+}
+
+func newBuilder(opts Options) *builder {
+	return &builder{dag: newMemDAG(), opts: opts, files: map[cid.Cid]string{}}
+}
+
+// add imports the file or directory at path and returns the root of its DAG
+func (b *builder) add(ctx context.Context, path string) (format.Node, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	switch {
+	case info.IsDir():
+		return b.addDir(ctx, path, map[string]bool{})
+	case info.Mode().IsRegular():
+		node, err := b.addFile(path)
+		if err != nil || b.opts.NoWrap {
+			return node, err
+		}
+		return b.wrapFile(ctx, node)
+	}
+	return nil, fmt.Errorf("cannot prepare %s: not a regular file", path)
+}
+
+func (b *builder) addFile(path string) (format.Node, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer f.Close()
+
+	params := helpers.DagBuilderParams{
+		Maxlinks:   b.opts.MaxLinks,
+		RawLeaves:  true,
+		CidBuilder: cidBuilder,
+		Dagserv:    b.dag,
+	}
+	db, err := params.New(chunker.NewSizeSplitter(f, b.opts.ChunkSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var node format.Node
+	if b.opts.Layout == LayoutTrickle {
+		node, err = trickle.Layout(db)
+	} else {
+		node, err = balanced.Layout(db)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to build DAG of %s: %w", path, err)
+	}
+	if _, ok := b.files[node.Cid()]; !ok {
+		b.files[node.Cid()] = path
+	}
+	return node, nil
+}
+
+// wrapFile returns a directory holding the DAG of a single file
+func (b *builder) wrapFile(ctx context.Context, file format.Node) (format.Node, error) {
+	dir := uio.NewDirectory(b.dag)
+	dir.SetCidBuilder(cidBuilder)
+	if err := dir.AddChild(ctx, wrapName, file); err != nil {
+		return nil, fmt.Errorf("failed to wrap file in a directory: %w", err)
+	}
+	node, err := dir.GetNode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build wrapping directory node: %w", err)
+	}
+	if err := b.dag.Add(ctx, node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// addDir imports a directory as fildeal PrepareData did: symbolic links are followed,
+// and directories without files below them are left out, except for the input
+// itself. parents holds the resolved paths of the directories above, to detect
+// symbolic links that loop
+func (b *builder) addDir(ctx context.Context, path string, parents map[string]bool) (format.Node, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+	if parents[resolved] {
+		return nil, fmt.Errorf("cannot prepare %s: symbolic link loop", path)
+	}
+	parents[resolved] = true
+	defer delete(parents, resolved)
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	dir := uio.NewDirectory(b.dag)
+	dir.SetCidBuilder(cidBuilder)
+	for _, e := range entries {
+		p := filepath.Join(path, e.Name())
+		mode := e.Type()
+		if mode&fs.ModeSymlink != 0 {
+			info, err := os.Stat(p)
+			if err != nil {
+				return nil, fmt.Errorf("failed to follow %s: %w", p, err)
+			}
+			mode = info.Mode().Type()
+		}
+
+		var child format.Node
+		switch {
+		case mode.IsDir():
+			child, err = b.addDir(ctx, p, parents)
+			if err == nil && len(child.Links()) == 0 {
+				continue
+			}
+		case mode.IsRegular():
+			child, err = b.addFile(p)
+		default:
+			err = fmt.Errorf("cannot prepare %s: not a regular file", p)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := dir.AddChild(ctx, e.Name(), child); err != nil {
+			return nil, fmt.Errorf("failed to add %s to its directory: %w", p, err)
+		}
+	}
+
+	node, err := dir.GetNode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build directory node of %s: %w", path, err)
+	}
+	if err := b.dag.Add(ctx, node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
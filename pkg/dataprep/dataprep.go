@@ -0,0 +1,201 @@
+// Package dataprep prepares data for storage deals: it builds the UnixFS DAG of a
+// file or directory, writes it as a CAR and computes the piece commitment (CommP)
+// of the CAR while it is written
+package dataprep
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	commcid "github.com/filecoin-project/go-fil-commcid"
+	commp "github.com/filecoin-project/go-fil-commp-hashhash"
+	"github.com/ipfs/boxo/ipld/unixfs/importer/helpers"
+	"github.com/ipfs/go-cid"
+)
+
+// Layout is the shape of the DAG of a file
+type Layout string
+
+const (
+	// LayoutBalanced gives every leaf the same depth, as go-ipfs does by default
+	LayoutBalanced Layout = "balanced"
+	// LayoutTrickle favours reading a file from its start, as for streaming media
+	LayoutTrickle Layout = "trickle"
+)
+
+const (
+	// DefaultChunkSize is the size of the file chunks in the leaves of a DAG
+	DefaultChunkSize = 1 << 20
+	// DefaultCarVersion is the CAR version written by default
+	DefaultCarVersion = 1
+)
+
+// DefaultMaxLinks is the default number of links of an internal file node for FileCID
+var DefaultMaxLinks = helpers.DefaultLinksPerBlock
+
+// DealMaxLinks is the default number of links of an internal file node for Prepare,
+// as in the DAGs built by fildeal PrepareData
+const DealMaxLinks = 1024
+
+// wrapName is the name of a single file in the directory that wraps it, which is the
+// path of the file relative to itself as fildeal PrepareData recorded it
+const wrapName = "."
+
+// bufSize is the size of the write buffer, a multiple of the 127-byte units that
+// CommP is computed over
+const bufSize = (4 << 20) / 128 * 127
+
+// Options controls how data is prepared. The zero value gives the defaults: Prepare
+// builds the same DAG and CAR as fildeal PrepareData, so payload and piece CIDs do not
+// change, and FileCID gives the same CID as utils.CalculateFileCID
+type Options struct {
+	// ChunkSize is the size of the file chunks, at most 1 MiB
+	ChunkSize int64
+	// MaxLinks is the number of links of an internal file node
+	MaxLinks int
+	// NoWrap makes a single file the root of the DAG. By default, it is wrapped in a
+	// directory, as fildeal PrepareData did, so the payload CID is not the file CID
+	NoWrap bool
+	// Layout is the shape of file DAGs, balanced by default
+	Layout Layout
+	// CarVersion is 1 for a CARv1, or 2 for a CARv2 with an index of its blocks
+	CarVersion int
+	// PieceSize, if set, pads the piece to this size instead of the smallest power
+	// of two that fits the CAR
+	PieceSize uint64
+}
+
+func (o Options) withDefaults(maxLinks int) (Options, error) {
+	if o.ChunkSize == 0 {
+		o.ChunkSize = DefaultChunkSize
+	}
+	if o.MaxLinks == 0 {
+		o.MaxLinks = maxLinks
+	}
+	if o.Layout == "" {
+		o.Layout = LayoutBalanced
+	}
+	if o.CarVersion == 0 {
+		o.CarVersion = DefaultCarVersion
+	}
+
+	if o.ChunkSize < 0 || o.ChunkSize > int64(helpers.BlockSizeLimit) {
+		return o, fmt.Errorf("chunk size must be between 1 and %d bytes", helpers.BlockSizeLimit)
+	}
+	if o.MaxLinks < 2 {
+		return o, fmt.Errorf("a file node needs at least 2 links")
+	}
+	if o.Layout != LayoutBalanced && o.Layout != LayoutTrickle {
+		return o, fmt.Errorf("unknown DAG layout %q", o.Layout)
+	}
+	if o.CarVersion != 1 && o.CarVersion != 2 {
+		return o, fmt.Errorf("unsupported CAR version %d", o.CarVersion)
+	}
+	return o, nil
+}
+
+// Result describes a prepared CAR file
+type Result struct {
+	// PayloadCID is the root of the UnixFS DAG
+	PayloadCID cid.Cid
+	// PieceCID is the piece commitment of the CAR
+	PieceCID cid.Cid
+	// PieceSize is the padded size of the piece
+	PieceSize uint64
+	CarSize   uint64
+	// CarPath is the CAR file, named after the piece CID
+	CarPath string
+}
+
+// Prepare builds the DAG of the file or directory at inputPath and writes it to a
+// CAR in outDir, computing its piece commitment in the same pass. The input is read
+// twice: once to build the DAG, whose internal nodes are kept in memory, and once to
+// stream its leaves into the CAR
+func Prepare(ctx context.Context, inputPath, outDir string, opts Options) (*Result, error) {
+	opts, err := opts.withDefaults(DealMaxLinks)
+	if err != nil {
+		return nil, err
+	}
+
+	b := newBuilder(opts)
+	root, err := b.add(ctx, inputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create CAR directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(outDir, ".*.car.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CAR file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	cp := &commp.Calc{}
+	counter := &countingWriter{}
+	w := bufio.NewWriterSize(io.MultiWriter(tmp, cp, counter), bufSize)
+	if err := writeCar(ctx, b, root.Cid(), opts.CarVersion, w); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write CAR file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write CAR file: %w", err)
+	}
+
+	rawCommP, pieceSize, err := cp.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute piece commitment: %w", err)
+	}
+	if opts.PieceSize > 0 && opts.PieceSize != pieceSize {
+		if rawCommP, err = commp.PadCommP(rawCommP, pieceSize, opts.PieceSize); err != nil {
+			return nil, fmt.Errorf("failed to pad piece to %d bytes: %w", opts.PieceSize, err)
+		}
+		pieceSize = opts.PieceSize
+	}
+	pieceCID, err := commcid.DataCommitmentV1ToCID(rawCommP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode piece CID: %w", err)
+	}
+
+	carPath := filepath.Join(outDir, pieceCID.String()+".car")
+	if err := os.Rename(tmp.Name(), carPath); err != nil {
+		return nil, fmt.Errorf("failed to name CAR file: %w", err)
+	}
+	return &Result{
+		PayloadCID: root.Cid(),
+		PieceCID:   pieceCID,
+		PieceSize:  pieceSize,
+		CarSize:    counter.n,
+		CarPath:    carPath,
+	}, nil
+}
+
+// FileCID returns the root CID of the DAG of the file at path, without writing it
+func FileCID(path string, opts Options) (cid.Cid, error) {
+	opts, err := opts.withDefaults(DefaultMaxLinks)
+	if err != nil {
+		return cid.Undef, err
+	}
+	node, err := newBuilder(opts).addFile(path)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return node.Cid(), nil
+}
+
+type countingWriter struct {
+	n uint64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += uint64(len(p))
+	return len(p), nil
+}
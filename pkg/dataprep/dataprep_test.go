@@ -0,0 +1,288 @@
+package dataprep
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/go-cid"
+	carv1 "github.com/ipld/go-car"
+	carv2 "github.com/ipld/go-car/v2"
+	"github.com/ipld/go-car/v2/index"
+	"github.com/multiformats/go-varint"
+)
+
+func writeTestFile(t *testing.T, size int) string {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "input.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrepareSingleFile(t *testing.T) {
+	path := writeTestFile(t, 3*1024+5)
+	opts := Options{ChunkSize: 1024}
+	fileCID, err := FileCID(path, Options{ChunkSize: 1024, MaxLinks: DealMaxLinks})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapped, err := Prepare(context.Background(), path, t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(wrapped.CarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cr, err := carv1.NewCarReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cr.Header.Roots) != 1 || cr.Header.Roots[0] != wrapped.PayloadCID {
+		t.Fatalf("CAR roots = %v, want %s", cr.Header.Roots, wrapped.PayloadCID)
+	}
+	// The root is the first block, a directory holding the file under the name "."
+	block, err := cr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := merkledag.DecodeProtobuf(block.RawData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := root.Links()
+	if block.Cid() != wrapped.PayloadCID || len(links) != 1 || links[0].Name != wrapName || links[0].Cid != fileCID {
+		t.Fatalf("root %s links to %v, want only %q -> %s", block.Cid(), links, wrapName, fileCID)
+	}
+
+	opts.NoWrap = true
+	bare, err := Prepare(context.Background(), path, t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bare.PayloadCID != fileCID {
+		t.Fatalf("payload CID without wrapping = %s, want the file CID %s", bare.PayloadCID, fileCID)
+	}
+	if bare.PieceCID == wrapped.PieceCID {
+		t.Fatal("wrapped and bare files have the same piece CID")
+	}
+}
+
+// writeFixture creates a folder with nested and empty directories, a symbolic link
+// and a file spanning several chunks, with fixed contents
+func writeFixture(t testing.TB, root string) {
+	t.Helper()
+	large := make([]byte, 2*DefaultChunkSize+1000)
+	for i := range large {
+		large[i] = byte(i * 7 % 251)
+	}
+	files := map[string][]byte{
+		"a.txt":            []byte("a\n"),
+		"sub/b.txt":        []byte("b\n"),
+		"sub/large.bin":    large,
+		"sub/deeper/c.txt": []byte("c\n"),
+		"z.txt":            []byte("last\n"),
+	}
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{"empty", "sub/empty/nested"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(dir)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join("sub", "b.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrepareMatchesFildeal(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root)
+
+	// The expected values are those of fildeal PrepareData for the same input
+	tests := []struct {
+		name       string
+		input      string
+		carVersion int
+		payloadCID string
+		pieceCID   string
+		pieceSize  uint64
+		carSize    uint64
+	}{
+		{
+			name:       "folder",
+			input:      root,
+			carVersion: 1,
+			payloadCID: "bafybeicugjouvcmd6z25qithimswdhu6hgzakiygjuezdu2bxbfjp4goha",
+			pieceCID:   "baga6ea4seaqawvphrkrcvzk2wtew5kzw6d6htwcormmkq76ppd6kot5avgv5sby",
+			pieceSize:  4194304,
+			carSize:    2099210,
+		},
+		{
+			name:       "single file",
+			input:      filepath.Join(root, "sub", "large.bin"),
+			carVersion: 1,
+			payloadCID: "bafybeigriwx5kxzjbhvb4yo6yfttuw2s6cqn4pxyfjspyvfzoxe67z6him",
+			pieceCID:   "baga6ea4seaqlgf5zt4tsbwe24v23xcgqr55kamayhd4lkt2oejcokjkkaung2ka",
+			pieceSize:  4194304,
+			carSize:    2098611,
+		},
+		// fildeal only wrote CARv1; a CARv2 holds the same data and its index
+		{
+			name:       "folder as CARv2",
+			input:      root,
+			carVersion: 2,
+			payloadCID: "bafybeicugjouvcmd6z25qithimswdhu6hgzakiygjuezdu2bxbfjp4goha",
+			pieceCID:   "baga6ea4seaqcrzewvrvxhejc76x6czoj2trgrdhyg6vwietbiocddvqedofqwmi",
+			pieceSize:  4194304,
+			carSize:    2099731,
+		},
+		{
+			name:       "single file as CARv2",
+			input:      filepath.Join(root, "sub", "large.bin"),
+			carVersion: 2,
+			payloadCID: "bafybeigriwx5kxzjbhvb4yo6yfttuw2s6cqn4pxyfjspyvfzoxe67z6him",
+			pieceCID:   "baga6ea4seaqdetm64wbt4cnsyn222tvwxo7hpjebzdwunqdlvwkvxklowavlshq",
+			pieceSize:  4194304,
+			carSize:    2098892,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Prepare(context.Background(), tt.input, t.TempDir(), Options{CarVersion: tt.carVersion})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.PayloadCID.String() != tt.payloadCID || res.PieceCID.String() != tt.pieceCID ||
+				res.PieceSize != tt.pieceSize || res.CarSize != tt.carSize {
+				t.Fatalf("got payload %s, piece %s, piece size %d, CAR size %d; want %s, %s, %d, %d",
+					res.PayloadCID, res.PieceCID, res.PieceSize, res.CarSize,
+					tt.payloadCID, tt.pieceCID, tt.pieceSize, tt.carSize)
+			}
+			info, err := os.Stat(res.CarPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uint64(info.Size()) != tt.carSize || filepath.Base(res.CarPath) != tt.pieceCID+".car" {
+				t.Fatalf("CAR file %s is %d bytes", res.CarPath, info.Size())
+			}
+		})
+	}
+}
+
+func TestPrepareCarV2(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root)
+
+	v1, err := Prepare(context.Background(), root, t.TempDir(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := Prepare(context.Background(), root, t.TempDir(), Options{CarVersion: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1Data, err := os.ReadFile(v1.CarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := carv2.OpenReader(v2.CarPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	roots, err := r.Roots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || roots[0] != v2.PayloadCID || v2.PayloadCID != v1.PayloadCID {
+		t.Fatalf("CARv2 roots = %v, want %s", roots, v1.PayloadCID)
+	}
+
+	// The CARv2 data is the CARv1, and its index finds every block in it
+	dr, err := r.DataReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, v1Data) {
+		t.Fatal("CARv2 data differs from the CARv1")
+	}
+	if !r.Header.HasIndex() {
+		t.Fatal("CARv2 has no index")
+	}
+
+	ir, err := r.IndexReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := index.ReadFrom(ir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := carv2.NewBlockReader(bytes.NewReader(v1Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		block, err := blocks.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		err = idx.GetAll(block.Cid(), func(offset uint64) bool {
+			// The offset is that of the CAR section holding the block
+			_, n, err := varint.FromUvarint(data[offset:])
+			if err == nil {
+				_, c, err := cid.CidFromBytes(data[int(offset)+n:])
+				found = err == nil && c == block.Cid()
+			}
+			return !found
+		})
+		if err != nil || !found {
+			t.Fatalf("block %s is not in the index: %v", block.Cid(), err)
+		}
+	}
+}
+
+func TestPrepareSymlinkLoop(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(".", filepath.Join(root, "loop")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Prepare(context.Background(), root, t.TempDir(), Options{})
+	if err == nil || !strings.Contains(err.Error(), "loop") {
+		t.Fatalf("err = %v, want a symbolic link loop", err)
+	}
+}
@@ -0,0 +1,220 @@
+// Package dealdb provides a local, file-based record of submitted deals
+package dealdb
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var dealsBucket = []byte("deals")
+
+// ErrNotFound is returned when no deal exists with the requested ID
+var ErrNotFound = errors.New("deal not found")
+
+// State is the lifecycle state of a recorded deal
+type State string
+
+const (
+	// StateProposed means the proposal was submitted but no deal is published yet
+	StateProposed State = "proposed"
+	// StatePublished means a storage provider published the deal on-chain
+	StatePublished State = "published"
+	// StateActive means the deal's sector was proven and the deal is active
+	StateActive State = "active"
+	// StateExpired means the deal reached its end epoch
+	StateExpired State = "expired"
+	// StateFailed means the proposal or deal can no longer become active
+	StateFailed State = "failed"
+)
+
+// Terminal reports whether no further transitions are expected from the state
+func (s State) Terminal() bool {
+	return s == StateExpired || s == StateFailed
+}
+
+// Deal is the local record of a deal submission
+type Deal struct {
+	ID         uint64    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	InputPath  string    `json:"input_path"`
+	PayloadCID string    `json:"payload_cid"`
+	PieceCID   string    `json:"piece_cid"`
+	PieceSize  uint64    `json:"piece_size"`
+	CarSize    uint64    `json:"car_size"`
+	BufferURL  string    `json:"buffer_url"`
+	// KeyRef identifies the encryption key of an encrypted input without revealing it
+	KeyRef string `json:"key_ref,omitempty"`
+	// Manifest is the path of the manifest of an encrypted folder, which maps its
+	// files to their encrypted paths and key references
+	Manifest             string `json:"manifest,omitempty"`
+	VerifiedDeal         bool   `json:"verified_deal"`
+	StartEpoch           int64  `json:"start_epoch"`
+	EndEpoch             int64  `json:"end_epoch"`
+	StoragePricePerEpoch string `json:"storage_price_per_epoch"`
+	ProviderCollateral   string `json:"provider_collateral"`
+	ClientCollateral     string `json:"client_collateral"`
+	TxHash               string `json:"tx_hash"`
+	ProposalID           string `json:"proposal_id,omitempty"`
+	// Status is the latest known on-chain status of the piece
+	Status string `json:"status,omitempty"`
+	// State is the lifecycle state derived from the on-chain status and epochs
+	State        State     `json:"state"`
+	StateNote    string    `json:"state_note,omitempty"`
+	DealID       uint64    `json:"deal_id,omitempty"`
+	Provider     string    `json:"provider,omitempty"`
+	LastSyncedAt time.Time `json:"last_synced_at,omitempty"`
+	// ErasedAt is when the deal's keys were destroyed with shred. The deal stays on
+	// chain, but its data can no longer be decrypted
+	ErasedAt time.Time `json:"erased_at,omitempty"`
+	// ErasureRecord is the hash of the shred log record of the erasure
+	ErasureRecord string `json:"erasure_record,omitempty"`
+}
+
+// Erased reports whether the deal's data was cryptographically erased
+func (d *Deal) Erased() bool {
+	return !d.ErasedAt.IsZero()
+}
+
+// DB is a deal database backed by a single bbolt file
+type DB struct {
+	db *bolt.DB
+}
+
+// Open opens the deal database at path, creating it if needed
+func Open(path string) (*DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open deal database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(dealsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialise deal database: %w", err)
+	}
+
+	return &DB{db: db}, nil
+}
+
+// Close closes the database file
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Add stores a new deal, assigning its ID and timestamps
+func (d *DB) Add(deal *Deal) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dealsBucket)
+
+		id, err := b.NextSequence()
+		if err != nil {
+			return fmt.Errorf("failed to allocate deal ID: %w", err)
+		}
+
+		now := time.Now().UTC()
+		deal.ID = id
+		if deal.State == "" {
+			deal.State = StateProposed
+		}
+		deal.CreatedAt = now
+		deal.UpdatedAt = now
+		return putDeal(b, deal)
+	})
+}
+
+// Update loads a deal, applies fn to it and stores the result
+func (d *DB) Update(id uint64, fn func(*Deal) error) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(dealsBucket)
+
+		deal, err := getDeal(b, id)
+		if err != nil {
+			return err
+		}
+		if err := fn(deal); err != nil {
+			return err
+		}
+
+		deal.ID = id
+		deal.UpdatedAt = time.Now().UTC()
+		return putDeal(b, deal)
+	})
+}
+
+// Get returns the deal with the given ID
+func (d *DB) Get(id uint64) (*Deal, error) {
+	var deal *Deal
+	err := d.db.View(func(tx *bolt.Tx) error {
+		var err error
+		deal, err = getDeal(tx.Bucket(dealsBucket), id)
+		return err
+	})
+	return deal, err
+}
+
+// List returns all deals ordered by ID
+func (d *DB) List() ([]*Deal, error) {
+	var deals []*Deal
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(dealsBucket).ForEach(func(_, v []byte) error {
+			deal, err := decodeDeal(v)
+			if err != nil {
+				return err
+			}
+			deals = append(deals, deal)
+			return nil
+		})
+	})
+	return deals, err
+}
+
+func getDeal(b *bolt.Bucket, id uint64) (*Deal, error) {
+	v := b.Get(dealKey(id))
+	if v == nil {
+		return nil, fmt.Errorf("%w: %d", ErrNotFound, id)
+	}
+
+	return decodeDeal(v)
+}
+
+func decodeDeal(v []byte) (*Deal, error) {
+	deal := &Deal{}
+	if err := json.Unmarshal(v, deal); err != nil {
+		return nil, fmt.Errorf("failed to decode deal: %w", err)
+	}
+	// Records written before lifecycle tracking have no state yet
+	if deal.State == "" {
+		deal.State = StateProposed
+	}
+	return deal, nil
+}
+
+func putDeal(b *bolt.Bucket, deal *Deal) error {
+	v, err := json.Marshal(deal)
+	if err != nil {
+		return fmt.Errorf("failed to encode deal %d: %w", deal.ID, err)
+	}
+	return b.Put(dealKey(deal.ID), v)
+}
+
+// dealKey encodes a deal ID so that keys sort in ID order
+func dealKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
@@ -0,0 +1,180 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Encrypted data is split into segments that are sealed independently with an AEAD.
+// Each segment uses the nonce
+//
+//	nonce prefix (7 bytes) || segment counter (4 bytes, big endian) || final flag (1 byte)
+//
+// so that reordered, duplicated or dropped segments fail authentication, and the
+// final flag makes truncation at a segment boundary detectable.
+
+// noncePrefixSize is the size of the random per-file nonce prefix
+const noncePrefixSize = 7
+
+// ErrAuthentication is returned when a segment fails authentication, which means
+// the key is wrong or the data was truncated, reordered or tampered with
+var ErrAuthentication = errors.New("authentication failed: wrong key or corrupted data")
+
+// segmentNonce builds the nonce of a segment
+func segmentNonce(prefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, noncePrefixSize+5)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// segmentWriter seals plaintext into fixed-size segments
+type segmentWriter struct {
+	w           io.Writer
+	aead        cipher.AEAD
+	noncePrefix []byte
+	aad         []byte
+	counter     uint32
+	buf         []byte
+	sealed      []byte
+	closed      bool
+}
+
+func newSegmentWriter(w io.Writer, aead cipher.AEAD, noncePrefix, aad []byte, segmentSize int) *segmentWriter {
+	return &segmentWriter{
+		w:           w,
+		aead:        aead,
+		noncePrefix: noncePrefix,
+		aad:         aad,
+		buf:         make([]byte, 0, segmentSize),
+		sealed:      make([]byte, 0, segmentSize+aead.Overhead()),
+	}
+}
+
+func (s *segmentWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errors.New("write to closed encryption writer")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		// A full segment is only sealed once more data arrives, because the last
+		// segment of the stream has to carry the final flag
+		if len(s.buf) == cap(s.buf) {
+			if err := s.seal(false); err != nil {
+				return n, err
+			}
+		}
+
+		k := copy(s.buf[len(s.buf):cap(s.buf)], p)
+		s.buf = s.buf[:len(s.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+// Close seals the final segment. It does not close the underlying writer
+func (s *segmentWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.seal(true)
+}
+
+func (s *segmentWriter) seal(final bool) error {
+	if s.counter == math.MaxUint32 {
+		return errors.New("too many segments")
+	}
+
+	nonce := segmentNonce(s.noncePrefix, s.counter, final)
+	s.sealed = s.aead.Seal(s.sealed[:0], nonce, s.buf, s.aad)
+	if _, err := s.w.Write(s.sealed); err != nil {
+		return fmt.Errorf("failed to write encrypted segment: %w", err)
+	}
+
+	s.counter++
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// segmentReader opens segments written by segmentWriter
+type segmentReader struct {
+	r           *bufio.Reader
+	aead        cipher.AEAD
+	noncePrefix []byte
+	aad         []byte
+	counter     uint32
+	sealed      []byte
+	plain       []byte
+	pos         int
+	done        bool
+}
+
+func newSegmentReader(r io.Reader, aead cipher.AEAD, noncePrefix, aad []byte, segmentSize int) *segmentReader {
+	return &segmentReader{
+		r:           bufio.NewReader(r),
+		aead:        aead,
+		noncePrefix: noncePrefix,
+		aad:         aad,
+		sealed:      make([]byte, segmentSize+aead.Overhead()),
+	}
+}
+
+func (s *segmentReader) Read(p []byte) (int, error) {
+	for s.pos == len(s.plain) {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, s.plain[s.pos:])
+	s.pos += n
+	return n, nil
+}
+
+func (s *segmentReader) open() error {
+	n, err := io.ReadFull(s.r, s.sealed)
+	final := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		final = true
+	case err != nil:
+		return fmt.Errorf("failed to read encrypted segment: %w", err)
+	default:
+		// A full segment is the final one if nothing follows it
+		if _, err := s.r.Peek(1); err == io.EOF {
+			final = true
+		} else if err != nil {
+			return fmt.Errorf("failed to read encrypted segment: %w", err)
+		}
+	}
+
+	if n < s.aead.Overhead() {
+		return fmt.Errorf("%w: truncated segment %d", ErrAuthentication, s.counter)
+	}
+
+	nonce := segmentNonce(s.noncePrefix, s.counter, final)
+	plain, err := s.aead.Open(s.plain[:0], nonce, s.sealed[:n], s.aad)
+	if err != nil {
+		return fmt.Errorf("%w: segment %d", ErrAuthentication, s.counter)
+	}
+
+	s.plain = plain
+	s.pos = 0
+	s.counter++
+	s.done = final
+	return nil
+}
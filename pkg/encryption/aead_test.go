@@ -0,0 +1,224 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+const testSegmentSize = 64
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// sealSegments encrypts plain into segments of testSegmentSize bytes
+func sealSegments(t *testing.T, key, noncePrefix, aad, plain []byte) []byte {
+	t.Helper()
+	aead, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sealed bytes.Buffer
+	w := newSegmentWriter(&sealed, aead, noncePrefix, aad, testSegmentSize)
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+func openSegments(key, noncePrefix, aad, sealed []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(newSegmentReader(bytes.NewReader(sealed), aead, noncePrefix, aad, testSegmentSize))
+}
+
+func TestSegmentRoundTrip(t *testing.T) {
+	key := testKey(t)
+	noncePrefix := randomBytes(t, noncePrefixSize)
+	aad := []byte("header")
+
+	tests := []struct {
+		name     string
+		size     int
+		segments int
+	}{
+		{"empty", 0, 1},
+		{"one byte", 1, 1},
+		{"segment minus one", testSegmentSize - 1, 1},
+		{"one segment", testSegmentSize, 1},
+		{"segment plus one", testSegmentSize + 1, 2},
+		{"three segments", 3 * testSegmentSize, 3},
+		{"partial last segment", 3*testSegmentSize + 5, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := randomBytes(t, tt.size)
+			sealed := sealSegments(t, key, noncePrefix, aad, plain)
+
+			overhead := 16 * tt.segments
+			if len(sealed) != tt.size+overhead {
+				t.Fatalf("sealed size = %d, want %d", len(sealed), tt.size+overhead)
+			}
+
+			got, err := openSegments(key, noncePrefix, aad, sealed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatal("decrypted data differs from the plaintext")
+			}
+		})
+	}
+}
+
+func TestSegmentWriteSizes(t *testing.T) {
+	key := testKey(t)
+	noncePrefix := randomBytes(t, noncePrefixSize)
+	plain := randomBytes(t, 5*testSegmentSize+7)
+	want := sealSegments(t, key, noncePrefix, nil, plain)
+
+	// The output must not depend on how the plaintext is split into writes
+	for _, step := range []int{1, 7, testSegmentSize, testSegmentSize + 1} {
+		aead, _ := newGCM(key)
+		var sealed bytes.Buffer
+		w := newSegmentWriter(&sealed, aead, noncePrefix, nil, testSegmentSize)
+		for p := plain; len(p) > 0; {
+			n := min(step, len(p))
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(sealed.Bytes(), want) {
+			t.Fatalf("writes of %d bytes give different output", step)
+		}
+	}
+}
+
+func TestSegmentTampering(t *testing.T) {
+	key := testKey(t)
+	noncePrefix := randomBytes(t, noncePrefixSize)
+	aad := []byte("header")
+	plain := randomBytes(t, 3*testSegmentSize+5)
+	sealed := sealSegments(t, key, noncePrefix, aad, plain)
+	sealedSize := testSegmentSize + 16
+
+	tests := []struct {
+		name        string
+		key         []byte
+		noncePrefix []byte
+		aad         []byte
+		modify      func([]byte) []byte
+	}{
+		{
+			name: "truncated at a segment boundary",
+			modify: func(b []byte) []byte {
+				return b[:2*sealedSize]
+			},
+		},
+		{
+			name: "truncated inside a segment",
+			modify: func(b []byte) []byte {
+				return b[:2*sealedSize+10]
+			},
+		},
+		{
+			name: "truncated below the tag size",
+			modify: func(b []byte) []byte {
+				return b[:3*sealedSize+3]
+			},
+		},
+		{
+			name: "extended after the final segment",
+			modify: func(b []byte) []byte {
+				return append(b, b[:sealedSize]...)
+			},
+		},
+		{
+			name: "flipped byte",
+			modify: func(b []byte) []byte {
+				b[sealedSize+3] ^= 1
+				return b
+			},
+		},
+		{
+			name: "swapped segments",
+			modify: func(b []byte) []byte {
+				first := append([]byte{}, b[:sealedSize]...)
+				copy(b, b[sealedSize:2*sealedSize])
+				copy(b[sealedSize:], first)
+				return b
+			},
+		},
+		{
+			name: "duplicated segment",
+			modify: func(b []byte) []byte {
+				copy(b[sealedSize:], b[:sealedSize])
+				return b
+			},
+		},
+		{name: "wrong key", key: testKey(t)},
+		{name: "wrong nonce prefix", noncePrefix: randomBytes(t, noncePrefixSize)},
+		{name: "wrong associated data", aad: []byte("other header")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append([]byte{}, sealed...)
+			if tt.modify != nil {
+				data = tt.modify(data)
+			}
+			k, np, a := key, noncePrefix, aad
+			if tt.key != nil {
+				k = tt.key
+			}
+			if tt.noncePrefix != nil {
+				np = tt.noncePrefix
+			}
+			if tt.aad != nil {
+				a = tt.aad
+			}
+
+			_, err := openSegments(k, np, a, data)
+			if !errors.Is(err, ErrAuthentication) {
+				t.Fatalf("err = %v, want ErrAuthentication", err)
+			}
+		})
+	}
+}
+
+func TestSegmentNonce(t *testing.T) {
+	prefix := []byte{1, 2, 3, 4, 5, 6, 7}
+	got := segmentNonce(prefix, 0x01020304, true)
+	want := []byte{1, 2, 3, 4, 5, 6, 7, 1, 2, 3, 4, 1}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("nonce = %x, want %x", got, want)
+	}
+	if got := segmentNonce(prefix, 0x01020304, false); got[len(got)-1] != 0 {
+		t.Fatalf("non-final nonce ends in %d", got[len(got)-1])
+	}
+}
@@ -0,0 +1,108 @@
+package encryption
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression identifies how the plaintext is compressed before it is encrypted.
+// It is recorded in the header, so decryption decompresses transparently
+type Compression uint8
+
+const (
+	// CompressionNone stores the plaintext as is
+	CompressionNone Compression = 0
+	// CompressionGzip compresses the plaintext with gzip
+	CompressionGzip Compression = 1
+	// CompressionZstd compresses the plaintext with zstd
+	CompressionZstd Compression = 2
+)
+
+// ErrCompressedRange is returned for ranged reads of a compressed file, whose
+// plaintext offsets do not map to segments
+var ErrCompressedRange = errors.New("byte ranges cannot be read from a compressed file; decrypt it whole")
+
+// String returns the name of the compression
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(c))
+	}
+}
+
+// ParseCompression returns the compression with the given name
+func ParseCompression(name string) (Compression, error) {
+	for _, c := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported compression %q", name)
+}
+
+// compressWriter compresses into an encrypting writer, and closes both
+type compressWriter struct {
+	io.WriteCloser
+	w io.WriteCloser
+}
+
+func (c *compressWriter) Close() error {
+	if err := c.WriteCloser.Close(); err != nil {
+		return fmt.Errorf("failed to compress data: %w", err)
+	}
+	return c.w.Close()
+}
+
+// newWriter returns a writer that compresses into w
+func (c Compression) newWriter(w io.WriteCloser) (io.WriteCloser, error) {
+	var zw io.WriteCloser
+	switch c {
+	case CompressionNone:
+		return w, nil
+	case CompressionGzip:
+		zw = gzip.NewWriter(w)
+	case CompressionZstd:
+		enc, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		zw = enc
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", c)
+	}
+	return &compressWriter{WriteCloser: zw, w: w}, nil
+}
+
+// newReader returns a reader that decompresses r
+func (c Compression) newReader(r io.Reader) (io.Reader, error) {
+	switch c {
+	case CompressionNone:
+		return r, nil
+	case CompressionGzip:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip data: %w", err)
+		}
+		return zr, nil
+	case CompressionZstd:
+		// A single-threaded decoder decodes synchronously, so it holds no goroutines
+		// that would need closing
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read zstd data: %w", err)
+		}
+		return zr, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", c)
+	}
+}
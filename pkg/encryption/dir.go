@@ -0,0 +1,303 @@
+package encryption
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// dirManifestVersion is the version of the directory manifest format
+const dirManifestVersion = 1
+
+// DirManifest maps the files of an encrypted directory to their encrypted paths and
+// keys. It is kept outside the encrypted tree, because with obfuscated names it is
+// the only record of the original names
+type DirManifest struct {
+	Version        int  `json:"version"`
+	ObfuscateNames bool `json:"obfuscate_names"`
+	// Dataset is the ID of the folder in the wallet's key hierarchy; it is empty when
+	// the files were encrypted to recipients or with a passphrase
+	Dataset string `json:"dataset,omitempty"`
+	// Dirs lists the directories, so that empty ones are restored too
+	Dirs  []DirFileEntry `json:"dirs"`
+	Files []DirFileEntry `json:"files"`
+}
+
+// DirFileEntry describes one encrypted file or directory. Paths are relative and
+// slash-separated
+type DirFileEntry struct {
+	Path          string `json:"path"`
+	EncryptedPath string `json:"encrypted_path"`
+	Size          int64  `json:"size"`
+	// KeyRef identifies the file's key without revealing it
+	KeyRef string `json:"key_ref"`
+}
+
+// DirOptions controls how a directory is encrypted
+type DirOptions struct {
+	// ObfuscateNames replaces every file and directory name with a random name
+	ObfuscateNames bool
+	// Recipients, if set, encrypts every file to these public keys instead of using a
+	// key derived from the wallet
+	Recipients []*ecdsa.PublicKey
+	// Passphrase, if set, derives the keys of the files from a passphrase instead,
+	// with scrypt run once for the whole folder using ScryptParams
+	Passphrase   []byte
+	ScryptParams ScryptParams
+	// Compression is applied to every file before it is encrypted
+	Compression Compression
+	// OnFile, if set, is called with the key of each file once it is encrypted
+	OnFile func(inputPath, encryptedPath string, key []byte) error
+}
+
+// EncryptDir encrypts every file below inputDir into the same relative location
+// below outputDir, and returns the manifest of the encrypted files. Each file gets
+// its own key, wrapped to opts.Recipients, derived from opts.Passphrase or derived
+// from a new dataset in the wallet's key hierarchy, which takes a single signature
+// for the whole folder
+func EncryptDir(inputDir, outputDir, privateKey string, opts DirOptions) (*DirManifest, error) {
+	manifest := &DirManifest{
+		Version:        dirManifestVersion,
+		ObfuscateNames: opts.ObfuscateNames,
+		Dirs:           []DirFileEntry{},
+		Files:          []DirFileEntry{},
+	}
+	if len(opts.Recipients) > 0 && len(opts.Passphrase) > 0 {
+		return nil, fmt.Errorf("a folder cannot be encrypted both to recipients and with a passphrase")
+	}
+
+	var dataset *ScopedKey
+	var passphraseParams, passphraseKey []byte
+	switch {
+	case len(opts.Passphrase) > 0:
+		header, err := NewPassphraseHeader(opts.ScryptParams)
+		if err != nil {
+			return nil, err
+		}
+		if passphraseKey, err = passphraseMasterKey(header, opts.Passphrase); err != nil {
+			return nil, err
+		}
+		passphraseParams = header.KDFParams
+	case len(opts.Recipients) == 0:
+		root, err := RootKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		id, err := randomName()
+		if err != nil {
+			return nil, err
+		}
+		if dataset, err = DatasetKey(root, id); err != nil {
+			return nil, err
+		}
+		manifest.Dataset = id
+	}
+
+	// Obfuscated names of the directories created so far, keyed by original path
+	dirNames := map[string]string{".": "."}
+
+	err := filepath.WalkDir(inputDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(inputDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return os.MkdirAll(outputDir, 0755)
+		}
+
+		encRel := path.Join(dirNames[path.Dir(rel)], path.Base(rel))
+		if opts.ObfuscateNames {
+			name, err := randomName()
+			if err != nil {
+				return err
+			}
+			encRel = path.Join(dirNames[path.Dir(rel)], name)
+		}
+		outPath := filepath.Join(outputDir, filepath.FromSlash(encRel))
+
+		switch {
+		case d.IsDir():
+			dirNames[rel] = encRel
+			manifest.Dirs = append(manifest.Dirs, DirFileEntry{Path: rel, EncryptedPath: encRel})
+			return os.MkdirAll(outPath, 0755)
+		case !d.Type().IsRegular():
+			return fmt.Errorf("cannot encrypt %s: not a regular file", p)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var key []byte
+		switch {
+		case passphraseKey != nil:
+			header := NewHeader()
+			header.KDF = KDFPassphrase
+			header.KDFParams = passphraseParams
+			header.Context = []byte(encRel)
+			header.Compression = opts.Compression
+			if key, err = passphraseFileKey(passphraseKey, header); err == nil {
+				err = encryptFile(p, outPath, key, header)
+			}
+		case dataset == nil:
+			key, err = EncryptFileTo(p, outPath, opts.Recipients, opts.Compression)
+		default:
+			if key, err = dataset.FileKey(encRel); err == nil {
+				header := NewHierarchyHeader(dataset.Dataset, encRel)
+				header.Compression = opts.Compression
+				err = encryptFile(p, outPath, key, header)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", rel, err)
+		}
+		if opts.OnFile != nil {
+			if err := opts.OnFile(p, outPath, key); err != nil {
+				return err
+			}
+		}
+
+		manifest.Files = append(manifest.Files, DirFileEntry{
+			Path:          rel,
+			EncryptedPath: encRel,
+			Size:          info.Size(),
+			KeyRef:        KeyID(key),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// DecryptDir decrypts the files of an encrypted directory listed in the manifest into
+// their original locations below outputDir, and returns how many files it decrypted.
+// When unlocking with a shared directory key, files outside that directory are skipped
+func DecryptDir(inputDir, outputDir string, manifest *DirManifest, unlocker *Unlocker) (int, error) {
+	for _, entry := range manifest.Dirs {
+		outPath := filepath.Join(outputDir, filepath.FromSlash(path.Clean("/"+entry.Path)))
+		if unlocker.Scoped != nil && entry.EncryptedPath != unlocker.Scoped.Path && !unlocker.Scoped.Contains(entry.EncryptedPath) {
+			continue
+		}
+		if err := os.MkdirAll(outPath, 0755); err != nil {
+			return 0, fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	count := 0
+	for _, entry := range manifest.Files {
+		// Manifests are shared along with keys, so an entry must not point outside
+		// the encrypted directory
+		if !filepath.IsLocal(filepath.FromSlash(entry.EncryptedPath)) {
+			return count, fmt.Errorf("invalid encrypted path %q of %s in the manifest: it leaves the encrypted directory", entry.EncryptedPath, entry.Path)
+		}
+		if unlocker.Scoped != nil && !unlocker.Scoped.Contains(entry.EncryptedPath) {
+			continue
+		}
+		inPath := filepath.Join(inputDir, filepath.FromSlash(entry.EncryptedPath))
+		outPath := filepath.Join(outputDir, filepath.FromSlash(path.Clean("/"+entry.Path)))
+
+		header, err := ReadFileHeader(inPath)
+		if err != nil {
+			return count, fmt.Errorf("failed to read header of %s: %w", entry.Path, err)
+		}
+		key, err := unlocker.Key(header)
+		if err != nil {
+			return count, fmt.Errorf("failed to derive key of %s: %w", entry.Path, err)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+			return count, fmt.Errorf("failed to create output directory: %w", err)
+		}
+		if err := DecryptFile(inPath, outPath, key); err != nil {
+			return count, fmt.Errorf("failed to decrypt %s: %w", entry.Path, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// ManifestForDir lists the files below an encrypted directory whose names were not
+// obfuscated, so it can be decrypted without the manifest written by EncryptDir
+func ManifestForDir(dir string) (*DirManifest, error) {
+	manifest := &DirManifest{Version: dirManifestVersion}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		entry := DirFileEntry{Path: rel, EncryptedPath: rel}
+		switch {
+		case d.IsDir():
+			manifest.Dirs = append(manifest.Dirs, entry)
+		case d.Type().IsRegular():
+			manifest.Files = append(manifest.Files, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// DirManifestPath returns the path of the manifest of an encrypted directory, which
+// sits next to the directory rather than inside it
+func DirManifestPath(dir string) string {
+	return filepath.Clean(dir) + ".manifest.json"
+}
+
+// ReadDirManifest reads a directory manifest from path
+func ReadDirManifest(path string) (*DirManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory manifest: %w", err)
+	}
+
+	manifest := &DirManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse directory manifest %s: %w", path, err)
+	}
+	if manifest.Version != dirManifestVersion {
+		return nil, fmt.Errorf("unsupported directory manifest version %d", manifest.Version)
+	}
+	return manifest, nil
+}
+
+// WriteDirManifest writes a directory manifest to path
+func WriteDirManifest(path string, manifest *DirManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode directory manifest: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write directory manifest: %w", err)
+	}
+	return nil
+}
+
+// randomName returns a random file name that reveals nothing about the original
+func randomName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate file name: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
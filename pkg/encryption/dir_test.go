@@ -0,0 +1,27 @@
+package encryption
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecryptDirRejectsEscapingPaths(t *testing.T) {
+	root := t.TempDir()
+	inputDir := filepath.Join(root, "encrypted")
+	if err := os.Mkdir(inputDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, encryptedPath := range []string{"../outside", "a/../../outside", "/etc/passwd", ""} {
+		manifest := &DirManifest{
+			Version: dirManifestVersion,
+			Files:   []DirFileEntry{{Path: "file", EncryptedPath: encryptedPath}},
+		}
+		_, err := DecryptDir(inputDir, filepath.Join(root, "out"), manifest, &Unlocker{})
+		if err == nil || !strings.Contains(err.Error(), "leaves the encrypted directory") {
+			t.Errorf("encrypted path %q: err = %v, want it rejected", encryptedPath, err)
+		}
+	}
+}
@@ -2,51 +2,85 @@
 package encryption
 
 import (
+	"bytes"
 	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
 )
 
-// EncryptData encrypts data using AES with a key derived from signature
-// Returns base64-encoded encrypted data and the hex encoded key for logging
+// ErrLegacyFormat is returned when the key of a file cannot be re-derived because it
+// uses the legacy format, which does not record how its key was derived
+var ErrLegacyFormat = errors.New("file uses the legacy format without a header; the key must be supplied")
+
+// ErrWalletRequired is returned when the key of a file can only be derived from a
+// wallet and no private key was given
+var ErrWalletRequired = errors.New("file key is derived from a wallet; the private key is required")
+
+// EncryptData encrypts data using AES-GCM with a key derived from signature
+// Returns the encrypted data and the hex encoded key for logging
 func EncryptData(data []byte, signature []byte) ([]byte, string, error) {
 	// Derive a deterministic 32-byte key from the signature using SHA-256
 	key := deriveKeyFromSignature(signature)
 	keyHex := hex.EncodeToString(key)
 
-	// Create a new AES cipher block
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to create AES cipher: %w", err)
-	}
-
-	// // Create deterministic IV by hashing the key
-	// iv := deriveIVFromKey(key)
+	header := NewHeader()
+	header.KDF = KDFWalletSignature
 
-	// Generate random IV
-	iv := make([]byte, aes.BlockSize)
-	if _, err := rand.Read(iv); err != nil {
-		return nil, "", fmt.Errorf("failed to generate IV: %w", err)
+	var encrypted bytes.Buffer
+	if err := EncryptStream(&encrypted, bytes.NewReader(data), key, header); err != nil {
+		return nil, "", err
 	}
+	return encrypted.Bytes(), keyHex, nil
+}
 
-	// Allocate buffer for IV + ciphertext
-	ciphertext := make([]byte, aes.BlockSize+len(data))
-
-	// Copy IV to the beginning
-	copy(ciphertext[:aes.BlockSize], iv)
+// DeriveKey recovers the data key of a file from its header using the wallet's
+// private key, by signing the recorded key-derivation context again, by deriving
+// it from the wallet's key hierarchy or, for an envelope, by unwrapping the key
+// addressed to the wallet
+func DeriveKey(header *Header, privateKey string) ([]byte, error) {
+	switch header.KDF {
+	case KDFPassphrase:
+		return nil, ErrPassphraseRequired
+	case KDFWalletSignature, KDFEnvelope, KDFHierarchy:
+		if privateKey == "" {
+			return nil, ErrWalletRequired
+		}
+	}
 
-	// Encrypt directly into the buffer after the IV
-	stream := cipher.NewCTR(block, iv)
-	stream.XORKeyStream(ciphertext[aes.BlockSize:], data)
+	switch header.KDF {
+	case KDFWalletSignature:
+	case KDFEnvelope:
+		if len(header.Recipients) == 0 {
+			return nil, ErrDetachedKeys
+		}
+		privateKeyECDSA, err := crypto.HexToECDSA(strings.TrimPrefix(privateKey, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		return header.unwrapKey(privateKeyECDSA)
+	case KDFHierarchy:
+		return (&Unlocker{PrivateKey: privateKey}).Key(header)
+	default:
+		return nil, fmt.Errorf("key of a file with KDF %s cannot be derived from a wallet", header.KDF)
+	}
+	if len(header.Context) == 0 {
+		return nil, errors.New("file does not record the message its key was derived from; the key must be supplied")
+	}
 
-	// Return base64 encoded for string compatibility
-	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(ciphertext)))
-	base64.StdEncoding.Encode(encoded, ciphertext)
-	return encoded, keyHex, nil
+	signing, err := parseKeySigning(header.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := signing.sign(privateKey, string(header.Context))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign message for decryption: %w", err)
+	}
+	return deriveKeyFromSignature(signature), nil
 }
 
 // deriveKeyFromSignature creates a deterministic 32-byte key from the signature
@@ -65,3 +99,12 @@ func deriveIVFromKey(key []byte) []byte {
 	hasher.Write(key)
 	return hasher.Sum(nil)[:aes.BlockSize] // First 16 bytes (AES block size)
 }
+
+// KeyID returns a short identifier for a key that can be stored alongside
+// records of the encrypted data without revealing the key itself
+func KeyID(key []byte) string {
+	hasher := sha256.New()
+	hasher.Write([]byte("eastore-key-id:"))
+	hasher.Write(key)
+	return hex.EncodeToString(hasher.Sum(nil)[:8])
+}
@@ -0,0 +1,133 @@
+package encryption
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+)
+
+// DataKeySize is the size of the random data key of an envelope
+const DataKeySize = 32
+
+// wrapInfo is the ECIES KDF shared information that binds wrapped keys to eastore
+var wrapInfo = []byte("eastore-envelope-key")
+
+// ErrNotRecipient is returned when a wallet is not among the recipients of a file
+var ErrNotRecipient = errors.New("wallet is not a recipient of this file")
+
+// compressedPubkeySize is the size of a compressed secp256k1 public key
+const compressedPubkeySize = 33
+
+// Recipient is the data key of an envelope wrapped to one secp256k1 public key
+type Recipient struct {
+	// PublicKey is the recipient's secp256k1 public key
+	PublicKey *ecdsa.PublicKey
+	// WrappedKey is the ECIES ciphertext of the data key
+	WrappedKey []byte
+}
+
+// Address returns the Ethereum address of the recipient
+func (r Recipient) Address() common.Address {
+	return crypto.PubkeyToAddress(*r.PublicKey)
+}
+
+// NewDataKey generates a random data key
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return key, nil
+}
+
+// NewEnvelopeHeader returns a header for data encrypted with dataKey, wrapped to
+// each of the recipients
+func NewEnvelopeHeader(dataKey []byte, recipients []*ecdsa.PublicKey) (*Header, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("envelope needs at least one recipient")
+	}
+
+	header := NewHeader()
+	header.KDF = KDFEnvelope
+	for _, pub := range recipients {
+		if err := header.AddRecipient(dataKey, pub); err != nil {
+			return nil, err
+		}
+	}
+	return header, nil
+}
+
+// AddRecipient wraps the data key to pub and adds it to the recipients, replacing
+// any existing entry for the same address
+func (h *Header) AddRecipient(dataKey []byte, pub *ecdsa.PublicKey) error {
+	wrapped, err := ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(pub), dataKey, wrapInfo, nil)
+	if err != nil {
+		return fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	h.RemoveRecipient(crypto.PubkeyToAddress(*pub))
+	h.Recipients = append(h.Recipients, Recipient{PublicKey: pub, WrappedKey: wrapped})
+	return nil
+}
+
+// RemoveRecipient removes the recipient with the given address and reports whether
+// it was present
+func (h *Header) RemoveRecipient(address common.Address) bool {
+	for i, r := range h.Recipients {
+		if r.Address() == address {
+			h.Recipients = append(h.Recipients[:i], h.Recipients[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// unwrapKey recovers the data key of an envelope with a recipient's private key
+func (h *Header) unwrapKey(privateKey *ecdsa.PrivateKey) ([]byte, error) {
+	address := crypto.PubkeyToAddress(privateKey.PublicKey)
+	for _, r := range h.Recipients {
+		if r.Address() != address {
+			continue
+		}
+
+		key, err := ecies.ImportECDSA(privateKey).Decrypt(r.WrappedKey, wrapInfo, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotRecipient, address.Hex())
+}
+
+// ParsePublicKey parses a hex-encoded secp256k1 public key, compressed or uncompressed
+func ParsePublicKey(s string) (*ecdsa.PublicKey, error) {
+	b, err := hexutil.Decode(ensureHexPrefix(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %q: %w", s, err)
+	}
+
+	var pub *ecdsa.PublicKey
+	if len(b) == 33 {
+		pub, err = crypto.DecompressPubkey(b)
+	} else {
+		pub, err = crypto.UnmarshalPubkey(b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %q: %w", s, err)
+	}
+	return pub, nil
+}
+
+func ensureHexPrefix(s string) string {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return s
+	}
+	return "0x" + s
+}
@@ -0,0 +1,415 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+)
+
+// testWallet is a recipient, with its private key in the hex form DeriveKey takes
+type testWallet struct {
+	key *ecdsa.PrivateKey
+	hex string
+}
+
+func newTestWallet(t *testing.T) testWallet {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testWallet{key: key, hex: hex.EncodeToString(crypto.FromECDSA(key))}
+}
+
+func (w testWallet) pub() *ecdsa.PublicKey {
+	return &w.key.PublicKey
+}
+
+func (w testWallet) address() common.Address {
+	return crypto.PubkeyToAddress(w.key.PublicKey)
+}
+
+func newTestEnvelope(t *testing.T, recipients ...testWallet) (*Header, []byte) {
+	t.Helper()
+	dataKey, err := NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubs := make([]*ecdsa.PublicKey, len(recipients))
+	for i, r := range recipients {
+		pubs[i] = r.pub()
+	}
+	header, err := NewEnvelopeHeader(dataKey, pubs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.NoncePrefix = randomBytes(t, noncePrefixSize)
+	return header, dataKey
+}
+
+// checkUnwraps checks that each wallet unwraps the data key of the header
+func checkUnwraps(t *testing.T, header *Header, dataKey []byte, wallets ...testWallet) {
+	t.Helper()
+	for _, w := range wallets {
+		key, err := DeriveKey(header, w.hex)
+		if err != nil {
+			t.Fatalf("recipient %s: %v", w.address().Hex(), err)
+		}
+		if !bytes.Equal(key, dataKey) {
+			t.Fatalf("recipient %s unwrapped a different key", w.address().Hex())
+		}
+	}
+}
+
+// checkRefused checks that none of the wallets can unwrap the data key of the header
+func checkRefused(t *testing.T, header *Header, wallets ...testWallet) {
+	t.Helper()
+	for _, w := range wallets {
+		if _, err := DeriveKey(header, w.hex); !errors.Is(err, ErrNotRecipient) {
+			t.Fatalf("wallet %s: err = %v, want %v", w.address().Hex(), err, ErrNotRecipient)
+		}
+	}
+}
+
+func TestEnvelopeWrapUnwrap(t *testing.T) {
+	alice, bob, eve := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	header, dataKey := newTestEnvelope(t, alice, bob)
+	if len(dataKey) != DataKeySize {
+		t.Fatalf("data key is %d bytes, want %d", len(dataKey), DataKeySize)
+	}
+	if len(header.Recipients) != 2 || header.Recipients[0].Address() != alice.address() || header.Recipients[1].Address() != bob.address() {
+		t.Fatal("header does not list the recipients in order")
+	}
+	if bytes.Contains(header.Recipients[0].WrappedKey, dataKey) {
+		t.Fatal("wrapped key holds the data key in the clear")
+	}
+	checkUnwraps(t, header, dataKey, alice, bob)
+	checkRefused(t, header, eve)
+
+	// The recipients survive the header encoding
+	raw, err := header.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadHeader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUnwraps(t, read, dataKey, alice, bob)
+	checkRefused(t, read, eve)
+
+	if _, err := NewEnvelopeHeader(dataKey, nil); err == nil {
+		t.Fatal("NewEnvelopeHeader accepted no recipients")
+	}
+	if _, err := DeriveKey(header, ""); !errors.Is(err, ErrWalletRequired) {
+		t.Fatalf("DeriveKey without a wallet: err = %v, want %v", err, ErrWalletRequired)
+	}
+}
+
+func TestEnvelopeTampering(t *testing.T) {
+	alice, bob, eve := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+
+	tests := []struct {
+		name   string
+		modify func(h *Header)
+	}{
+		{"flipped ciphertext byte", func(h *Header) {
+			w := h.Recipients[0].WrappedKey
+			w[len(w)/2] ^= 1
+		}},
+		{"flipped tag byte", func(h *Header) {
+			w := h.Recipients[0].WrappedKey
+			w[len(w)-1] ^= 1
+		}},
+		{"flipped ephemeral key byte", func(h *Header) {
+			h.Recipients[0].WrappedKey[1] ^= 1
+		}},
+		{"truncated", func(h *Header) {
+			h.Recipients[0].WrappedKey = h.Recipients[0].WrappedKey[:len(h.Recipients[0].WrappedKey)-1]
+		}},
+		{"empty", func(h *Header) {
+			h.Recipients[0].WrappedKey = nil
+		}},
+		{"another recipient's wrapped key", func(h *Header) {
+			h.Recipients[0].WrappedKey = h.Recipients[1].WrappedKey
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, _ := newTestEnvelope(t, alice, bob)
+			tt.modify(header)
+			if key, err := DeriveKey(header, alice.hex); err == nil {
+				t.Fatalf("unwrapped key %x from a tampered wrapped key", key)
+			}
+		})
+	}
+
+	// A key wrapped to eve's public key but listed under alice's address is
+	// not found for eve, and does not unwrap for alice
+	header, dataKey := newTestEnvelope(t, alice)
+	decoy, _ := newTestEnvelope(t, eve)
+	header.Recipients[0].WrappedKey = decoy.Recipients[0].WrappedKey
+	if _, err := DeriveKey(header, alice.hex); err == nil {
+		t.Fatal("alice unwrapped a key wrapped to eve")
+	}
+	checkRefused(t, header, eve)
+
+	// A key wrapped by ECIES without the eastore shared information is refused
+	header.Recipients[0].WrappedKey = eciesWrap(t, alice.pub(), dataKey, nil)
+	if _, err := DeriveKey(header, alice.hex); err == nil {
+		t.Fatal("unwrapped a key wrapped without the eastore shared information")
+	}
+	header.Recipients[0].WrappedKey = eciesWrap(t, alice.pub(), dataKey, wrapInfo)
+	checkUnwraps(t, header, dataKey, alice)
+}
+
+func TestEnvelopeRecipients(t *testing.T) {
+	alice, bob, carol := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	header, dataKey := newTestEnvelope(t, alice, bob)
+
+	// Adding an existing recipient replaces its entry
+	wrapped := header.Recipients[0].WrappedKey
+	if err := header.AddRecipient(dataKey, alice.pub()); err != nil {
+		t.Fatal(err)
+	}
+	if len(header.Recipients) != 2 {
+		t.Fatalf("header has %d recipients after adding alice again, want 2", len(header.Recipients))
+	}
+	for _, r := range header.Recipients {
+		if r.Address() == alice.address() && bytes.Equal(r.WrappedKey, wrapped) {
+			t.Fatal("alice's wrapped key was not replaced")
+		}
+	}
+	checkUnwraps(t, header, dataKey, alice, bob)
+
+	if header.RemoveRecipient(carol.address()) {
+		t.Fatal("RemoveRecipient removed a wallet that is not a recipient")
+	}
+	if !header.RemoveRecipient(bob.address()) {
+		t.Fatal("RemoveRecipient did not find bob")
+	}
+	checkUnwraps(t, header, dataKey, alice)
+	checkRefused(t, header, bob, carol)
+}
+
+func TestRekey(t *testing.T) {
+	alice, bob, carol := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	header, dataKey := newTestEnvelope(t, alice, bob)
+
+	if err := header.Rekey(dataKey, []*ecdsa.PublicKey{carol.pub()}, []common.Address{bob.address()}, false); err != nil {
+		t.Fatal(err)
+	}
+	if header.KeyGeneration != 1 {
+		t.Fatalf("key generation = %d, want 1", header.KeyGeneration)
+	}
+	checkUnwraps(t, header, dataKey, alice, carol)
+	checkRefused(t, header, bob)
+
+	// Rewrapping keeps the data key and the recipients, under fresh wrapped keys
+	before := map[common.Address][]byte{}
+	for _, r := range header.Recipients {
+		before[r.Address()] = r.WrappedKey
+	}
+	if err := header.Rekey(dataKey, nil, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if len(header.Recipients) != 2 || header.KeyGeneration != 2 {
+		t.Fatalf("after rewrap: %d recipients at generation %d, want 2 at generation 2", len(header.Recipients), header.KeyGeneration)
+	}
+	for _, r := range header.Recipients {
+		if bytes.Equal(before[r.Address()], r.WrappedKey) {
+			t.Fatalf("the wrapped key of %s was not renewed", r.Address().Hex())
+		}
+	}
+	checkUnwraps(t, header, dataKey, alice, carol)
+
+	for name, rekey := range map[string]func(h *Header) error{
+		"revoke a non-recipient": func(h *Header) error {
+			return h.Rekey(dataKey, nil, []common.Address{bob.address()}, false)
+		},
+		"revoke everyone": func(h *Header) error {
+			return h.Rekey(dataKey, nil, []common.Address{alice.address(), carol.address()}, false)
+		},
+		"rekey a passphrase file": func(h *Header) error {
+			return newTestPassphraseHeader(t).Rekey(dataKey, []*ecdsa.PublicKey{bob.pub()}, nil, false)
+		},
+	} {
+		h := *header
+		h.Recipients = append([]Recipient{}, header.Recipients...)
+		if err := rekey(&h); err == nil {
+			t.Errorf("%s: Rekey succeeded", name)
+		}
+	}
+}
+
+func TestEncryptFileTo(t *testing.T) {
+	alice, bob, eve := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "plain")
+	plain := randomBytes(t, 2*ChunkSize+5)
+	if err := os.WriteFile(inPath, plain, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	encPath := filepath.Join(dir, "encrypted")
+	dataKey, err := EncryptFileTo(inPath, encPath, []*ecdsa.PublicKey{alice.pub(), bob.pub()}, CompressionNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := ReadFileHeader(encPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUnwraps(t, header, dataKey, alice, bob)
+	checkRefused(t, header, eve)
+	checkDecrypts(t, encPath, dataKey, plain)
+
+	// Rewriting the key section leaves the data decryptable by the new recipients
+	if err := header.Rekey(dataKey, []*ecdsa.PublicKey{eve.pub()}, []common.Address{alice.address()}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := RewriteFileHeader(encPath, header); err != nil {
+		t.Fatal(err)
+	}
+	header, err = ReadFileHeader(encPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkUnwraps(t, header, dataKey, bob, eve)
+	checkRefused(t, header, alice)
+	checkDecrypts(t, encPath, dataKey, plain)
+}
+
+func TestEncryptFileDetached(t *testing.T) {
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "plain")
+	plain := randomBytes(t, ChunkSize+1)
+	if err := os.WriteFile(inPath, plain, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	encPath := filepath.Join(dir, "encrypted")
+	manifestPath := KeyManifestPath(encPath)
+	dataKey, err := EncryptFileDetached(inPath, encPath, manifestPath, []*ecdsa.PublicKey{alice.pub()}, CompressionNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := os.ReadFile(encPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The header holds no wrapped keys, so the manifest is needed to unwrap one
+	header, err := ReadFileHeader(encPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DeriveKey(header, alice.hex); !errors.Is(err, ErrDetachedKeys) {
+		t.Fatalf("DeriveKey without the manifest: err = %v, want %v", err, ErrDetachedKeys)
+	}
+	manifest, err := ReadKeyManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Apply(header); err != nil {
+		t.Fatal(err)
+	}
+	checkUnwraps(t, header, dataKey, alice)
+	checkRefused(t, header, bob)
+	checkDecrypts(t, encPath, dataKey, plain)
+
+	// Rekeying rewrites the manifest alone
+	if err := header.Rekey(dataKey, []*ecdsa.PublicKey{bob.pub()}, []common.Address{alice.address()}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKeyManifest(manifestPath, NewKeyManifest(header)); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := os.ReadFile(encPath); err != nil || !bytes.Equal(after, encrypted) {
+		t.Fatal("rekeying a file with detached keys changed the file")
+	}
+	header, err = ReadFileHeader(encPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest, err = ReadKeyManifest(manifestPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Apply(header); err != nil {
+		t.Fatal(err)
+	}
+	if header.KeyGeneration != 1 {
+		t.Fatalf("key generation = %d, want 1", header.KeyGeneration)
+	}
+	checkUnwraps(t, header, dataKey, bob)
+	checkRefused(t, header, alice)
+
+	// A manifest only applies to its own file
+	other, _ := newTestEnvelope(t, alice)
+	if err := manifest.Apply(other); err == nil || !strings.Contains(err.Error(), "different file") {
+		t.Fatalf("applying the manifest to another file: err = %v, want it refused", err)
+	}
+	manifest.Version++
+	if err := manifest.Apply(header); err == nil {
+		t.Fatal("applied a manifest of an unknown version")
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	w := newTestWallet(t)
+	compressed := hexutil.Encode(crypto.CompressPubkey(w.pub()))
+	uncompressed := hexutil.Encode(crypto.FromECDSAPub(w.pub()))
+
+	for _, s := range []string{compressed, uncompressed, strings.TrimPrefix(compressed, "0x"), "0X" + uncompressed[2:]} {
+		pub, err := ParsePublicKey(s)
+		if err != nil {
+			t.Fatalf("ParsePublicKey(%q): %v", s, err)
+		}
+		if crypto.PubkeyToAddress(*pub) != w.address() {
+			t.Fatalf("ParsePublicKey(%q) returned another key", s)
+		}
+	}
+
+	for _, s := range []string{"", "0x", "zz", compressed[:len(compressed)-2], "0x05" + compressed[4:], w.address().Hex()} {
+		if _, err := ParsePublicKey(s); err == nil {
+			t.Errorf("ParsePublicKey(%q) succeeded", s)
+		}
+	}
+}
+
+// eciesWrap wraps key to pub with ECIES and the given KDF shared information
+func eciesWrap(t *testing.T, pub *ecdsa.PublicKey, key, info []byte) []byte {
+	t.Helper()
+	wrapped, err := ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(pub), key, info, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wrapped
+}
+
+func checkDecrypts(t *testing.T, encPath string, key, plain []byte) {
+	t.Helper()
+	outPath := encPath + ".decrypted"
+	if err := DecryptFile(encPath, outPath, key); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("decrypted data differs from the input")
+	}
+}
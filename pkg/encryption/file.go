@@ -1,39 +1,173 @@
 package encryption
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/eastore-project/eastore/pkg/utils"
 )
 
-// EncryptFile encrypts a file using the private key to derive the encryption key
-// Returns encrypted data bytes, hex-encoded key string, and error if any
-func EncryptFile(inputPath string, privateKey string) ([]byte, string, error) {
+// EncryptFile encrypts the file at inputPath into outputPath using the private key to
+// derive the encryption key from a signature over the file's CID, signed as described
+// by signing (nil for the legacy personal_sign scheme), compressing it first unless
+// compression is CompressionNone. The file is streamed, so memory use does not depend
+// on its size
+// Returns the hex-encoded key string, and error if any
+func EncryptFile(inputPath, outputPath string, privateKey string, signing *KeySigning, compression Compression) (string, error) {
 	// Calculate file CID for encryption
 	fileCID, err := utils.CalculateFileCID(inputPath)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to calculate file CID: %w", err)
+		return "", fmt.Errorf("failed to calculate file CID: %w", err)
 	}
 	cidStr := fileCID.String()
 
 	// Sign the message to derive encryption key
-	signature, err := utils.SignMessage(privateKey, cidStr)
+	signature, err := signing.sign(privateKey, cidStr)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to sign message for encryption: %w", err)
+		return "", fmt.Errorf("failed to sign message for encryption: %w", err)
 	}
+	key := deriveKeyFromSignature(signature)
 
-	// Read the file
-	fileData, err := os.ReadFile(inputPath)
+	// The signed CID is recorded so the key can be re-derived from the wallet alone
+	header := NewHeader()
+	header.KDF = KDFWalletSignature
+	header.KDFParams = signing.params()
+	header.Context = []byte(cidStr)
+	header.Compression = compression
+
+	if err := encryptFile(inputPath, outputPath, key, header); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// EncryptFileTo encrypts the file at inputPath into outputPath with a random data key
+// that is wrapped to each recipient's public key, so any of them can decrypt it with
+// their own wallet. Returns the data key
+func EncryptFileTo(inputPath, outputPath string, recipients []*ecdsa.PublicKey, compression Compression) ([]byte, error) {
+	key, err := NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := NewEnvelopeHeader(key, recipients)
+	if err != nil {
+		return nil, err
+	}
+	header.Compression = compression
+
+	if err := encryptFile(inputPath, outputPath, key, header); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func encryptFile(inputPath, outputPath string, key []byte, header *Header) error {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open input file: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create encrypted file: %w", err)
+	}
+	defer out.Close()
+
+	if err := EncryptStream(out, in, key, header); err != nil {
+		return fmt.Errorf("failed to encrypt data: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write encrypted file: %w", err)
+	}
+	return nil
+}
+
+// DecryptFile decrypts the file at inputPath into outputPath with the given key,
+// streaming the data so that memory use does not depend on the file size
+func DecryptFile(inputPath, outputPath string, key []byte) error {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to open encrypted file: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(outputPath)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to read input file: %w", err)
+		return fmt.Errorf("failed to create decrypted file: %w", err)
 	}
+	defer out.Close()
 
-	// Encrypt the data
-	encryptedData, hexKey, err := EncryptData(fileData, signature)
+	if err := DecryptStream(out, in, key); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to write decrypted file: %w", err)
+	}
+	return nil
+}
+
+// DecryptFileRange decrypts length bytes of the plaintext of the file at inputPath,
+// starting at offset, into outputPath. Only the part of the file holding that range
+// is read. A length of 0 decrypts to the end. Returns the number of bytes written
+func DecryptFileRange(inputPath, outputPath string, key []byte, offset, length int64) (int64, error) {
+	in, err := os.Open(inputPath)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to encrypt data: %w", err)
+		return 0, fmt.Errorf("failed to open encrypted file: %w", err)
 	}
+	defer in.Close()
 
-	return encryptedData, hexKey, nil
+	info, err := in.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open encrypted file: %w", err)
+	}
+	r, err := NewDecryptReaderAt(in, info.Size(), key)
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 || offset > r.Size() {
+		return 0, fmt.Errorf("offset %d is outside the plaintext of %d bytes", offset, r.Size())
+	}
+	if length <= 0 || length > r.Size()-offset {
+		length = r.Size() - offset
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create decrypted file: %w", err)
+	}
+	defer out.Close()
+
+	n, err := io.CopyBuffer(out, io.NewSectionReader(r, offset, length), make([]byte, ChunkSize))
+	if err != nil {
+		return n, fmt.Errorf("failed to decrypt data: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return n, fmt.Errorf("failed to write decrypted file: %w", err)
+	}
+	return n, nil
+}
+
+// ReadFileHeader reads the header of the encrypted file at path. It returns
+// ErrLegacyFormat for files in the legacy format, which have no header
+func ReadFileHeader(path string) (*Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open encrypted file: %w", err)
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(formatMagic))
+	if _, err := io.ReadFull(f, magic); err != nil || !bytes.Equal(magic, formatMagic) {
+		return nil, ErrLegacyFormat
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read encrypted file: %w", err)
+	}
+	return ReadHeader(f)
 }
@@ -0,0 +1,354 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Encrypted files start with a self-describing binary header:
+//
+//	magic        7 bytes  "EASTORE"
+//	version      1 byte
+//	body length  4 bytes, big endian
+//	body:
+//	  cipher suite   1 byte
+//	  chunk size     4 bytes, big endian
+//	  nonce prefix   7 bytes
+//	  records        type (1 byte) || length (4 bytes, big endian) || value
+//
+// The header is authenticated as associated data of every segment, so it cannot
+// be altered without decryption failing. The key section, made of the recipient and
+// key generation records, is the exception: it is excluded from the associated data
+// so that recipients can be added, revoked or re-wrapped without re-encrypting the data. A tampered wrapped key only yields a wrong data
+// key, which still fails authentication.
+
+// CipherSuite identifies the AEAD used to seal the segments
+type CipherSuite uint8
+
+const (
+	SuiteAES256GCM        CipherSuite = 1
+	SuiteChaCha20Poly1305 CipherSuite = 2
+)
+
+// String returns the name of the cipher suite
+func (s CipherSuite) String() string {
+	switch s {
+	case SuiteAES256GCM:
+		return "AES-256-GCM"
+	case SuiteChaCha20Poly1305:
+		return "ChaCha20-Poly1305"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(s))
+	}
+}
+
+// KDF identifies how the data key of a file is derived
+type KDF uint8
+
+const (
+	// KDFNone means the key is supplied directly and cannot be re-derived
+	KDFNone KDF = 0
+	// KDFWalletSignature derives the key as the SHA-256 hash of an EIP-191 wallet
+	// signature over the key-derivation context
+	KDFWalletSignature KDF = 1
+	// KDFEnvelope uses a random data key that is wrapped to each recipient
+	KDFEnvelope KDF = 2
+	// KDFHierarchy derives the key from the wallet's key hierarchy, using the dataset
+	// ID in the KDF parameters and the file path in the key-derivation context
+	KDFHierarchy KDF = 3
+	// KDFPassphrase derives the key from a passphrase with scrypt, using the cost
+	// parameters and salt in the KDF parameters
+	KDFPassphrase KDF = 4
+)
+
+// String returns the name of the KDF
+func (k KDF) String() string {
+	switch k {
+	case KDFNone:
+		return "none"
+	case KDFWalletSignature:
+		return "wallet-signature"
+	case KDFEnvelope:
+		return "envelope"
+	case KDFHierarchy:
+		return "hierarchy"
+	case KDFPassphrase:
+		return "passphrase"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(k))
+	}
+}
+
+const (
+	// formatVersion1 is the interim format with a fixed AES-256-GCM suite and
+	// segment size and no header body; it is still read but no longer written
+	formatVersion1 = 1
+	// formatVersion is the version written by this package
+	formatVersion = 2
+)
+
+// Header record types
+const (
+	recordKDF       = 1
+	recordContext   = 2
+	recordRecipient = 3
+	recordKeyGen    = 4
+	// recordCompression holds the Compression of the plaintext, if any
+	recordCompression = 5
+)
+
+// DefaultChunkSize is the amount of plaintext sealed in each authenticated segment
+const DefaultChunkSize = 64 << 10
+
+// maxChunkSize bounds the segment buffers allocated when reading a header
+const maxChunkSize = 16 << 20
+
+// maxHeaderSize bounds the header body read from untrusted input
+const maxHeaderSize = 1 << 20
+
+// formatMagic starts every file in the authenticated format and distinguishes it
+// from the legacy base64 AES-CTR format
+var formatMagic = []byte("EASTORE")
+
+// Header describes how a file was encrypted
+type Header struct {
+	Version     uint8
+	Suite       CipherSuite
+	ChunkSize   uint32
+	NoncePrefix []byte
+	KDF         KDF
+	KDFParams   []byte
+	// Context is the key-derivation context, such as the message signed by the wallet
+	Context []byte
+	// Recipients hold the data key wrapped to each recipient of an envelope
+	Recipients []Recipient
+	// KeyGeneration counts how often the recipients were re-wrapped
+	KeyGeneration uint32
+	// Compression is applied to the plaintext before it is encrypted
+	Compression Compression
+
+	// raw holds the encoded version 1 preamble, which is its associated data
+	raw []byte
+	// size is the encoded size of a header that was read
+	size int
+}
+
+// NewHeader returns a header with the default cipher suite and chunk size
+func NewHeader() *Header {
+	return &Header{
+		Version:   formatVersion,
+		Suite:     SuiteAES256GCM,
+		ChunkSize: DefaultChunkSize,
+	}
+}
+
+// Marshal encodes the header
+func (h *Header) Marshal() ([]byte, error) {
+	return h.marshal(true)
+}
+
+// Size returns the encoded size of a header that was read, which is the offset of
+// the first segment
+func (h *Header) Size() int {
+	return h.size
+}
+
+// FileID returns the hex nonce prefix, which is random for every encrypted file and
+// so identifies it
+func (h *Header) FileID() string {
+	return hex.EncodeToString(h.NoncePrefix)
+}
+
+// aad returns the associated data that authenticates the header, which is the
+// header encoded without its key section
+func (h *Header) aad() ([]byte, error) {
+	if h.Version == formatVersion1 {
+		return h.raw, nil
+	}
+	return h.marshal(false)
+}
+
+func (h *Header) marshal(withKeys bool) ([]byte, error) {
+	if len(h.NoncePrefix) != noncePrefixSize {
+		return nil, fmt.Errorf("nonce prefix must be %d bytes", noncePrefixSize)
+	}
+
+	var body bytes.Buffer
+	body.WriteByte(byte(h.Suite))
+	binary.Write(&body, binary.BigEndian, h.ChunkSize)
+	body.Write(h.NoncePrefix)
+	writeRecord(&body, recordKDF, append([]byte{byte(h.KDF)}, h.KDFParams...))
+	if len(h.Context) > 0 {
+		writeRecord(&body, recordContext, h.Context)
+	}
+	if h.Compression != CompressionNone {
+		writeRecord(&body, recordCompression, []byte{byte(h.Compression)})
+	}
+	if withKeys {
+		for _, r := range h.Recipients {
+			writeRecord(&body, recordRecipient, append(crypto.CompressPubkey(r.PublicKey), r.WrappedKey...))
+		}
+		if h.KeyGeneration > 0 {
+			writeRecord(&body, recordKeyGen, binary.BigEndian.AppendUint32(nil, h.KeyGeneration))
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(formatMagic)
+	out.WriteByte(formatVersion)
+	binary.Write(&out, binary.BigEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes(), nil
+}
+
+func writeRecord(buf *bytes.Buffer, recordType byte, value []byte) {
+	buf.WriteByte(recordType)
+	binary.Write(buf, binary.BigEndian, uint32(len(value)))
+	buf.Write(value)
+}
+
+// ReadHeader reads and validates a header from r
+func ReadHeader(r io.Reader) (*Header, error) {
+	prefix := make([]byte, len(formatMagic)+1)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if !bytes.Equal(prefix[:len(formatMagic)], formatMagic) {
+		return nil, errors.New("not an Eastore encrypted file")
+	}
+
+	switch version := prefix[len(formatMagic)]; version {
+	case formatVersion1:
+		return readHeaderV1(r, prefix)
+	case formatVersion:
+	default:
+		return nil, fmt.Errorf("unsupported format version %d", version)
+	}
+
+	var bodyLen uint32
+	if err := binary.Read(r, binary.BigEndian, &bodyLen); err != nil {
+		return nil, fmt.Errorf("failed to read header length: %w", err)
+	}
+	if bodyLen > maxHeaderSize {
+		return nil, fmt.Errorf("header too large: %d bytes", bodyLen)
+	}
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	h, err := parseHeaderBody(body)
+	if err != nil {
+		return nil, err
+	}
+	h.size = len(prefix) + 4 + len(body)
+	return h, nil
+}
+
+// readHeaderV1 reads the interim version 1 preamble, which only holds the nonce prefix
+func readHeaderV1(r io.Reader, prefix []byte) (*Header, error) {
+	noncePrefix := make([]byte, noncePrefixSize)
+	if _, err := io.ReadFull(r, noncePrefix); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	return &Header{
+		Version:     formatVersion1,
+		Suite:       SuiteAES256GCM,
+		ChunkSize:   DefaultChunkSize,
+		NoncePrefix: noncePrefix,
+		KDF:         KDFWalletSignature,
+		raw:         append(prefix, noncePrefix...),
+		size:        len(prefix) + noncePrefixSize,
+	}, nil
+}
+
+func parseHeaderBody(body []byte) (*Header, error) {
+	const fixedSize = 1 + 4 + noncePrefixSize
+	if len(body) < fixedSize {
+		return nil, errors.New("header too short")
+	}
+
+	h := &Header{
+		Version:     formatVersion,
+		Suite:       CipherSuite(body[0]),
+		ChunkSize:   binary.BigEndian.Uint32(body[1:5]),
+		NoncePrefix: append([]byte{}, body[5:fixedSize]...),
+	}
+	if h.ChunkSize == 0 || h.ChunkSize > maxChunkSize {
+		return nil, fmt.Errorf("invalid chunk size %d", h.ChunkSize)
+	}
+
+	records := body[fixedSize:]
+	for len(records) > 0 {
+		if len(records) < 5 {
+			return nil, errors.New("truncated header record")
+		}
+		recordType := records[0]
+		length := binary.BigEndian.Uint32(records[1:5])
+		if uint64(length) > uint64(len(records)-5) {
+			return nil, errors.New("truncated header record")
+		}
+		value := records[5 : 5+length]
+		records = records[5+length:]
+
+		switch recordType {
+		case recordKDF:
+			if len(value) == 0 {
+				return nil, errors.New("empty KDF record")
+			}
+			h.KDF = KDF(value[0])
+			h.KDFParams = append([]byte{}, value[1:]...)
+		case recordContext:
+			h.Context = append([]byte{}, value...)
+		case recordRecipient:
+			if len(value) <= compressedPubkeySize {
+				return nil, errors.New("truncated recipient record")
+			}
+			pub, err := crypto.DecompressPubkey(value[:compressedPubkeySize])
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient public key: %w", err)
+			}
+			h.Recipients = append(h.Recipients, Recipient{
+				PublicKey:  pub,
+				WrappedKey: append([]byte{}, value[compressedPubkeySize:]...),
+			})
+		case recordKeyGen:
+			if len(value) != 4 {
+				return nil, errors.New("invalid key generation record")
+			}
+			h.KeyGeneration = binary.BigEndian.Uint32(value)
+		case recordCompression:
+			if len(value) != 1 {
+				return nil, errors.New("invalid compression record")
+			}
+			h.Compression = Compression(value[0])
+		default:
+			return nil, fmt.Errorf("unsupported header record type %d", recordType)
+		}
+	}
+	return h, nil
+}
+
+// newAEAD creates the AEAD of the header's cipher suite
+func (h *Header) newAEAD(key []byte) (cipher.AEAD, error) {
+	switch h.Suite {
+	case SuiteAES256GCM:
+		return newGCM(key)
+	case SuiteChaCha20Poly1305:
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create ChaCha20-Poly1305: %w", err)
+		}
+		return aead, nil
+	default:
+		return nil, fmt.Errorf("unsupported cipher suite %s", h.Suite)
+	}
+}
@@ -0,0 +1,230 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// withRecords returns an encoded header with extra records appended to its body
+func withRecords(raw []byte, records ...[]byte) []byte {
+	out := append([]byte{}, raw...)
+	for _, r := range records {
+		out = append(out, r...)
+	}
+	bodyLen := binary.BigEndian.Uint32(raw[8:12]) + uint32(len(out)-len(raw))
+	binary.BigEndian.PutUint32(out[8:12], bodyLen)
+	return out
+}
+
+func record(recordType byte, value []byte) []byte {
+	var buf bytes.Buffer
+	writeRecord(&buf, recordType, value)
+	return buf.Bytes()
+}
+
+func TestHeaderRoundTrip(t *testing.T) {
+	recipient, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Header{
+		Version:       formatVersion,
+		Suite:         SuiteChaCha20Poly1305,
+		ChunkSize:     4096,
+		NoncePrefix:   []byte{1, 2, 3, 4, 5, 6, 7},
+		KDF:           KDFPassphrase,
+		KDFParams:     []byte{9, 8, 7},
+		Context:       []byte("context"),
+		KeyGeneration: 3,
+		Compression:   CompressionZstd,
+	}
+	if err := h.AddRecipient(testKey(t), &recipient.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadHeader(bytes.NewReader(append(raw, "segments"...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Size() != len(raw) {
+		t.Errorf("Size() = %d, want %d", got.Size(), len(raw))
+	}
+	if got.Version != h.Version || got.Suite != h.Suite || got.ChunkSize != h.ChunkSize ||
+		got.KDF != h.KDF || got.KeyGeneration != h.KeyGeneration || got.Compression != h.Compression {
+		t.Errorf("header = %+v, want %+v", got, h)
+	}
+	if !bytes.Equal(got.NoncePrefix, h.NoncePrefix) || !bytes.Equal(got.KDFParams, h.KDFParams) || !bytes.Equal(got.Context, h.Context) {
+		t.Errorf("header = %+v, want %+v", got, h)
+	}
+	if len(got.Recipients) != 1 || !got.Recipients[0].PublicKey.Equal(&recipient.PublicKey) ||
+		!bytes.Equal(got.Recipients[0].WrappedKey, h.Recipients[0].WrappedKey) {
+		t.Errorf("recipients = %+v, want %+v", got.Recipients, h.Recipients)
+	}
+}
+
+func TestReadHeaderErrors(t *testing.T) {
+	h := NewHeader()
+	h.NoncePrefix = make([]byte, noncePrefixSize)
+	h.KDF = KDFWalletSignature
+	raw, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"bad magic", append([]byte("EASTORF"), raw[7:]...)},
+		{"unsupported version", append(append([]byte("EASTORE"), 9), raw[8:]...)},
+		{"truncated body", raw[:len(raw)-1]},
+		{"unknown record", withRecords(raw, record(99, []byte("x")))},
+		{"truncated record header", withRecords(raw, []byte{recordContext, 0, 0})},
+		{"truncated record value", withRecords(raw, []byte{recordContext, 0, 0, 0, 9, 'x'})},
+		{"empty KDF record", withRecords(raw, record(recordKDF, nil))},
+		{"invalid key generation", withRecords(raw, record(recordKeyGen, []byte{1}))},
+		{"invalid compression", withRecords(raw, record(recordCompression, []byte{1, 2}))},
+		{"truncated recipient", withRecords(raw, record(recordRecipient, make([]byte, compressedPubkeySize)))},
+		{"header too large", func() []byte {
+			b := append([]byte{}, raw...)
+			binary.BigEndian.PutUint32(b[8:12], maxHeaderSize+1)
+			return b
+		}()},
+		{"zero chunk size", func() []byte {
+			b := append([]byte{}, raw...)
+			binary.BigEndian.PutUint32(b[13:17], 0)
+			return b
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadHeader(bytes.NewReader(tt.data)); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestHeaderAuthenticated(t *testing.T) {
+	key := testKey(t)
+	plain := randomBytes(t, 3*testSegmentSize+1)
+
+	header := NewHeader()
+	header.ChunkSize = testSegmentSize
+	header.KDF = KDFWalletSignature
+	header.Context = []byte("message")
+	data := encryptBytes(t, key, plain, header)
+
+	parsed, err := ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments := data[parsed.Size():]
+
+	// replace re-encodes the header after modifying it
+	replace := func(modify func(h *Header)) []byte {
+		h, _ := ReadHeader(bytes.NewReader(data))
+		modify(h)
+		raw, err := h.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(raw, segments...)
+	}
+
+	recipient, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"unchanged", data, false},
+		{"context changed", replace(func(h *Header) { h.Context = []byte("massage") }), true},
+		{"KDF changed", replace(func(h *Header) { h.KDF = KDFNone }), true},
+		{"KDF parameters changed", replace(func(h *Header) { h.KDFParams = []byte{1} }), true},
+		{"recipient added", replace(func(h *Header) {
+			if err := h.AddRecipient(key, &recipient.PublicKey); err != nil {
+				t.Fatal(err)
+			}
+		}), false},
+		{"key generation changed", replace(func(h *Header) { h.KeyGeneration = 7 }), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decryptBytes(key, tt.data)
+			if tt.wantErr {
+				if !errors.Is(err, ErrAuthentication) {
+					t.Fatalf("err = %v, want ErrAuthentication", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatal("decrypted data differs from the plaintext")
+			}
+		})
+	}
+}
+
+func TestReadHeaderV1(t *testing.T) {
+	key := testKey(t)
+	plain := randomBytes(t, DefaultChunkSize+100)
+	noncePrefix := randomBytes(t, noncePrefixSize)
+
+	// A version 1 file is its preamble followed by AES-256-GCM segments of the
+	// default size, authenticated with the preamble
+	preamble := append(append(append([]byte{}, formatMagic...), formatVersion1), noncePrefix...)
+	aead, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data bytes.Buffer
+	data.Write(preamble)
+	w := newSegmentWriter(&data, aead, noncePrefix, preamble, DefaultChunkSize)
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := ReadHeader(bytes.NewReader(data.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != formatVersion1 || h.Suite != SuiteAES256GCM || h.ChunkSize != DefaultChunkSize ||
+		h.KDF != KDFWalletSignature || h.Size() != len(preamble) || !bytes.Equal(h.NoncePrefix, noncePrefix) {
+		t.Fatalf("header = %+v", h)
+	}
+
+	got, err := decryptBytes(key, data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("decrypted data differs from the plaintext")
+	}
+
+	tampered := append([]byte{}, data.Bytes()...)
+	tampered[len(formatMagic)+2] ^= 1
+	if _, err := decryptBytes(key, tampered); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("tampered preamble: err = %v, want ErrAuthentication", err)
+	}
+}
@@ -0,0 +1,246 @@
+package encryption
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/eastore-project/eastore/pkg/utils"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Keys of a folder are derived from a single wallet signature:
+//
+//	root      = HKDF-Extract(salt "eastore-root-v1", signature of RootMessage)
+//	dataset   = HKDF-Expand(root, "eastore/dataset/" + dataset ID)
+//	directory = HKDF-Expand(parent, "eastore/dir/" + name), for each path component
+//	file      = HKDF-Expand(directory, "eastore/file/" + name)
+//
+// Paths are those of the encrypted tree, so obfuscated names never leave the manifest.
+// Anyone holding the key of a directory can derive the keys of everything below it,
+// but nothing above or beside it.
+
+// RootMessage is the message signed to derive the root of the key hierarchy. It is
+// fixed and distinct from any CID, so the signature cannot be mistaken for another use
+const RootMessage = "Eastore key hierarchy v1\n\nSign this message to derive your Eastore encryption keys."
+
+// scopedKeyPrefix starts the text form of a ScopedKey
+const scopedKeyPrefix = "eastore-dirkey-v1:"
+
+// ScopedKey is the key of a directory in a dataset's key hierarchy, which unlocks
+// only the files below that directory
+type ScopedKey struct {
+	Dataset string `json:"dataset"`
+	// Path is the slash-separated path of the directory in the encrypted tree; it
+	// is empty for the dataset root
+	Path string `json:"path"`
+	Key  []byte `json:"key"`
+}
+
+// RootKey derives the root of the key hierarchy from the wallet's signature over RootMessage
+func RootKey(privateKey string) ([]byte, error) {
+	if privateKey == "" {
+		return nil, ErrWalletRequired
+	}
+	signature, err := utils.SignMessage(privateKey, RootMessage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign key hierarchy root: %w", err)
+	}
+	return hkdf.Extract(sha256.New, signature, []byte("eastore-root-v1")), nil
+}
+
+// DatasetKey derives the key of a dataset from the root key
+func DatasetKey(root []byte, dataset string) (*ScopedKey, error) {
+	key, err := expandKey(root, "eastore/dataset/"+dataset)
+	if err != nil {
+		return nil, err
+	}
+	return &ScopedKey{Dataset: dataset, Key: key}, nil
+}
+
+// Dir derives the key of a directory below k, given its path relative to k
+func (k *ScopedKey) Dir(rel string) (*ScopedKey, error) {
+	key := k.Key
+	for _, name := range splitPath(rel) {
+		var err error
+		if key, err = expandKey(key, "eastore/dir/"+name); err != nil {
+			return nil, err
+		}
+	}
+
+	p := path.Join(k.Path, rel)
+	if p == "." {
+		p = ""
+	}
+	return &ScopedKey{Dataset: k.Dataset, Path: p, Key: key}, nil
+}
+
+// Contains reports whether the file at p, its path in the dataset, is below the
+// directory of k
+func (k *ScopedKey) Contains(p string) bool {
+	return k.Path == "" || strings.HasPrefix(path.Clean(p), k.Path+"/")
+}
+
+// FileKey derives the key of the file at p, the file's path in the dataset
+func (k *ScopedKey) FileKey(p string) ([]byte, error) {
+	p = path.Clean(p)
+	if !k.Contains(p) {
+		return nil, fmt.Errorf("%s is outside the shared directory %s", p, k.Path)
+	}
+	rel := p
+	if k.Path != "" {
+		rel = strings.TrimPrefix(p, k.Path+"/")
+	}
+
+	dir, err := k.Dir(path.Dir(rel))
+	if err != nil {
+		return nil, err
+	}
+	return expandKey(dir.Key, "eastore/file/"+path.Base(rel))
+}
+
+// String encodes the key so that it can be shared
+func (k *ScopedKey) String() string {
+	data, _ := json.Marshal(k)
+	return scopedKeyPrefix + base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseScopedKey decodes a key produced by ScopedKey.String
+func ParseScopedKey(s string) (*ScopedKey, error) {
+	if !strings.HasPrefix(s, scopedKeyPrefix) {
+		return nil, errors.New("not a directory key")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, scopedKeyPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory key: %w", err)
+	}
+
+	k := &ScopedKey{}
+	if err := json.Unmarshal(data, k); err != nil {
+		return nil, fmt.Errorf("invalid directory key: %w", err)
+	}
+	if k.Dataset == "" || len(k.Key) != sha256.Size {
+		return nil, errors.New("invalid directory key")
+	}
+	return k, nil
+}
+
+// IsScopedKey reports whether s looks like a shared directory key
+func IsScopedKey(s string) bool {
+	return strings.HasPrefix(s, scopedKeyPrefix)
+}
+
+// NewHierarchyHeader returns a header for the file at p in the dataset
+func NewHierarchyHeader(dataset, p string) *Header {
+	header := NewHeader()
+	header.KDF = KDFHierarchy
+	header.KDFParams = []byte(dataset)
+	header.Context = []byte(path.Clean(p))
+	return header
+}
+
+// Unlocker recovers the keys of encrypted files, from the wallet, a passphrase or a
+// shared directory key. It signs the hierarchy root and runs scrypt at most once, so
+// a whole folder is decrypted with a single signature or passphrase
+type Unlocker struct {
+	PrivateKey string
+	// Scoped, if set, is used for files of the key hierarchy instead of the wallet
+	Scoped *ScopedKey
+	// Passphrase, if set, is called the first time a file encrypted with a
+	// passphrase is met
+	Passphrase func() ([]byte, error)
+	// Known, if set, returns the key of a file that is already known, such as
+	// from a key vault, or nil; it is tried before any derivation
+	Known func(header *Header) []byte
+
+	root       []byte
+	passphrase []byte
+	// scrypt keys by KDF parameters
+	passphraseKeys map[string][]byte
+}
+
+// Key returns the data key of the file with the given header
+func (u *Unlocker) Key(header *Header) ([]byte, error) {
+	if u.Known != nil {
+		if key := u.Known(header); key != nil {
+			return key, nil
+		}
+	}
+	if header.KDF == KDFPassphrase {
+		return u.passphraseKey(header)
+	}
+	if header.KDF != KDFHierarchy {
+		if u.Scoped != nil {
+			return nil, fmt.Errorf("a directory key cannot unlock a file with KDF %s", header.KDF)
+		}
+		return DeriveKey(header, u.PrivateKey)
+	}
+
+	dataset := string(header.KDFParams)
+	scoped := u.Scoped
+	if scoped == nil {
+		if u.root == nil {
+			root, err := RootKey(u.PrivateKey)
+			if err != nil {
+				return nil, err
+			}
+			u.root = root
+		}
+		var err error
+		if scoped, err = DatasetKey(u.root, dataset); err != nil {
+			return nil, err
+		}
+	} else if scoped.Dataset != dataset {
+		return nil, errors.New("the directory key belongs to a different dataset")
+	}
+	return scoped.FileKey(string(header.Context))
+}
+
+func (u *Unlocker) passphraseKey(header *Header) ([]byte, error) {
+	if master, ok := u.passphraseKeys[string(header.KDFParams)]; ok {
+		return passphraseFileKey(master, header)
+	}
+
+	if u.passphrase == nil {
+		if u.Passphrase == nil {
+			return nil, ErrPassphraseRequired
+		}
+		passphrase, err := u.Passphrase()
+		if err != nil {
+			return nil, err
+		}
+		u.passphrase = passphrase
+	}
+	master, err := passphraseMasterKey(header, u.passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.passphraseKeys == nil {
+		u.passphraseKeys = map[string][]byte{}
+	}
+	u.passphraseKeys[string(header.KDFParams)] = master
+	return passphraseFileKey(master, header)
+}
+
+// expandKey derives a 32-byte subkey of key for info
+func expandKey(key []byte, info string) ([]byte, error) {
+	out := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, key, []byte(info)), out); err != nil {
+		return nil, fmt.Errorf("failed to derive key for %s: %w", info, err)
+	}
+	return out, nil
+}
+
+func splitPath(p string) []string {
+	p = path.Clean(p)
+	if p == "." || p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
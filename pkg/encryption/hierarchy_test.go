@@ -0,0 +1,289 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// testPrivateKey is a well-known test wallet
+const testPrivateKey = "4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"
+
+func newPrivateKey(t *testing.T) string {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(crypto.FromECDSA(key))
+}
+
+func testDatasetKey(t *testing.T, privateKey, dataset string) *ScopedKey {
+	t.Helper()
+	root, err := RootKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := DatasetKey(root, dataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func dirKey(t *testing.T, k *ScopedKey, rel string) *ScopedKey {
+	t.Helper()
+	dir, err := k.Dir(rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func fileKey(t *testing.T, k *ScopedKey, p string) []byte {
+	t.Helper()
+	key, err := k.FileKey(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestScopedKeyUnlocksSubtree(t *testing.T) {
+	dataset := testDatasetKey(t, testPrivateKey, "dataset")
+	a := dirKey(t, dataset, "a")
+	ab := dirKey(t, a, "b")
+	if a.Path != "a" || ab.Path != "a/b" || dirKey(t, dataset, "a/b").Path != "a/b" {
+		t.Fatalf("directory key paths = %q and %q, want a and a/b", a.Path, ab.Path)
+	}
+	if !bytes.Equal(ab.Key, dirKey(t, dataset, "a/b").Key) {
+		t.Fatal("deriving a/b in one step and in two steps gives different keys")
+	}
+
+	// Every key a directory key derives is the one the dataset key derives
+	for _, k := range []*ScopedKey{a, ab} {
+		for _, p := range []string{"a/b/x", "a/b/c/d/x"} {
+			if !bytes.Equal(fileKey(t, k, p), fileKey(t, dataset, p)) {
+				t.Fatalf("key %q derives a different key for %s than the dataset key", k.Path, p)
+			}
+		}
+	}
+	if !bytes.Equal(fileKey(t, a, "a/x"), fileKey(t, dataset, "a/x")) {
+		t.Fatal("key a derives a different key for a/x than the dataset key")
+	}
+
+	// Keys of distinct files, and of a file and a directory of the same name, differ
+	seen := map[string]string{}
+	for p, key := range map[string][]byte{
+		"file a/x":  fileKey(t, dataset, "a/x"),
+		"file a/y":  fileKey(t, dataset, "a/y"),
+		"file ab/x": fileKey(t, dataset, "ab/x"),
+		"file b/x":  fileKey(t, dataset, "b/x"),
+		"file a":    fileKey(t, dataset, "a"),
+		"dir a":     a.Key,
+		"dir a/b":   ab.Key,
+		"dir ab":    dirKey(t, dataset, "ab").Key,
+		"dataset":   dataset.Key,
+	} {
+		if other, ok := seen[string(key)]; ok {
+			t.Fatalf("%s and %s have the same key", p, other)
+		}
+		seen[string(key)] = p
+	}
+}
+
+func TestScopedKeyContains(t *testing.T) {
+	dataset := testDatasetKey(t, testPrivateKey, "dataset")
+	a := dirKey(t, dataset, "a")
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"a/x", true},
+		{"a/b/x", true},
+		{"./a/x", true},
+		{"a/./x", true},
+		{"a//x", true},
+		// Cleaned, this is a/x again
+		{"a/../a/x", true},
+		// A sibling whose name starts with the directory's name
+		{"ab/x", false},
+		{"ab", false},
+		{"a.b/x", false},
+		// The directory itself is not a file below it
+		{"a", false},
+		{"a/", false},
+		{"x", false},
+		{"b/a/x", false},
+		// Parent references must not lead out of the directory
+		{"a/../b/x", false},
+		{"a/../ab/x", false},
+		{"a/b/../../x", false},
+		{"../a/x", false},
+		{"..", false},
+		{"/a/x", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := a.Contains(tt.path); got != tt.want {
+			t.Errorf("Contains(%q) = %t, want %t", tt.path, got, tt.want)
+		}
+
+		key, err := a.FileKey(tt.path)
+		if !tt.want {
+			if err == nil {
+				t.Errorf("FileKey(%q) = %x, want an error", tt.path, key)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("FileKey(%q): %v", tt.path, err)
+		} else if !bytes.Equal(key, fileKey(t, dataset, tt.path)) {
+			t.Errorf("FileKey(%q) differs from the dataset key's", tt.path)
+		}
+	}
+
+	// The dataset key contains the whole dataset
+	for _, p := range []string{"x", "a/x", "ab/x"} {
+		if !dataset.Contains(p) {
+			t.Errorf("dataset key does not contain %s", p)
+		}
+	}
+}
+
+func TestUnlockerScopedKey(t *testing.T) {
+	dataset := testDatasetKey(t, testPrivateKey, "dataset")
+	a := dirKey(t, dataset, "a")
+	shared, err := ParseScopedKey(a.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped := &Unlocker{Scoped: shared}
+	wallet := &Unlocker{PrivateKey: testPrivateKey}
+
+	// A file below the shared directory unlocks to the key the wallet derives
+	header := NewHierarchyHeader("dataset", "a/b/x")
+	key, err := scoped.Key(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := wallet.Key(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, want) {
+		t.Fatal("the shared directory key and the wallet derive different keys")
+	}
+
+	// The context is read from the file header, so it is not trusted to be clean
+	for _, context := range []string{"ab/x", "a/../b/x", "../a/x", "/a/x", "b/x"} {
+		header := NewHierarchyHeader("dataset", "b/x")
+		header.Context = []byte(context)
+		if key, err := scoped.Key(header); err == nil {
+			t.Errorf("context %q unlocked with key %x, want an error", context, key)
+		}
+	}
+
+	// The same path in another dataset, of the same or of another wallet, is refused
+	if _, err := scoped.Key(NewHierarchyHeader("other", "a/x")); err == nil || !strings.Contains(err.Error(), "different dataset") {
+		t.Fatalf("key of another dataset: err = %v, want it refused", err)
+	}
+	other := dirKey(t, testDatasetKey(t, newPrivateKey(t), "dataset"), "a")
+	if bytes.Equal(fileKey(t, other, "a/x"), fileKey(t, a, "a/x")) {
+		t.Fatal("another wallet's dataset of the same ID derives the same keys")
+	}
+	if bytes.Equal(fileKey(t, dirKey(t, testDatasetKey(t, testPrivateKey, "other"), "a"), "a/x"), fileKey(t, a, "a/x")) {
+		t.Fatal("another dataset of the same wallet derives the same keys")
+	}
+
+	// A directory key only unlocks files of the key hierarchy
+	if _, err := scoped.Key(NewHeader()); err == nil {
+		t.Fatal("directory key unlocked a file with another KDF")
+	}
+}
+
+func TestParseScopedKey(t *testing.T) {
+	k := dirKey(t, testDatasetKey(t, testPrivateKey, "dataset"), "a/b")
+	if !IsScopedKey(k.String()) {
+		t.Fatalf("IsScopedKey(%q) = false", k.String())
+	}
+	got, err := ParseScopedKey(k.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Dataset != k.Dataset || got.Path != k.Path || !bytes.Equal(got.Key, k.Key) {
+		t.Fatalf("ParseScopedKey = %+v, want %+v", got, k)
+	}
+
+	for _, s := range []string{
+		"",
+		hex.EncodeToString(k.Key),
+		scopedKeyPrefix + "!!!",
+		scopedKeyPrefix + "e30", // {}
+		scopedKeyPrefix + "eyJkYXRhc2V0IjoiZCIsImtleSI6IkFBQUEifQ", // {"dataset":"d","key":"AAAA"}
+	} {
+		if _, err := ParseScopedKey(s); err == nil {
+			t.Errorf("ParseScopedKey(%q) succeeded", s)
+		}
+	}
+}
+
+func TestDecryptDirScopedKey(t *testing.T) {
+	root := t.TempDir()
+	inputDir := filepath.Join(root, "in")
+	files := map[string]string{
+		"top":     "top",
+		"a/x":     "a/x",
+		"a/b/y":   "a/b/y",
+		"ab/x":    "ab/x",
+		"b/a/x":   "b/a/x",
+		"a.b/x":   "a.b/x",
+		"b/other": "b/other",
+	}
+	for name, content := range files {
+		p := filepath.Join(inputDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	encryptedDir := filepath.Join(root, "encrypted")
+	manifest, err := EncryptDir(inputDir, encryptedDir, testPrivateKey, DirOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Dataset == "" {
+		t.Fatal("folder was not encrypted in the key hierarchy")
+	}
+
+	shared := dirKey(t, testDatasetKey(t, testPrivateKey, manifest.Dataset), "a")
+	outDir := filepath.Join(root, "out")
+	count, err := DecryptDir(encryptedDir, outDir, manifest, &Unlocker{Scoped: shared})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("decrypted %d files, want the 2 below a", count)
+	}
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(outDir, filepath.FromSlash(name)))
+		inside := strings.HasPrefix(name, "a/")
+		switch {
+		case inside && err != nil:
+			t.Errorf("%s was not decrypted: %v", name, err)
+		case inside && string(data) != content:
+			t.Errorf("%s decrypted to %q, want %q", name, data, content)
+		case !inside && err == nil:
+			t.Errorf("%s, outside the shared directory, was decrypted", name)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/eastore-project/eastore/pkg/shamir"
+)
+
+// keySharePrefix starts the text form of a KeyShare
+const keySharePrefix = "eastore-share-v1:"
+
+// keyShareChecksumSize is the size of the checksum that ends a key share
+const keyShareChecksumSize = 4
+
+// keyShareEncoding is case-insensitive and avoids characters that are easily
+// confused, so shares can be written down and typed back
+var keyShareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ErrInvalidKeyShare is returned for a key share that is malformed or whose
+// checksum does not match
+var ErrInvalidKeyShare = errors.New("invalid key share")
+
+// KeyShare is one of the Shamir shares a data key is split into. Any Threshold of
+// the Total shares recover the key; fewer reveal nothing about it
+type KeyShare struct {
+	Threshold int
+	Total     int
+	Index     int
+	// KeyRef is the KeyID of the key, used to group shares and check the result
+	KeyRef string
+	Value  []byte
+}
+
+// SplitKey splits a key into n shares, any threshold of which recover it
+func SplitKey(key []byte, n, threshold int) ([]*KeyShare, error) {
+	parts, err := shamir.Split(key, n, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([]*KeyShare, len(parts))
+	for i, p := range parts {
+		shares[i] = &KeyShare{
+			Threshold: threshold,
+			Total:     n,
+			Index:     int(p.X),
+			KeyRef:    KeyID(key),
+			Value:     p.Y,
+		}
+	}
+	return shares, nil
+}
+
+// CombineKey recovers a key from at least the threshold number of its shares
+func CombineKey(shares []*KeyShare) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no key shares given")
+	}
+	first := shares[0]
+	parts := make([]shamir.Share, len(shares))
+	for i, s := range shares {
+		if s.KeyRef != first.KeyRef {
+			return nil, fmt.Errorf("key shares belong to different keys: %s and %s", first.KeyRef, s.KeyRef)
+		}
+		if s.Threshold != first.Threshold || s.Total != first.Total {
+			return nil, fmt.Errorf("key share %d was split differently from key share %d", s.Index, first.Index)
+		}
+		parts[i] = shamir.Share{X: byte(s.Index), Y: s.Value}
+	}
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("key %s needs %d of its %d shares, but only %d were given", first.KeyRef, first.Threshold, first.Total, len(shares))
+	}
+
+	key, err := shamir.Combine(parts)
+	if err != nil {
+		return nil, err
+	}
+	if KeyID(key) != first.KeyRef {
+		return nil, fmt.Errorf("recovered key does not match key reference %s: a share is corrupted", first.KeyRef)
+	}
+	return key, nil
+}
+
+// String encodes the share as printable text ending in a checksum
+func (s *KeyShare) String() string {
+	ref, _ := hex.DecodeString(s.KeyRef)
+	var b bytes.Buffer
+	b.WriteByte(byte(s.Threshold))
+	b.WriteByte(byte(s.Total))
+	b.WriteByte(byte(s.Index))
+	b.WriteByte(byte(len(ref)))
+	b.Write(ref)
+	b.Write(s.Value)
+	b.Write(keyShareChecksum(b.Bytes()))
+
+	text := keyShareEncoding.EncodeToString(b.Bytes())
+	var groups []string
+	for len(text) > 4 {
+		groups = append(groups, text[:4])
+		text = text[4:]
+	}
+	return keySharePrefix + strings.Join(append(groups, text), "-")
+}
+
+// ParseKeyShare decodes a share produced by KeyShare.String. Case, whitespace and
+// dashes in the encoded part are ignored
+func ParseKeyShare(s string) (*KeyShare, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, keySharePrefix) {
+		return nil, fmt.Errorf("%w: missing %s prefix", ErrInvalidKeyShare, keySharePrefix)
+	}
+	text := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimPrefix(s, keySharePrefix)))
+	data, err := keyShareEncoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKeyShare, err)
+	}
+
+	if len(data) < 4+keyShareChecksumSize {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidKeyShare)
+	}
+	body, sum := data[:len(data)-keyShareChecksumSize], data[len(data)-keyShareChecksumSize:]
+	if !bytes.Equal(keyShareChecksum(body), sum) {
+		return nil, fmt.Errorf("%w: checksum mismatch, check for typos", ErrInvalidKeyShare)
+	}
+
+	refLen := int(body[3])
+	if len(body) <= 4+refLen {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidKeyShare)
+	}
+	share := &KeyShare{
+		Threshold: int(body[0]),
+		Total:     int(body[1]),
+		Index:     int(body[2]),
+		KeyRef:    hex.EncodeToString(body[4 : 4+refLen]),
+		Value:     body[4+refLen:],
+	}
+	if share.Threshold < 2 || share.Total < share.Threshold || share.Index < 1 || share.Index > share.Total {
+		return nil, fmt.Errorf("%w: share %d of %d with threshold %d", ErrInvalidKeyShare, share.Index, share.Total, share.Threshold)
+	}
+	return share, nil
+}
+
+func keyShareChecksum(data []byte) []byte {
+	sum := sha256.Sum256(append([]byte("eastore-key-share:"), data...))
+	return sum[:keyShareChecksumSize]
+}
@@ -0,0 +1,137 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKeyShareStringParse(t *testing.T) {
+	key := testKey(t)
+	shares, err := SplitKey(key, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range shares {
+		text := s.String()
+		if !strings.HasPrefix(text, keySharePrefix) {
+			t.Fatalf("share %q does not start with %s", text, keySharePrefix)
+		}
+		encoded := strings.TrimPrefix(text, keySharePrefix)
+
+		// Shares are typed back by hand, so case, spacing and dashes do not matter
+		for _, variant := range []string{
+			text,
+			keySharePrefix + strings.ToLower(encoded),
+			keySharePrefix + strings.ReplaceAll(encoded, "-", ""),
+			"  " + keySharePrefix + strings.ReplaceAll(encoded, "-", " ") + "\n",
+		} {
+			got, err := ParseKeyShare(variant)
+			if err != nil {
+				t.Fatalf("ParseKeyShare(%q): %v", variant, err)
+			}
+			if got.Threshold != s.Threshold || got.Total != s.Total || got.Index != s.Index ||
+				got.KeyRef != s.KeyRef || !bytes.Equal(got.Value, s.Value) {
+				t.Fatalf("ParseKeyShare(%q) = %+v, want %+v", variant, got, s)
+			}
+		}
+	}
+}
+
+func TestParseKeyShareErrors(t *testing.T) {
+	shares, err := SplitKey(testKey(t), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := shares[0].String()
+
+	// typo replaces one character of the encoded part with another valid one
+	typo := func(i int) string {
+		b := []byte(text)
+		i += len(keySharePrefix)
+		if b[i] == '-' {
+			i++
+		}
+		if b[i] == 'A' {
+			b[i] = 'B'
+		} else {
+			b[i] = 'A'
+		}
+		return string(b)
+	}
+	// reencode returns the share with a valid checksum over a modified body
+	reencode := func(modify func(s *KeyShare)) string {
+		s := *shares[0]
+		modify(&s)
+		return s.String()
+	}
+
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"missing prefix", strings.TrimPrefix(text, keySharePrefix)},
+		{"wrong prefix", "eastore-share-v2:" + strings.TrimPrefix(text, keySharePrefix)},
+		{"typo at the start", typo(0)},
+		{"typo in the middle", typo(len(text) / 2)},
+		{"typo in the checksum", typo(len(text) - len(keySharePrefix) - 3)},
+		{"not base32", text + "!"},
+		{"truncated", text[:len(text)-5]},
+		{"too short", keySharePrefix + "AAAA"},
+		{"threshold below 2", reencode(func(s *KeyShare) { s.Threshold = 1 })},
+		{"index 0", reencode(func(s *KeyShare) { s.Index = 0 })},
+		{"index above total", reencode(func(s *KeyShare) { s.Index = 4 })},
+		{"no value", reencode(func(s *KeyShare) { s.Value = nil })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseKeyShare(tt.text); !errors.Is(err, ErrInvalidKeyShare) {
+				t.Fatalf("ParseKeyShare(%q): err = %v, want ErrInvalidKeyShare", tt.text, err)
+			}
+		})
+	}
+}
+
+func TestCombineKey(t *testing.T) {
+	key := testKey(t)
+	shares, err := SplitKey(key, 4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := SplitKey(testKey(t), 4, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := CombineKey([]*KeyShare{shares[3], shares[0], shares[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatal("recovered key differs from the original")
+	}
+
+	corrupted := *shares[1]
+	corrupted.Value = append([]byte{}, corrupted.Value...)
+	corrupted.Value[0] ^= 1
+
+	tests := []struct {
+		name   string
+		shares []*KeyShare
+	}{
+		{"no shares", nil},
+		{"fewer than the threshold", shares[:2]},
+		{"different keys", []*KeyShare{shares[0], shares[1], other[2]}},
+		{"corrupted share", []*KeyShare{shares[0], &corrupted, shares[2]}},
+		{"duplicate share", []*KeyShare{shares[0], shares[1], shares[1]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CombineKey(tt.shares); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
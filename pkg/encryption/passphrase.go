@@ -0,0 +1,149 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// A passphrase key is derived with scrypt, whose cost parameters and salt are
+// recorded in the KDF parameters as log2(N) (1 byte) || r (4 bytes, big endian) ||
+// p (4 bytes, big endian) || salt. A non-empty key-derivation context, used for the
+// files of a folder, is the file's path, and the key is
+//
+//	file = HKDF-Expand(scrypt key, "eastore/file/" + path)
+//
+// so that a whole folder needs a single scrypt run
+
+// ErrPassphraseRequired is returned when the key of a file can only be derived from
+// a passphrase
+var ErrPassphraseRequired = errors.New("file is encrypted with a passphrase")
+
+const (
+	// passphraseSaltSize is the size of the random scrypt salt
+	passphraseSaltSize = 16
+	// maxScryptMemory bounds the memory scrypt may use for parameters read from a
+	// header, so that a crafted file cannot exhaust it
+	maxScryptMemory = 1 << 30
+)
+
+// ScryptParams are the cost parameters of scrypt
+type ScryptParams struct {
+	// LogN is log2 of the CPU/memory cost N
+	LogN uint8
+	R    uint32
+	P    uint32
+}
+
+// DefaultScryptParams take about 128 MiB of memory and half a second on current
+// hardware
+var DefaultScryptParams = ScryptParams{LogN: 17, R: 8, P: 1}
+
+// validate checks that the parameters are usable and within maxScryptMemory
+func (p ScryptParams) validate() error {
+	if p.LogN == 0 || p.LogN > 30 || p.R == 0 || p.R > 1<<20 || p.P == 0 || p.P > 16 {
+		return fmt.Errorf("invalid scrypt parameters N=2^%d r=%d p=%d", p.LogN, p.R, p.P)
+	}
+	if memory := 128 * uint64(p.R) << p.LogN; memory > maxScryptMemory {
+		return fmt.Errorf("scrypt parameters N=2^%d r=%d need %d MiB of memory, more than the %d MiB allowed", p.LogN, p.R, memory>>20, maxScryptMemory>>20)
+	}
+	return nil
+}
+
+// NewPassphraseHeader returns a header for a key derived from a passphrase with a
+// new random salt
+func NewPassphraseHeader(params ScryptParams) (*Header, error) {
+	if err := params.validate(); err != nil {
+		return nil, err
+	}
+	salt := make([]byte, passphraseSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	kdfParams := []byte{params.LogN}
+	kdfParams = binary.BigEndian.AppendUint32(kdfParams, params.R)
+	kdfParams = binary.BigEndian.AppendUint32(kdfParams, params.P)
+
+	header := NewHeader()
+	header.KDF = KDFPassphrase
+	header.KDFParams = append(kdfParams, salt...)
+	return header, nil
+}
+
+// parsePassphraseParams decodes the KDF parameters of a passphrase header
+func parsePassphraseParams(kdfParams []byte) (ScryptParams, []byte, error) {
+	if len(kdfParams) < 9+passphraseSaltSize {
+		return ScryptParams{}, nil, errors.New("invalid passphrase KDF parameters")
+	}
+	params := ScryptParams{
+		LogN: kdfParams[0],
+		R:    binary.BigEndian.Uint32(kdfParams[1:5]),
+		P:    binary.BigEndian.Uint32(kdfParams[5:9]),
+	}
+	if err := params.validate(); err != nil {
+		return ScryptParams{}, nil, err
+	}
+	return params, kdfParams[9:], nil
+}
+
+// passphraseMasterKey runs scrypt over the passphrase with the parameters and salt
+// of a passphrase header
+func passphraseMasterKey(header *Header, passphrase []byte) ([]byte, error) {
+	if header.KDF != KDFPassphrase {
+		return nil, fmt.Errorf("key of a file with KDF %s cannot be derived from a passphrase", header.KDF)
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	params, salt, err := parsePassphraseParams(header.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := scrypt.Key(passphrase, salt, 1<<params.LogN, int(params.R), int(params.P), 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key from passphrase: %w", err)
+	}
+	return key, nil
+}
+
+// passphraseFileKey derives the key of a file from the scrypt key of its header
+func passphraseFileKey(master []byte, header *Header) ([]byte, error) {
+	if len(header.Context) == 0 {
+		return master, nil
+	}
+	return expandKey(master, "eastore/file/"+string(header.Context))
+}
+
+// PassphraseKey derives the data key of a file encrypted with a passphrase
+func PassphraseKey(header *Header, passphrase []byte) ([]byte, error) {
+	master, err := passphraseMasterKey(header, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return passphraseFileKey(master, header)
+}
+
+// EncryptFileWithPassphrase encrypts the file at inputPath into outputPath with a key
+// derived from the passphrase, so that no wallet is needed to encrypt or decrypt it.
+// Returns the key
+func EncryptFileWithPassphrase(inputPath, outputPath string, passphrase []byte, params ScryptParams, compression Compression) ([]byte, error) {
+	header, err := NewPassphraseHeader(params)
+	if err != nil {
+		return nil, err
+	}
+	header.Compression = compression
+	key, err := PassphraseKey(header, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := encryptFile(inputPath, outputPath, key, header); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,237 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testScryptParams keep scrypt fast in tests
+var testScryptParams = ScryptParams{LogN: 10, R: 8, P: 1}
+
+func newTestPassphraseHeader(t *testing.T) *Header {
+	t.Helper()
+	header, err := NewPassphraseHeader(testScryptParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+func passphraseKey(t *testing.T, header *Header, passphrase string) []byte {
+	t.Helper()
+	key, err := PassphraseKey(header, []byte(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// encodeScryptParams returns KDF parameters as they are stored in a header
+func encodeScryptParams(logN uint8, r, p uint32) []byte {
+	params := []byte{logN}
+	params = binary.BigEndian.AppendUint32(params, r)
+	params = binary.BigEndian.AppendUint32(params, p)
+	return append(params, make([]byte, passphraseSaltSize)...)
+}
+
+func TestScryptParamsFromHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []byte
+		valid  bool
+	}{
+		{"defaults", encodeScryptParams(17, 8, 1), true},
+		{"memory limit", encodeScryptParams(20, 8, 1), true},
+		{"most parallel", encodeScryptParams(10, 8, 16), true},
+		{"N of 1", encodeScryptParams(0, 8, 1), false},
+		{"N above 2^30", encodeScryptParams(31, 1, 1), false},
+		{"N overflowing", encodeScryptParams(255, 8, 1), false},
+		{"r of 0", encodeScryptParams(17, 0, 1), false},
+		{"r too large", encodeScryptParams(1, 1<<20+1, 1), false},
+		{"r overflowing", encodeScryptParams(17, 1<<32-1, 1), false},
+		{"p of 0", encodeScryptParams(17, 8, 0), false},
+		{"p too large", encodeScryptParams(17, 8, 17), false},
+		{"p overflowing", encodeScryptParams(17, 8, 1<<32-1), false},
+		// 128 * r * N bytes above 1 GiB
+		{"over the memory limit", encodeScryptParams(20, 9, 1), false},
+		{"largest N over the memory limit", encodeScryptParams(30, 1, 1), false},
+		{"no salt", encodeScryptParams(17, 8, 1)[:9], false},
+		{"short salt", encodeScryptParams(17, 8, 1)[:9+passphraseSaltSize-1], false},
+		{"empty", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parsePassphraseParams(tt.params)
+			if tt.valid != (err == nil) {
+				t.Fatalf("parsePassphraseParams: err = %v, want valid = %t", err, tt.valid)
+			}
+			if tt.valid {
+				return
+			}
+
+			// Invalid parameters are refused before scrypt runs, so a crafted
+			// header cannot make it allocate more than the limit
+			header := NewHeader()
+			header.KDF = KDFPassphrase
+			header.KDFParams = tt.params
+			if _, err := PassphraseKey(header, []byte("passphrase")); err == nil {
+				t.Fatal("PassphraseKey accepted invalid parameters")
+			}
+			if _, err := (&Unlocker{Passphrase: func() ([]byte, error) { return []byte("passphrase"), nil }}).Key(header); err == nil {
+				t.Fatal("Unlocker accepted invalid parameters")
+			}
+		})
+	}
+
+	// New headers are held to the same limits
+	if _, err := NewPassphraseHeader(ScryptParams{LogN: 20, R: 9, P: 1}); err == nil {
+		t.Fatal("NewPassphraseHeader accepted parameters over the memory limit")
+	}
+}
+
+func TestScryptParamsSurviveHeader(t *testing.T) {
+	header := newTestPassphraseHeader(t)
+	header.NoncePrefix = randomBytes(t, noncePrefixSize)
+	raw, err := header.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadHeader(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, salt, err := parsePassphraseParams(read.KDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params != testScryptParams || len(salt) != passphraseSaltSize {
+		t.Fatalf("read parameters %+v with a %d-byte salt, want %+v with a %d-byte salt", params, len(salt), testScryptParams, passphraseSaltSize)
+	}
+	if !bytes.Equal(passphraseKey(t, read, "passphrase"), passphraseKey(t, header, "passphrase")) {
+		t.Fatal("the header read back derives a different key")
+	}
+}
+
+func TestPassphraseKey(t *testing.T) {
+	header := newTestPassphraseHeader(t)
+	key := passphraseKey(t, header, "correct horse")
+	if len(key) != 32 {
+		t.Fatalf("key is %d bytes, want 32", len(key))
+	}
+	if !bytes.Equal(passphraseKey(t, header, "correct horse"), key) {
+		t.Fatal("the same passphrase and header derive different keys")
+	}
+	if bytes.Equal(passphraseKey(t, header, "correct horse "), key) {
+		t.Fatal("another passphrase derives the same key")
+	}
+	if bytes.Equal(passphraseKey(t, newTestPassphraseHeader(t), "correct horse"), key) {
+		t.Fatal("another salt derives the same key")
+	}
+
+	if _, err := PassphraseKey(header, nil); err == nil {
+		t.Fatal("PassphraseKey accepted an empty passphrase")
+	}
+	if _, err := PassphraseKey(NewHeader(), []byte("correct horse")); err == nil {
+		t.Fatal("PassphraseKey accepted a header of another KDF")
+	}
+}
+
+func TestPassphraseFileKeys(t *testing.T) {
+	header := newTestPassphraseHeader(t)
+	master := passphraseKey(t, header, "passphrase")
+
+	// The files of a folder share the scrypt parameters and salt, and each key is
+	// bound to the file's path
+	withContext := func(h *Header, context string) *Header {
+		c := NewHeader()
+		c.KDF = KDFPassphrase
+		c.KDFParams = h.KDFParams
+		c.Context = []byte(context)
+		return c
+	}
+	headers := []*Header{
+		withContext(header, "a/x"),
+		withContext(header, "a/y"),
+		withContext(header, "ab/x"),
+		withContext(header, "a"),
+	}
+	seen := map[string]string{string(master): "no context"}
+	for _, h := range headers {
+		key := passphraseKey(t, h, "passphrase")
+		if other, ok := seen[string(key)]; ok {
+			t.Fatalf("%s and %s have the same key", h.Context, other)
+		}
+		seen[string(key)] = string(h.Context)
+	}
+
+	// The unlocker runs scrypt once per set of parameters and asks for the
+	// passphrase once, and derives the same keys
+	prompts := 0
+	u := &Unlocker{Passphrase: func() ([]byte, error) {
+		prompts++
+		return []byte("passphrase"), nil
+	}}
+	other := withContext(newTestPassphraseHeader(t), "a/x")
+	for _, h := range append(headers, header, other) {
+		key, err := u.Key(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(key, passphraseKey(t, h, "passphrase")) {
+			t.Fatalf("unlocker derives a different key for %q", h.Context)
+		}
+	}
+	if prompts != 1 {
+		t.Fatalf("passphrase was asked for %d times, want once", prompts)
+	}
+	if len(u.passphraseKeys) != 2 {
+		t.Fatalf("unlocker ran scrypt for %d sets of parameters, want 2", len(u.passphraseKeys))
+	}
+
+	if _, err := (&Unlocker{}).Key(header); !errors.Is(err, ErrPassphraseRequired) {
+		t.Fatalf("unlocker without a passphrase: err = %v, want %v", err, ErrPassphraseRequired)
+	}
+}
+
+func TestEncryptFileWithPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "plain")
+	encPath := filepath.Join(dir, "encrypted")
+	plain := randomBytes(t, 3*ChunkSize+17)
+	if err := os.WriteFile(inPath, plain, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := EncryptFileWithPassphrase(inPath, encPath, []byte("passphrase"), testScryptParams, CompressionNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := ReadFileHeader(encPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(passphraseKey(t, header, "passphrase"), key) {
+		t.Fatal("the key derived from the file header differs from the one returned")
+	}
+
+	outPath := filepath.Join(dir, "decrypted")
+	if err := DecryptFile(encPath, outPath, key); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatal("decrypted data differs from the input")
+	}
+
+	if err := DecryptFile(encPath, outPath, passphraseKey(t, header, "wrong")); err == nil {
+		t.Fatal("a key from the wrong passphrase decrypted the file")
+	}
+}
@@ -0,0 +1,253 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ReaderAt decrypts any byte range of encrypted data without processing the rest of
+// it. Only the segments (or, for the legacy format, the AES blocks) that overlap a
+// read are fetched and decrypted, so a few megabytes can be pulled out of a large
+// file cheaply. Only the segments that are read are authenticated, so truncation
+// past the end of a read goes unnoticed. It is safe for parallel ReadAt calls
+type ReaderAt struct {
+	read func(p []byte, off int64) (int, error)
+	size int64
+}
+
+// NewDecryptReaderAt returns a ReaderAt over the plaintext of the size bytes of
+// encrypted data in r, in the current or the legacy format
+func NewDecryptReaderAt(r io.ReaderAt, size int64, key []byte) (*ReaderAt, error) {
+	head := make([]byte, min(size, base64SniffSize))
+	if _, err := r.ReadAt(head, 0); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read encrypted data: %w", err)
+	}
+	if !bytes.HasPrefix(head, formatMagic) {
+		if isBase64Encoded(head) {
+			decoded, err := newBase64ReaderAt(r, size)
+			if err != nil {
+				return nil, err
+			}
+			return newLegacyReaderAt(decoded, decoded.size, key)
+		}
+		return newLegacyReaderAt(r, size, key)
+	}
+
+	header, err := ReadHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	return header.newDecryptReaderAt(io.NewSectionReader(r, int64(header.Size()), size-int64(header.Size())), key)
+}
+
+// Size returns the size of the plaintext
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+// ReadAt reads len(p) bytes of plaintext starting at off
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+
+	want := len(p)
+	if rest := r.size - off; int64(want) > rest {
+		p = p[:rest]
+	}
+	n, err := r.read(p, off)
+	if err == nil && n < want {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// newDecryptReaderAt decrypts ranges of the segments in data, which holds
+// everything after the header
+func (h *Header) newDecryptReaderAt(data *io.SectionReader, key []byte) (*ReaderAt, error) {
+	if h.Compression != CompressionNone {
+		return nil, ErrCompressedRange
+	}
+	aead, err := h.newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	aad, err := h.aad()
+	if err != nil {
+		return nil, err
+	}
+
+	// Every stream ends with a final segment, which is shorter than the others
+	// unless the plaintext is a whole number of segments
+	sealedSize := int64(h.ChunkSize) + int64(aead.Overhead())
+	count := (data.Size() + sealedSize - 1) / sealedSize
+	if count == 0 || data.Size()-(count-1)*sealedSize < int64(aead.Overhead()) {
+		return nil, fmt.Errorf("%w: truncated data", ErrAuthentication)
+	}
+	if count > 1<<32 {
+		return nil, errors.New("too many segments")
+	}
+
+	s := &segmentReaderAt{
+		data:        data,
+		aead:        aead,
+		noncePrefix: h.NoncePrefix,
+		aad:         aad,
+		chunkSize:   int64(h.ChunkSize),
+		sealedSize:  sealedSize,
+		count:       count,
+		cached:      -1,
+	}
+	return &ReaderAt{read: s.readAt, size: data.Size() - count*int64(aead.Overhead())}, nil
+}
+
+// segmentReaderAt opens the segments that overlap each read
+type segmentReaderAt struct {
+	data        *io.SectionReader
+	aead        cipher.AEAD
+	noncePrefix []byte
+	aad         []byte
+	chunkSize   int64
+	sealedSize  int64
+	count       int64
+
+	// The last segment opened is kept, since consecutive small reads usually fall
+	// into the same segment
+	mu     sync.Mutex
+	cached int64
+	plain  []byte
+}
+
+func (s *segmentReaderAt) readAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		index := (off + int64(n)) / s.chunkSize
+		plain, err := s.segment(index)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], plain[(off+int64(n))%s.chunkSize:])
+	}
+	return n, nil
+}
+
+// segment returns the plaintext of the segment at index. The result must not be
+// modified
+func (s *segmentReaderAt) segment(index int64) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached == index {
+		return s.plain, nil
+	}
+
+	sealed := make([]byte, min(s.sealedSize, s.data.Size()-index*s.sealedSize))
+	if _, err := s.data.ReadAt(sealed, index*s.sealedSize); err != nil {
+		return nil, fmt.Errorf("failed to read encrypted segment: %w", err)
+	}
+	nonce := segmentNonce(s.noncePrefix, uint32(index), index == s.count-1)
+	plain, err := s.aead.Open(sealed[:0], nonce, sealed, s.aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: segment %d", ErrAuthentication, index)
+	}
+
+	s.cached, s.plain = index, plain
+	return plain, nil
+}
+
+// newLegacyReaderAt decrypts ranges of the legacy format, an IV followed by the
+// AES-CTR ciphertext, by starting the counter at the first block of each read
+func newLegacyReaderAt(r io.ReaderAt, size int64, key []byte) (*ReaderAt, error) {
+	iv := make([]byte, aes.BlockSize)
+	if size < aes.BlockSize {
+		return nil, errors.New("failed to read IV: data too short")
+	}
+	if _, err := r.ReadAt(iv, 0); err != nil {
+		return nil, fmt.Errorf("failed to read IV: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	read := func(p []byte, off int64) (int, error) {
+		n, err := r.ReadAt(p, aes.BlockSize+off)
+		if err == io.EOF && n == len(p) {
+			err = nil
+		}
+
+		stream := cipher.NewCTR(block, counterAt(iv, uint64(off/aes.BlockSize)))
+		skip := make([]byte, off%aes.BlockSize)
+		stream.XORKeyStream(skip, skip)
+		stream.XORKeyStream(p[:n], p[:n])
+		return n, err
+	}
+	return &ReaderAt{read: read, size: size - aes.BlockSize}, nil
+}
+
+// counterAt returns the counter block of block i of a CTR stream, which is the IV
+// plus i as a 128-bit big-endian integer
+func counterAt(iv []byte, i uint64) []byte {
+	counter := append([]byte{}, iv...)
+	for j := len(counter) - 1; j >= 0 && i > 0; j-- {
+		sum := uint64(counter[j]) + i&0xff
+		counter[j] = byte(sum)
+		i = i>>8 + sum>>8
+	}
+	return counter
+}
+
+// base64ReaderAt decodes ranges of unwrapped, standard base64 data
+type base64ReaderAt struct {
+	r       io.ReaderAt
+	encoded int64
+	size    int64
+}
+
+func newBase64ReaderAt(r io.ReaderAt, encoded int64) (*base64ReaderAt, error) {
+	if encoded%4 != 0 {
+		return nil, errors.New("invalid base64 data: length is not a multiple of 4")
+	}
+	size := encoded / 4 * 3
+	if encoded > 0 {
+		tail := make([]byte, 2)
+		if _, err := r.ReadAt(tail, encoded-2); err != nil {
+			return nil, fmt.Errorf("failed to read encrypted data: %w", err)
+		}
+		size -= int64(bytes.Count(tail, []byte("=")))
+	}
+	return &base64ReaderAt{r: r, encoded: encoded, size: size}, nil
+}
+
+func (b *base64ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= b.size {
+		return 0, io.EOF
+	}
+
+	// Decode the whole quantums of 4 characters (3 bytes) that overlap the read
+	start := off / 3 * 4
+	end := min((off+int64(len(p))+2)/3*4, b.encoded)
+	encoded := make([]byte, end-start)
+	if _, err := b.r.ReadAt(encoded, start); err != nil && err != io.EOF {
+		return 0, fmt.Errorf("failed to read encrypted data: %w", err)
+	}
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	k, err := base64.StdEncoding.Decode(decoded, encoded)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode base64 data: %w", err)
+	}
+
+	n := copy(p, decoded[off%3:k])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
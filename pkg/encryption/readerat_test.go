@@ -0,0 +1,176 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func newTestReaderAt(t *testing.T, key, data []byte) *ReaderAt {
+	t.Helper()
+	r, err := NewDecryptReaderAt(bytes.NewReader(data), int64(len(data)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// checkRanges reads ranges that start and end on either side of segment and
+// block boundaries and compares them with the plaintext
+func checkRanges(t *testing.T, r *ReaderAt, plain []byte) {
+	t.Helper()
+	if r.Size() != int64(len(plain)) {
+		t.Fatalf("Size() = %d, want %d", r.Size(), len(plain))
+	}
+
+	size := int64(len(plain))
+	offsets := []int64{0, 1, 15, 16, 17, testSegmentSize - 1, testSegmentSize, testSegmentSize + 1, size / 2, size - 1}
+	lengths := []int64{1, 3, 16, testSegmentSize, testSegmentSize + 2, 3 * testSegmentSize, size}
+	for _, off := range offsets {
+		if off < 0 || off >= size {
+			continue
+		}
+		for _, length := range lengths {
+			p := make([]byte, length)
+			n, err := r.ReadAt(p, off)
+			want := plain[off:min(off+length, size)]
+			if n != len(want) || !bytes.Equal(p[:n], want) {
+				t.Fatalf("ReadAt(%d, %d) returned %d bytes that differ from the plaintext", length, off, n)
+			}
+			if off+length > size && err != io.EOF {
+				t.Fatalf("ReadAt(%d, %d) past the end: err = %v, want io.EOF", length, off, err)
+			}
+			if off+length <= size && err != nil {
+				t.Fatalf("ReadAt(%d, %d): %v", length, off, err)
+			}
+		}
+	}
+
+	if _, err := r.ReadAt(make([]byte, 1), size); err != io.EOF {
+		t.Fatalf("ReadAt at the end: err = %v, want io.EOF", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 1), -1); err == nil {
+		t.Fatal("ReadAt at a negative offset: expected an error")
+	}
+}
+
+func TestReaderAtRanges(t *testing.T) {
+	key := testKey(t)
+	header := func() *Header {
+		h := NewHeader()
+		h.ChunkSize = testSegmentSize
+		return h
+	}
+
+	tests := []struct {
+		name string
+		size int
+		data func(plain []byte) []byte
+	}{
+		{"partial last segment", 5*testSegmentSize + 9, func(plain []byte) []byte {
+			return encryptBytes(t, key, plain, header())
+		}},
+		{"whole segments", 4 * testSegmentSize, func(plain []byte) []byte {
+			return encryptBytes(t, key, plain, header())
+		}},
+		{"one byte", 1, func(plain []byte) []byte {
+			return encryptBytes(t, key, plain, header())
+		}},
+		{"legacy", 5*testSegmentSize + 9, func(plain []byte) []byte {
+			return legacyEncrypt(t, key, plain, false)
+		}},
+		{"legacy base64", 5*testSegmentSize + 9, func(plain []byte) []byte {
+			return legacyEncrypt(t, key, plain, true)
+		}},
+		{"legacy base64 with padding", 5*testSegmentSize + 10, func(plain []byte) []byte {
+			return legacyEncrypt(t, key, plain, true)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := randomBytes(t, tt.size)
+			checkRanges(t, newTestReaderAt(t, key, tt.data(plain)), plain)
+		})
+	}
+}
+
+func TestReaderAtEmpty(t *testing.T) {
+	key := testKey(t)
+	r := newTestReaderAt(t, key, encryptBytes(t, key, nil, nil))
+	if r.Size() != 0 {
+		t.Fatalf("Size() = %d, want 0", r.Size())
+	}
+	if _, err := r.ReadAt(make([]byte, 1), 0); err != io.EOF {
+		t.Fatalf("err = %v, want io.EOF", err)
+	}
+}
+
+func TestReaderAtAuthentication(t *testing.T) {
+	key := testKey(t)
+	header := NewHeader()
+	header.ChunkSize = testSegmentSize
+	plain := randomBytes(t, 4*testSegmentSize+9)
+	data := encryptBytes(t, key, plain, header)
+
+	parsed, err := ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedSize := testSegmentSize + 16
+
+	// A segment that was full becomes the last one, so its final flag is wrong
+	truncated := data[:parsed.Size()+3*sealedSize]
+	r := newTestReaderAt(t, key, truncated)
+	if _, err := r.ReadAt(make([]byte, 1), 2*testSegmentSize+1); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("truncated: err = %v, want ErrAuthentication", err)
+	}
+
+	tampered := append([]byte{}, data...)
+	tampered[parsed.Size()+sealedSize+5] ^= 1
+	r = newTestReaderAt(t, key, tampered)
+	if _, err := r.ReadAt(make([]byte, 1), 0); err != nil {
+		t.Fatalf("untouched segment: %v", err)
+	}
+	if _, err := r.ReadAt(make([]byte, 1), testSegmentSize+1); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("tampered segment: err = %v, want ErrAuthentication", err)
+	}
+
+	r = newTestReaderAt(t, testKey(t), data)
+	if _, err := r.ReadAt(make([]byte, 1), 0); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong key: err = %v, want ErrAuthentication", err)
+	}
+
+	if _, err := NewDecryptReaderAt(bytes.NewReader(data), int64(parsed.Size()+10), key); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("segment shorter than its tag: err = %v, want ErrAuthentication", err)
+	}
+}
+
+func TestReaderAtCompressed(t *testing.T) {
+	key := testKey(t)
+	header := NewHeader()
+	header.Compression = CompressionGzip
+	data := encryptBytes(t, key, randomBytes(t, 100), header)
+
+	if _, err := NewDecryptReaderAt(bytes.NewReader(data), int64(len(data)), key); !errors.Is(err, ErrCompressedRange) {
+		t.Fatalf("err = %v, want ErrCompressedRange", err)
+	}
+}
+
+func TestCounterAt(t *testing.T) {
+	tests := []struct {
+		iv   []byte
+		i    uint64
+		want []byte
+	}{
+		{make([]byte, 16), 1, append(make([]byte, 15), 1)},
+		{append(make([]byte, 15), 0xff), 1, append(make([]byte, 14), 1, 0)},
+		{bytes.Repeat([]byte{0xff}, 16), 1, make([]byte, 16)},
+		{make([]byte, 16), 0x0102, append(make([]byte, 14), 1, 2)},
+	}
+	for _, tt := range tests {
+		if got := counterAt(tt.iv, tt.i); !bytes.Equal(got, tt.want) {
+			t.Errorf("counterAt(%x, %d) = %x, want %x", tt.iv, tt.i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ErrDetachedKeys is returned when the wrapped keys of an envelope are stored in a
+// detached key manifest instead of the file header
+var ErrDetachedKeys = errors.New("the keys of this file are detached; the key manifest must be supplied")
+
+// keyManifestVersion is the version of the key manifest format
+const keyManifestVersion = 1
+
+// KeyManifest holds the key section of an envelope outside the encrypted file, so that
+// recipients can change without altering a single byte of the stored data
+type KeyManifest struct {
+	Version int `json:"version"`
+	// FileID ties the manifest to its file; it is the hex nonce prefix of the file header
+	FileID     string                 `json:"file_id"`
+	Generation uint32                 `json:"generation"`
+	Recipients []keyManifestRecipient `json:"recipients"`
+}
+
+type keyManifestRecipient struct {
+	Address    string `json:"address"`
+	PublicKey  string `json:"public_key"`
+	WrappedKey string `json:"wrapped_key"`
+}
+
+// KeyManifestPath returns the default path of the key manifest of an encrypted file
+func KeyManifestPath(path string) string {
+	return path + ".keys.json"
+}
+
+// NewKeyManifest returns a manifest holding the key section of the header
+func NewKeyManifest(h *Header) *KeyManifest {
+	m := &KeyManifest{
+		Version:    keyManifestVersion,
+		FileID:     h.FileID(),
+		Generation: h.KeyGeneration,
+		Recipients: []keyManifestRecipient{},
+	}
+	for _, r := range h.Recipients {
+		m.Recipients = append(m.Recipients, keyManifestRecipient{
+			Address:    r.Address().Hex(),
+			PublicKey:  hexutil.Encode(crypto.CompressPubkey(r.PublicKey)),
+			WrappedKey: hexutil.Encode(r.WrappedKey),
+		})
+	}
+	return m
+}
+
+// Apply loads the key section of the manifest into the header of its file
+func (m *KeyManifest) Apply(h *Header) error {
+	if m.Version != keyManifestVersion {
+		return fmt.Errorf("unsupported key manifest version %d", m.Version)
+	}
+	if !strings.EqualFold(m.FileID, h.FileID()) {
+		return errors.New("key manifest belongs to a different file")
+	}
+
+	recipients := make([]Recipient, 0, len(m.Recipients))
+	for _, r := range m.Recipients {
+		pub, err := ParsePublicKey(r.PublicKey)
+		if err != nil {
+			return err
+		}
+		wrapped, err := hexutil.Decode(r.WrappedKey)
+		if err != nil {
+			return fmt.Errorf("invalid wrapped key for %s: %w", r.Address, err)
+		}
+		recipients = append(recipients, Recipient{PublicKey: pub, WrappedKey: wrapped})
+	}
+
+	h.Recipients = recipients
+	h.KeyGeneration = m.Generation
+	return nil
+}
+
+// ReadKeyManifest reads a key manifest from path
+func ReadKeyManifest(path string) (*KeyManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key manifest: %w", err)
+	}
+
+	m := &KeyManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse key manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// WriteKeyManifest writes a key manifest to path
+func WriteKeyManifest(path string, m *KeyManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode key manifest: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write key manifest: %w", err)
+	}
+	return nil
+}
+
+// EncryptFileDetached works like EncryptFileTo, but writes the wrapped keys to the key
+// manifest at manifestPath instead of the file header. Recipients can then be changed
+// by rewriting the manifest alone, leaving the encrypted file and its piece CID unchanged
+func EncryptFileDetached(inputPath, outputPath, manifestPath string, recipients []*ecdsa.PublicKey, compression Compression) ([]byte, error) {
+	key, err := NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := NewEnvelopeHeader(key, recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	header.Compression = compression
+	wrapped := header.Recipients
+	header.Recipients = nil
+	if err := encryptFile(inputPath, outputPath, key, header); err != nil {
+		return nil, err
+	}
+
+	header.Recipients = wrapped
+	if err := WriteKeyManifest(manifestPath, NewKeyManifest(header)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Rekey changes the recipients of an envelope whose data key is known. New
+// recipients are added and revoked ones removed; with rewrap, the same data key is
+// wrapped again for every remaining recipient under fresh ephemeral keys, which does
+// not rotate the data key. The key generation is bumped.
+// Revoked recipients that already hold the data key can still decrypt copies of the
+// data they obtained; only re-encrypting the data prevents that
+func (h *Header) Rekey(dataKey []byte, add []*ecdsa.PublicKey, revoke []common.Address, rewrap bool) error {
+	if h.KDF != KDFEnvelope {
+		return fmt.Errorf("only envelope-encrypted files can be rekeyed, not KDF %s", h.KDF)
+	}
+
+	for _, address := range revoke {
+		if !h.RemoveRecipient(address) {
+			return fmt.Errorf("%s is not a recipient", address.Hex())
+		}
+	}
+
+	if rewrap {
+		for _, r := range append([]Recipient{}, h.Recipients...) {
+			if err := h.AddRecipient(dataKey, r.PublicKey); err != nil {
+				return err
+			}
+		}
+	}
+	for _, pub := range add {
+		if err := h.AddRecipient(dataKey, pub); err != nil {
+			return err
+		}
+	}
+
+	if len(h.Recipients) == 0 {
+		return errors.New("cannot revoke every recipient")
+	}
+	h.KeyGeneration++
+	return nil
+}
+
+// RewriteFileHeader replaces the header of the encrypted file at path. Only the key
+// section may differ from the current header; the segments are copied unchanged
+func RewriteFileHeader(path string, header *Header) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open encrypted file: %w", err)
+	}
+	defer in.Close()
+
+	current, err := ReadHeader(in)
+	if err != nil {
+		return err
+	}
+	oldAAD, err := current.aad()
+	if err != nil {
+		return err
+	}
+	newAAD, err := header.aad()
+	if err != nil {
+		return err
+	}
+	if current.Version != formatVersion || !bytes.Equal(oldAAD, newAAD) {
+		return errors.New("only the key section of the header can be rewritten")
+	}
+
+	raw, err := header.Marshal()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".rekey-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(raw); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	if _, err := io.CopyBuffer(tmp, in, make([]byte, ChunkSize)); err != nil {
+		return fmt.Errorf("failed to copy encrypted data: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write encrypted file: %w", err)
+	}
+	in.Close()
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace encrypted file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package encryption
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/eastore-project/eastore/pkg/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// SigningScheme is how the wallet signs the message a key is derived from
+type SigningScheme uint8
+
+const (
+	// SchemePersonalSign signs the bare CID with the EIP-191 personal message prefix.
+	// It is the legacy scheme, used by files whose KDF parameters are empty
+	SchemePersonalSign SigningScheme = 0
+	// SchemeEIP712 signs EIP-712 typed data in the Eastore domain, which wallets show
+	// as a structured prompt and which cannot collide with other applications' messages
+	SchemeEIP712 SigningScheme = 1
+)
+
+// String returns the name of the signing scheme
+func (s SigningScheme) String() string {
+	switch s {
+	case SchemePersonalSign:
+		return "personal-sign"
+	case SchemeEIP712:
+		return "eip712"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(s))
+	}
+}
+
+// keyPurpose is the purpose field of the typed data signed for encryption keys
+const keyPurpose = "Derive the encryption key of a file stored with Eastore"
+
+// KeySigning describes how the key-derivation message of a file is signed
+type KeySigning struct {
+	Scheme SigningScheme
+	// ChainID and Contract form the EIP-712 domain; a zero contract is left out
+	ChainID  uint64
+	Contract common.Address
+}
+
+// params encodes the signing as the KDF parameters of a wallet-signature header:
+// nothing for personal_sign, or scheme || chain ID (8 bytes, big endian) || contract
+func (s *KeySigning) params() []byte {
+	if s == nil || s.Scheme == SchemePersonalSign {
+		return nil
+	}
+
+	params := binary.BigEndian.AppendUint64([]byte{byte(s.Scheme)}, s.ChainID)
+	if s.Contract != (common.Address{}) {
+		params = append(params, s.Contract.Bytes()...)
+	}
+	return params
+}
+
+// parseKeySigning decodes the KDF parameters of a wallet-signature header
+func parseKeySigning(params []byte) (*KeySigning, error) {
+	if len(params) == 0 {
+		return &KeySigning{Scheme: SchemePersonalSign}, nil
+	}
+
+	s := &KeySigning{Scheme: SigningScheme(params[0])}
+	if s.Scheme != SchemeEIP712 {
+		return nil, fmt.Errorf("unsupported signing scheme %s", s.Scheme)
+	}
+	switch len(params) {
+	case 9:
+	case 9 + common.AddressLength:
+		s.Contract = common.BytesToAddress(params[9:])
+	default:
+		return nil, fmt.Errorf("invalid %s signing parameters", s.Scheme)
+	}
+	s.ChainID = binary.BigEndian.Uint64(params[1:9])
+	return s, nil
+}
+
+// TypedData returns the EIP-712 typed data signed to derive the key for a CID
+func (s *KeySigning) TypedData(cid string) apitypes.TypedData {
+	domainTypes := []apitypes.Type{
+		{Name: "name", Type: "string"},
+		{Name: "version", Type: "string"},
+		{Name: "chainId", Type: "uint256"},
+	}
+	domain := apitypes.TypedDataDomain{
+		Name:    "Eastore",
+		Version: "1",
+		ChainId: math.NewHexOrDecimal256(int64(s.ChainID)),
+	}
+	if s.Contract != (common.Address{}) {
+		domainTypes = append(domainTypes, apitypes.Type{Name: "verifyingContract", Type: "address"})
+		domain.VerifyingContract = s.Contract.Hex()
+	}
+
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": domainTypes,
+			"EncryptionKey": {
+				{Name: "purpose", Type: "string"},
+				{Name: "cid", Type: "string"},
+			},
+		},
+		PrimaryType: "EncryptionKey",
+		Domain:      domain,
+		Message: apitypes.TypedDataMessage{
+			"purpose": keyPurpose,
+			"cid":     cid,
+		},
+	}
+}
+
+// sign signs the key-derivation message for a CID
+func (s *KeySigning) sign(privateKey, cid string) ([]byte, error) {
+	if s == nil || s.Scheme == SchemePersonalSign {
+		return utils.SignMessage(privateKey, cid)
+	}
+	return utils.SignTypedData(privateKey, s.TypedData(cid))
+}
@@ -0,0 +1,155 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+// ChunkSize is the size of the buffers used when streaming data through the cipher,
+// which bounds the memory used by encryption and decryption regardless of input size
+const ChunkSize = 1 << 20
+
+// base64SniffSize is how many leading bytes are inspected to tell the format of
+// encrypted data
+const base64SniffSize = 512
+
+// NewEncryptWriter returns a writer that encrypts everything written to it with the
+// given key in authenticated segments, and writes the header and result to w. The
+// header describes the cipher suite, chunk size and key derivation; if nil, NewHeader
+// is used. Its nonce prefix is generated here. If the header sets a compression, the
+// data is compressed before it is encrypted.
+// The caller must Close the returned writer to seal the final segment; this does not close w
+func NewEncryptWriter(w io.Writer, key []byte, header *Header) (io.WriteCloser, error) {
+	if header == nil {
+		header = NewHeader()
+	}
+	aead, err := header.newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header.Version = formatVersion
+	header.NoncePrefix = make([]byte, noncePrefixSize)
+	if _, err := rand.Read(header.NoncePrefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce prefix: %w", err)
+	}
+
+	raw, err := header.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	aad, err := header.aad()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	return header.Compression.newWriter(newSegmentWriter(w, aead, header.NoncePrefix, aad, int(header.ChunkSize)))
+}
+
+// NewDecryptReader returns a reader that decrypts data produced by NewEncryptWriter
+// or EncryptData. Files in the legacy unauthenticated AES-CTR format, base64 encoded
+// or raw, are still accepted
+func NewDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, ChunkSize)
+
+	head, err := br.Peek(base64SniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, fmt.Errorf("failed to read encrypted data: %w", err)
+	}
+	// Only files without the magic are sniffed for base64, so binary data in the
+	// current format can never be mistaken for the legacy encoding
+	if !bytes.HasPrefix(head, formatMagic) {
+		return newLegacyDecryptReader(br, head, key)
+	}
+
+	header, err := ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	return header.newDecryptReader(br, key)
+}
+
+// newDecryptReader decrypts the segments that follow the header in r
+func (h *Header) newDecryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := h.newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	aad, err := h.aad()
+	if err != nil {
+		return nil, err
+	}
+	return h.Compression.newReader(newSegmentReader(r, aead, h.NoncePrefix, aad, int(h.ChunkSize)))
+}
+
+// newLegacyDecryptReader decrypts the legacy format: a random IV followed by the
+// AES-CTR ciphertext, optionally base64 encoded. The format carries no
+// authentication, so a wrong key or corrupted data cannot be detected
+func newLegacyDecryptReader(br *bufio.Reader, head, key []byte) (io.Reader, error) {
+	var src io.Reader = br
+	if isBase64Encoded(head) {
+		src = base64.NewDecoder(base64.StdEncoding, br)
+	}
+
+	// Extract IV from the beginning
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(src, iv); err != nil {
+		return nil, fmt.Errorf("failed to read IV: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	return cipher.StreamReader{S: cipher.NewCTR(block, iv), R: src}, nil
+}
+
+// newGCM creates an AES-GCM AEAD for the key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// EncryptStream encrypts src into dst with the given key, one chunk at a time. The
+// header may be nil to use the defaults
+func EncryptStream(dst io.Writer, src io.Reader, key []byte, header *Header) error {
+	w, err := NewEncryptWriter(dst, key, header)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.CopyBuffer(w, src, make([]byte, ChunkSize)); err != nil {
+		return fmt.Errorf("failed to encrypt data: %w", err)
+	}
+	return w.Close()
+}
+
+// DecryptStream decrypts src into dst with the given key, one chunk at a time
+func DecryptStream(dst io.Writer, src io.Reader, key []byte) error {
+	r, err := NewDecryptReader(src, key)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.CopyBuffer(dst, r, make([]byte, ChunkSize)); err != nil {
+		return fmt.Errorf("failed to decrypt data: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// encryptBytes encrypts plain with a header and returns the encrypted file
+func encryptBytes(t *testing.T, key, plain []byte, header *Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := EncryptStream(&buf, bytes.NewReader(plain), key, header); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decryptBytes(key, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	err := DecryptStream(&buf, bytes.NewReader(data), key)
+	return buf.Bytes(), err
+}
+
+// legacyEncrypt produces the legacy format: a random IV followed by the AES-CTR
+// ciphertext, base64 encoded if asked
+func legacyEncrypt(t *testing.T, key, plain []byte, encode bool) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := randomBytes(t, aes.BlockSize)
+	out := make([]byte, len(plain))
+	cipher.NewCTR(block, iv).XORKeyStream(out, plain)
+	out = append(iv, out...)
+	if encode {
+		return []byte(base64.StdEncoding.EncodeToString(out))
+	}
+	return out
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := testKey(t)
+
+	tests := []struct {
+		name   string
+		size   int
+		header func() *Header
+	}{
+		{"default header", 3*DefaultChunkSize + 17, func() *Header { return nil }},
+		{"empty", 0, func() *Header { return nil }},
+		{"chacha20-poly1305", 1000, func() *Header {
+			h := NewHeader()
+			h.Suite = SuiteChaCha20Poly1305
+			h.ChunkSize = testSegmentSize
+			return h
+		}},
+		{"gzip", 5000, func() *Header {
+			h := NewHeader()
+			h.Compression = CompressionGzip
+			return h
+		}},
+		{"zstd", 5000, func() *Header {
+			h := NewHeader()
+			h.Compression = CompressionZstd
+			return h
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain := randomBytes(t, tt.size)
+			data := encryptBytes(t, key, plain, tt.header())
+			if !bytes.HasPrefix(data, formatMagic) {
+				t.Fatal("encrypted data does not start with the format magic")
+			}
+
+			got, err := decryptBytes(key, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatal("decrypted data differs from the plaintext")
+			}
+		})
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	key := testKey(t)
+	header := NewHeader()
+	header.ChunkSize = testSegmentSize
+	data := encryptBytes(t, key, randomBytes(t, 4*testSegmentSize), header)
+
+	parsed, err := ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedSize := testSegmentSize + 16
+	for _, segments := range []int{1, 2, 3} {
+		truncated := data[:parsed.Size()+segments*sealedSize]
+		if _, err := decryptBytes(key, truncated); !errors.Is(err, ErrAuthentication) {
+			t.Fatalf("truncated to %d segments: err = %v, want ErrAuthentication", segments, err)
+		}
+	}
+	if _, err := decryptBytes(key, data[:parsed.Size()-1]); err == nil {
+		t.Fatal("truncated header: expected an error")
+	}
+}
+
+func TestLegacyDecrypt(t *testing.T) {
+	key := testKey(t)
+
+	for _, size := range []int{0, 1, 100, ChunkSize + 3} {
+		for _, encode := range []bool{false, true} {
+			plain := randomBytes(t, size)
+			got, err := decryptBytes(key, legacyEncrypt(t, key, plain, encode))
+			if err != nil {
+				t.Fatalf("size %d, base64 %v: %v", size, encode, err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("size %d, base64 %v: decrypted data differs from the plaintext", size, encode)
+			}
+		}
+	}
+}
+
+func TestEncryptData(t *testing.T) {
+	plain := []byte("hello, eastore")
+	signature := []byte("signature")
+
+	data, keyHex, err := EncryptData(plain, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keyHex) != 64 {
+		t.Fatalf("key = %q, want 32 hex bytes", keyHex)
+	}
+	got, err := DecryptData(data, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("decrypted %q, want %q", got, plain)
+	}
+	if _, err := DecryptData(data, []byte("other signature")); !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("wrong signature: err = %v, want ErrAuthentication", err)
+	}
+}
@@ -0,0 +1,276 @@
+// Package keyvault provides a local store of data keys, encrypted under a passphrase
+// or the wallet, and indexed by the CIDs of the data they encrypt
+package keyvault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eastore-project/eastore/pkg/encryption"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// FileName is the name of the vault file in the local repo
+const FileName = "keys.vault"
+
+// VaultMessage is the message the wallet signs to derive the key of a vault that is
+// unlocked with the wallet
+const VaultMessage = "Eastore key vault v1\n\nSign this message to unlock your Eastore key vault."
+
+// vaultVersion is the version of the vault contents
+const vaultVersion = 1
+
+// ErrNotFound is returned when no key matches an identifier
+var ErrNotFound = errors.New("key not found in vault")
+
+// Entry is a data key and the identifiers of the data it encrypts
+type Entry struct {
+	// KeyRef identifies the key without revealing it; it is unique in the vault
+	KeyRef string        `json:"key_ref"`
+	Key    hexutil.Bytes `json:"key"`
+	// FileID is the ID of the encrypted file, from its header
+	FileID       string `json:"file_id,omitempty"`
+	PlaintextCID string `json:"plaintext_cid,omitempty"`
+	PayloadCID   string `json:"payload_cid,omitempty"`
+	PieceCID     string `json:"piece_cid,omitempty"`
+	// Path is the original path of the encrypted input
+	Path string `json:"path,omitempty"`
+	// KDF is how the key was obtained, as recorded in the file header
+	KDF string `json:"kdf,omitempty"`
+	// KeyManifest is the path of the detached key manifest holding the wrapped key
+	KeyManifest string    `json:"key_manifest,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// NewEntry returns an entry for a data key
+func NewEntry(key []byte) *Entry {
+	return &Entry{
+		KeyRef:    encryption.KeyID(key),
+		Key:       append([]byte{}, key...),
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// Matches reports whether id is the key reference, file ID or one of the CIDs of the entry
+func (e *Entry) Matches(id string) bool {
+	for _, v := range []string{e.KeyRef, e.FileID, e.PlaintextCID, e.PayloadCID, e.PieceCID} {
+		if v != "" && strings.EqualFold(v, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Erasable reports whether destroying the stored copies of the key makes the data
+// unrecoverable. That is only the case for a random key wrapped in a detached key
+// manifest: wallet and passphrase keys can be derived again, and keys wrapped in the
+// header of a file stay with the stored file
+func (e *Entry) Erasable() bool {
+	return e.KDF == encryption.KDFEnvelope.String() && e.KeyManifest != ""
+}
+
+// merge fills the identifiers of e that are missing from other
+func (e *Entry) merge(other *Entry) {
+	for _, f := range [][2]*string{
+		{&e.FileID, &other.FileID},
+		{&e.PlaintextCID, &other.PlaintextCID},
+		{&e.PayloadCID, &other.PayloadCID},
+		{&e.PieceCID, &other.PieceCID},
+		{&e.Path, &other.Path},
+		{&e.KDF, &other.KDF},
+		{&e.KeyManifest, &other.KeyManifest},
+	} {
+		if *f[1] != "" {
+			*f[0] = *f[1]
+		}
+	}
+}
+
+// validate checks that the key matches its reference
+func (e *Entry) validate() error {
+	if len(e.Key) == 0 {
+		return fmt.Errorf("entry %s has no key", e.KeyRef)
+	}
+	if ref := encryption.KeyID(e.Key); e.KeyRef != ref {
+		return fmt.Errorf("key of entry %s does not match its reference %s", e.KeyRef, ref)
+	}
+	return nil
+}
+
+// contents is the plaintext of the vault file
+type contents struct {
+	Version int      `json:"version"`
+	Entries []*Entry `json:"entries"`
+}
+
+// Vault is a set of data keys stored in a file encrypted with the Eastore container
+// format. The vault key is derived from a passphrase or from the wallet's signature
+// over VaultMessage, as recorded in the file header
+type Vault struct {
+	path    string
+	kdf     *encryption.Header
+	key     []byte
+	entries []*Entry
+}
+
+// Exists reports whether a vault file exists at path
+func Exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// CreateWithWallet returns a new, empty vault at path unlocked by the wallet
+func CreateWithWallet(path, privateKey string) (*Vault, error) {
+	header := encryption.NewHeader()
+	header.KDF = encryption.KDFWalletSignature
+	header.Context = []byte(VaultMessage)
+	key, err := encryption.DeriveKey(header, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive vault key: %w", err)
+	}
+	return &Vault{path: path, kdf: header, key: key}, nil
+}
+
+// CreateWithPassphrase returns a new, empty vault at path unlocked by a passphrase
+func CreateWithPassphrase(path string, passphrase []byte) (*Vault, error) {
+	header, err := encryption.NewPassphraseHeader(encryption.DefaultScryptParams)
+	if err != nil {
+		return nil, err
+	}
+	key, err := encryption.PassphraseKey(header, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive vault key: %w", err)
+	}
+	return &Vault{path: path, kdf: header, key: key}, nil
+}
+
+// Open reads and decrypts the vault at path, using the unlocker to derive its key
+func Open(path string, unlocker *encryption.Unlocker) (*Vault, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key vault: %w", err)
+	}
+	header, err := encryption.ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key vault header: %w", err)
+	}
+	key, err := unlocker.Key(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive vault key: %w", err)
+	}
+
+	plain, err := encryption.DecryptDataWithKey(data, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock key vault: %w", err)
+	}
+	c := &contents{}
+	if err := json.Unmarshal(plain, c); err != nil {
+		return nil, fmt.Errorf("failed to parse key vault: %w", err)
+	}
+	if c.Version != vaultVersion {
+		return nil, fmt.Errorf("unsupported key vault version %d", c.Version)
+	}
+
+	return &Vault{path: path, kdf: header, key: key, entries: c.Entries}, nil
+}
+
+// Path returns the path of the vault file
+func (v *Vault) Path() string {
+	return v.path
+}
+
+// KDF returns how the vault key is derived
+func (v *Vault) KDF() encryption.KDF {
+	return v.kdf.KDF
+}
+
+// Entries returns all entries, oldest first
+func (v *Vault) Entries() []*Entry {
+	return v.entries
+}
+
+// Put adds an entry, or merges its identifiers into the entry with the same key
+func (v *Vault) Put(entry *Entry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	for _, e := range v.entries {
+		if e.KeyRef == entry.KeyRef {
+			e.merge(entry)
+			return nil
+		}
+	}
+	v.entries = append(v.entries, entry)
+	return nil
+}
+
+// Lookup returns the entries matching id, which may be a key reference, a file ID
+// or a plaintext, payload or piece CID. Several files stored in one deal share its
+// payload and piece CIDs
+func (v *Vault) Lookup(id string) []*Entry {
+	var found []*Entry
+	for _, e := range v.entries {
+		if e.Matches(id) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// Delete removes the entries matching id and returns them
+func (v *Vault) Delete(id string) []*Entry {
+	var kept, deleted []*Entry
+	for _, e := range v.entries {
+		if e.Matches(id) {
+			deleted = append(deleted, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	v.entries = kept
+	return deleted
+}
+
+// Save encrypts the vault and replaces its file
+func (v *Vault) Save() error {
+	entries := v.entries
+	if entries == nil {
+		entries = []*Entry{}
+	}
+	plain, err := json.Marshal(&contents{Version: vaultVersion, Entries: entries})
+	if err != nil {
+		return fmt.Errorf("failed to encode key vault: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(v.path), 0700); err != nil {
+		return fmt.Errorf("failed to create key vault directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(v.path), filepath.Base(v.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write key vault: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// The key stays the same, and a new nonce prefix is drawn for every write
+	header := encryption.NewHeader()
+	header.KDF = v.kdf.KDF
+	header.KDFParams = v.kdf.KDFParams
+	header.Context = v.kdf.Context
+	if err := encryption.EncryptStream(tmp, bytes.NewReader(plain), v.key, header); err != nil {
+		return fmt.Errorf("failed to encrypt key vault: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write key vault: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), v.path); err != nil {
+		return fmt.Errorf("failed to replace key vault: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Package shamir implements Shamir's secret sharing over GF(256), splitting a secret
+// into shares any threshold of which recover it, while fewer reveal nothing about it
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// MaxShares is the largest number of shares, one per non-zero field element
+const MaxShares = 255
+
+// Share is one share of a secret: the evaluations at X of one random polynomial
+// per byte of the secret, whose constant term is that byte
+type Share struct {
+	X byte
+	Y []byte
+}
+
+// Split splits secret into n shares, any threshold of which recover it
+func Split(secret []byte, n, threshold int) ([]Share, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("secret must not be empty")
+	}
+	if threshold < 2 {
+		return nil, errors.New("threshold must be at least 2")
+	}
+	if n < threshold {
+		return nil, fmt.Errorf("number of shares %d is below the threshold %d", n, threshold)
+	}
+	if n > MaxShares {
+		return nil, fmt.Errorf("number of shares %d exceeds the maximum of %d", n, MaxShares)
+	}
+
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{X: byte(i + 1), Y: make([]byte, len(secret))}
+	}
+
+	coeffs := make([]byte, threshold)
+	for b, s := range secret {
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, fmt.Errorf("failed to generate polynomial: %w", err)
+		}
+		coeffs[0] = s
+		for _, share := range shares {
+			share.Y[b] = evaluate(coeffs, share.X)
+		}
+	}
+	for i := range coeffs {
+		coeffs[i] = 0
+	}
+	return shares, nil
+}
+
+// Combine recovers the secret from at least threshold shares of it, by Lagrange
+// interpolation at zero. With fewer shares, or shares of different secrets, the
+// result is garbage, so callers should check it
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least 2 shares are needed")
+	}
+	size := len(shares[0].Y)
+	seen := make(map[byte]bool, len(shares))
+	for _, s := range shares {
+		if s.X == 0 {
+			return nil, errors.New("invalid share index 0")
+		}
+		if seen[s.X] {
+			return nil, fmt.Errorf("share %d is given twice", s.X)
+		}
+		seen[s.X] = true
+		if len(s.Y) != size || size == 0 {
+			return nil, errors.New("shares have different lengths")
+		}
+	}
+
+	secret := make([]byte, size)
+	for i, si := range shares {
+		// The Lagrange basis polynomial of share i at zero; subtraction is XOR
+		basis := byte(1)
+		for j, sj := range shares {
+			if i != j {
+				basis = mul(basis, div(sj.X, sj.X^si.X))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= mul(basis, si.Y[b])
+		}
+	}
+	return secret, nil
+}
+
+// evaluate returns the polynomial with the given coefficients, lowest first, at x
+func evaluate(coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// mul multiplies in GF(256) with the AES polynomial x^8+x^4+x^3+x+1, without
+// branching or table lookups on secret values
+func mul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= -(b & 1) & a
+		a = a<<1 ^ (0x1b & -(a >> 7))
+		b >>= 1
+	}
+	return p
+}
+
+// inv returns the multiplicative inverse of a non-zero a, which is a^254
+func inv(a byte) byte {
+	r := a
+	for i := 0; i < 6; i++ {
+		r = mul(mul(r, r), a)
+	}
+	return mul(r, r)
+}
+
+func div(a, b byte) byte {
+	return mul(a, inv(b))
+}
@@ -0,0 +1,161 @@
+package shamir
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math/bits"
+	"testing"
+)
+
+func testSecret(t *testing.T, n int) []byte {
+	t.Helper()
+	secret := make([]byte, n)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// subset returns the shares whose bits are set in mask
+func subset(shares []Share, mask int) []Share {
+	var out []Share
+	for i, s := range shares {
+		if mask&(1<<i) != 0 {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func TestSplitCombineSubsets(t *testing.T) {
+	for _, tt := range []struct{ n, threshold int }{{2, 2}, {3, 2}, {5, 3}, {6, 6}} {
+		secret := testSecret(t, 32)
+		shares, err := Split(secret, tt.n, tt.threshold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(shares) != tt.n {
+			t.Fatalf("%d-of-%d: got %d shares", tt.threshold, tt.n, len(shares))
+		}
+
+		for mask := 1; mask < 1<<tt.n; mask++ {
+			count := bits.OnesCount(uint(mask))
+			if count < 2 {
+				continue
+			}
+			got, err := Combine(subset(shares, mask))
+			if err != nil {
+				t.Fatalf("%d-of-%d, shares %b: %v", tt.threshold, tt.n, mask, err)
+			}
+			// Fewer shares than the threshold give a wrong secret rather than an
+			// error; for 32 random bytes a match is practically impossible
+			if want := count >= tt.threshold; bytes.Equal(got, secret) != want {
+				t.Fatalf("%d-of-%d, shares %b: recovered the secret = %v, want %v", tt.threshold, tt.n, mask, !want, want)
+			}
+		}
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    []byte
+		n         int
+		threshold int
+	}{
+		{"empty secret", nil, 3, 2},
+		{"threshold below 2", []byte{1}, 3, 1},
+		{"fewer shares than the threshold", []byte{1}, 2, 3},
+		{"too many shares", []byte{1}, MaxShares + 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Split(tt.secret, tt.n, tt.threshold); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+	if shares, err := Split([]byte{1}, MaxShares, 2); err != nil || shares[MaxShares-1].X != MaxShares {
+		t.Fatalf("Split with %d shares: %v", MaxShares, err)
+	}
+}
+
+func TestCombineErrors(t *testing.T) {
+	shares, err := Split(testSecret(t, 16), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		shares []Share
+	}{
+		{"no shares", nil},
+		{"one share", shares[:1]},
+		{"duplicate X", []Share{shares[0], shares[1], shares[0]}},
+		{"zero X", []Share{shares[0], {X: 0, Y: shares[1].Y}}},
+		{"different lengths", []Share{shares[0], {X: shares[1].X, Y: shares[1].Y[:8]}}},
+		{"empty values", []Share{{X: 1}, {X: 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Combine(tt.shares); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestCombineKnownAnswer(t *testing.T) {
+	// f(x) = 0x42 + x and g(x) = 0x01 + 2x + 3x^2, evaluated by hand in GF(256)
+	tests := []struct {
+		name   string
+		shares []Share
+		want   []byte
+	}{
+		{"linear", []Share{{X: 1, Y: []byte{0x43}}, {X: 2, Y: []byte{0x40}}}, []byte{0x42}},
+		{"quadratic", []Share{{X: 1, Y: []byte{0x00}}, {X: 2, Y: []byte{0x09}}, {X: 3, Y: []byte{0x08}}}, []byte{0x01}},
+		{"quadratic, reordered", []Share{{X: 3, Y: []byte{0x08}}, {X: 1, Y: []byte{0x00}}, {X: 2, Y: []byte{0x09}}}, []byte{0x01}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Combine(tt.shares)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("secret = %x, want %x", got, tt.want)
+			}
+		})
+	}
+
+	if got := evaluate([]byte{0x01, 0x02, 0x03}, 3); got != 0x08 {
+		t.Fatalf("evaluate = %#x, want 0x08", got)
+	}
+}
+
+func TestFieldArithmetic(t *testing.T) {
+	// Products from FIPS 197, which uses the same field
+	tests := []struct{ a, b, want byte }{
+		{0x57, 0x83, 0xc1},
+		{0x57, 0x13, 0xfe},
+		{0x53, 0xca, 0x01},
+		{0x00, 0xff, 0x00},
+		{0x01, 0xab, 0xab},
+	}
+	for _, tt := range tests {
+		if got := mul(tt.a, tt.b); got != tt.want {
+			t.Errorf("mul(%#x, %#x) = %#x, want %#x", tt.a, tt.b, got, tt.want)
+		}
+		if got := mul(tt.b, tt.a); got != tt.want {
+			t.Errorf("mul(%#x, %#x) = %#x, want %#x", tt.b, tt.a, got, tt.want)
+		}
+	}
+
+	for a := 1; a < 256; a++ {
+		if got := mul(byte(a), inv(byte(a))); got != 1 {
+			t.Fatalf("%#x * inv(%#x) = %#x, want 1", a, a, got)
+		}
+	}
+}
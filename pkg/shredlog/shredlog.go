@@ -0,0 +1,192 @@
+// Package shredlog provides an append-only, hash-chained log of destroyed data keys
+package shredlog
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileName is the name of the log file in the local repo
+const FileName = "shred.log"
+
+// hashDomain separates record hashes from other SHA-256 uses
+const hashDomain = "eastore-shred-log:"
+
+// ErrBrokenChain is returned when a record does not match the hash chain, meaning
+// the log was modified after it was written
+var ErrBrokenChain = errors.New("shred log hash chain is broken")
+
+// Record statuses. A shred first appends a pending record listing what it is about
+// to destroy, and then a done or failed record completing it, so that no key is
+// destroyed without being logged. Records written before statuses were introduced
+// have none
+const (
+	StatusPending = "pending"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Key describes a destroyed key without revealing it
+type Key struct {
+	KeyRef       string `json:"key_ref"`
+	FileID       string `json:"file_id,omitempty"`
+	PlaintextCID string `json:"plaintext_cid,omitempty"`
+	PayloadCID   string `json:"payload_cid,omitempty"`
+	PieceCID     string `json:"piece_cid,omitempty"`
+	KDF          string `json:"kdf,omitempty"`
+	// Erasable is false when the key can still be derived from the wallet, a
+	// passphrase or a header stored with the data
+	Erasable bool `json:"erasable"`
+}
+
+// Record is one shred operation. Hash covers every other field, including the hash
+// of the previous record, so no record can be changed, removed or reordered
+// without breaking the chain
+type Record struct {
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	// Request is the identifier the shred was requested for
+	Request   string   `json:"request"`
+	Keys      []Key    `json:"keys"`
+	Manifests []string `json:"manifests,omitempty"`
+	Deals     []uint64 `json:"deals,omitempty"`
+	Status    string   `json:"status,omitempty"`
+	// Completes is the sequence number of the pending record that a done or failed
+	// record completes
+	Completes uint64 `json:"completes,omitempty"`
+	// Error is why a failed shred stopped
+	Error string `json:"error,omitempty"`
+	Prev  string `json:"prev"`
+	Hash  string `json:"hash"`
+}
+
+// computeHash returns the hash of the record with its Hash field left out
+func (r *Record) computeHash() (string, error) {
+	c := *r
+	c.Hash = ""
+	data, err := json.Marshal(&c)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode shred record: %w", err)
+	}
+	sum := sha256.Sum256(append([]byte(hashDomain), data...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Read returns the records of the log at path, oldest first. A missing log has no
+// records
+func Read(path string) ([]*Record, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open shred log: %w", err)
+	}
+	defer f.Close()
+
+	var records []*Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		r := &Record{}
+		if err := json.Unmarshal(scanner.Bytes(), r); err != nil {
+			return nil, fmt.Errorf("failed to parse shred record %d: %w", len(records)+1, err)
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read shred log: %w", err)
+	}
+	return records, nil
+}
+
+// Verify checks that the records form an unbroken hash chain and returns the hash
+// of the last one, which commits to the whole log
+func Verify(records []*Record) (string, error) {
+	prev := ""
+	for i, r := range records {
+		if r.Seq != uint64(i+1) {
+			return "", fmt.Errorf("%w: record %d has sequence number %d", ErrBrokenChain, i+1, r.Seq)
+		}
+		if r.Prev != prev {
+			return "", fmt.Errorf("%w: record %d does not follow record %d", ErrBrokenChain, r.Seq, r.Seq-1)
+		}
+		hash, err := r.computeHash()
+		if err != nil {
+			return "", err
+		}
+		if r.Hash != hash {
+			return "", fmt.Errorf("%w: record %d was modified", ErrBrokenChain, r.Seq)
+		}
+		prev = r.Hash
+	}
+	return prev, nil
+}
+
+// Incomplete returns the pending records that no later record completes, which are
+// shreds that were interrupted after they were logged
+func Incomplete(records []*Record) []*Record {
+	completed := map[uint64]bool{}
+	for _, r := range records {
+		if r.Completes != 0 {
+			completed[r.Completes] = true
+		}
+	}
+	var incomplete []*Record
+	for _, r := range records {
+		if r.Status == StatusPending && !completed[r.Seq] {
+			incomplete = append(incomplete, r)
+		}
+	}
+	return incomplete
+}
+
+// Append verifies the log at path, then chains the record to it and appends it,
+// filling in its sequence number, time and hashes
+func Append(path string, record *Record) error {
+	records, err := Read(path)
+	if err != nil {
+		return err
+	}
+	prev, err := Verify(records)
+	if err != nil {
+		return err
+	}
+
+	record.Seq = uint64(len(records) + 1)
+	record.Time = time.Now().UTC()
+	record.Prev = prev
+	if record.Hash, err = record.computeHash(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode shred record: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create shred log directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open shred log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write shred log: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to write shred log: %w", err)
+	}
+	return f.Close()
+}
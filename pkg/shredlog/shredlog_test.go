@@ -0,0 +1,217 @@
+package shredlog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeLog appends a pending shred and its completion, then a shred that was
+// interrupted after it was logged, and returns the log path
+func writeLog(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), FileName)
+	for _, r := range []*Record{
+		{Request: "a", Keys: []Key{{KeyRef: "aaaa", Erasable: true}}, Manifests: []string{"/m/a.keys.json"}, Deals: []uint64{1}, Status: StatusPending},
+		{Request: "a", Status: StatusDone, Completes: 1},
+		{Request: "b", Keys: []Key{{KeyRef: "bbbb"}}, Status: StatusPending},
+		{Request: "b", Status: StatusFailed, Completes: 3, Error: "disk full"},
+		{Request: "c", Keys: []Key{{KeyRef: "cccc", Erasable: true}}, Status: StatusPending},
+	} {
+		if err := Append(path, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func readLog(t *testing.T, path string) []*Record {
+	t.Helper()
+	records, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestVerify(t *testing.T) {
+	records := readLog(t, writeLog(t))
+	if len(records) != 5 {
+		t.Fatalf("read %d records, want 5", len(records))
+	}
+
+	head, err := Verify(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != records[4].Hash {
+		t.Fatalf("Verify returned head %s, want the hash of the last record %s", head, records[4].Hash)
+	}
+	for i, r := range records {
+		if r.Seq != uint64(i+1) {
+			t.Fatalf("record %d has sequence number %d", i+1, r.Seq)
+		}
+		if i > 0 && r.Prev != records[i-1].Hash {
+			t.Fatalf("record %d is not chained to record %d", r.Seq, i)
+		}
+	}
+
+	// An empty or missing log is a valid, empty chain
+	head, err = Verify(readLog(t, filepath.Join(t.TempDir(), FileName)))
+	if err != nil || head != "" {
+		t.Fatalf("Verify of a missing log = %q, %v, want an empty head", head, err)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	path := writeLog(t)
+
+	tests := []struct {
+		name   string
+		modify func([]*Record) []*Record
+		want   string
+	}{
+		{
+			name: "changed request",
+			modify: func(r []*Record) []*Record {
+				r[0].Request = "other"
+				return r
+			},
+			want: "record 1 was modified",
+		},
+		{
+			name: "dropped key",
+			modify: func(r []*Record) []*Record {
+				r[0].Keys = nil
+				return r
+			},
+			want: "record 1 was modified",
+		},
+		{
+			name: "erasable flag flipped",
+			modify: func(r []*Record) []*Record {
+				r[2].Keys[0].Erasable = true
+				return r
+			},
+			want: "record 3 was modified",
+		},
+		{
+			name: "failure recorded as done",
+			modify: func(r []*Record) []*Record {
+				r[3].Status = StatusDone
+				r[3].Error = ""
+				return r
+			},
+			want: "record 4 was modified",
+		},
+		{
+			name: "rehashed record",
+			modify: func(r []*Record) []*Record {
+				// Recomputing the hash of an edited record breaks the link from
+				// the record after it
+				r[1].Deals = []uint64{2}
+				r[1].Hash, _ = r[1].computeHash()
+				return r
+			},
+			want: "record 3 does not follow record 2",
+		},
+		{
+			name: "removed record",
+			modify: func(r []*Record) []*Record {
+				return append(r[:1], r[2:]...)
+			},
+			want: "record 2 has sequence number 3",
+		},
+		{
+			name: "renumbered after removal",
+			modify: func(r []*Record) []*Record {
+				r = append(r[:1], r[2:]...)
+				for i, rec := range r {
+					rec.Seq = uint64(i + 1)
+				}
+				return r
+			},
+			want: "record 2 does not follow record 1",
+		},
+		{
+			name: "reordered records",
+			modify: func(r []*Record) []*Record {
+				r[1], r[2] = r[2], r[1]
+				return r
+			},
+			want: "record 2 has sequence number 3",
+		},
+		{
+			name: "broken link",
+			modify: func(r []*Record) []*Record {
+				r[4].Prev = r[2].Hash
+				return r
+			},
+			want: "record 5 does not follow record 4",
+		},
+		{
+			name: "first record with a previous hash",
+			modify: func(r []*Record) []*Record {
+				r[0].Prev = r[4].Hash
+				return r
+			},
+			want: "record 1 does not follow record 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Verify(tt.modify(readLog(t, path)))
+			if !errors.Is(err, ErrBrokenChain) {
+				t.Fatalf("Verify = %v, want %v", err, ErrBrokenChain)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Verify = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendRefusesBrokenLog(t *testing.T) {
+	path := writeLog(t)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := strings.Replace(string(data), `"request":"b"`, `"request":"x"`, 1)
+	if err := os.WriteFile(path, []byte(edited), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = Append(path, &Record{Request: "d", Status: StatusPending})
+	if !errors.Is(err, ErrBrokenChain) {
+		t.Fatalf("Append to an edited log = %v, want %v", err, ErrBrokenChain)
+	}
+	if len(readLog(t, path)) != 5 {
+		t.Fatal("Append wrote to an edited log")
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	records := readLog(t, writeLog(t))
+
+	// Shreds that were completed, whether they succeeded or failed, are not listed
+	incomplete := Incomplete(records)
+	if len(incomplete) != 1 || incomplete[0].Seq != 5 || incomplete[0].Request != "c" {
+		t.Fatalf("Incomplete = %+v, want only record 5", incomplete)
+	}
+
+	// Records written before statuses were introduced are never incomplete
+	legacy := []*Record{{Seq: 1, Request: "old", Keys: []Key{{KeyRef: "dddd"}}}}
+	if incomplete := Incomplete(legacy); len(incomplete) != 0 {
+		t.Fatalf("Incomplete of a record without a status = %+v, want none", incomplete)
+	}
+
+	if incomplete := Incomplete(records[:2]); len(incomplete) != 0 {
+		t.Fatalf("Incomplete of a completed shred = %+v, want none", incomplete)
+	}
+	if incomplete := Incomplete(records[:1]); len(incomplete) != 1 || incomplete[0].Seq != 1 {
+		t.Fatalf("Incomplete of a pending shred = %+v, want record 1", incomplete)
+	}
+}
@@ -0,0 +1,287 @@
+// Package tracker reconciles locally recorded deals with their on-chain state
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eastore-project/eastore/pkg/contract"
+	"github.com/eastore-project/eastore/pkg/dealdb"
+	"github.com/eastore-project/eastore/pkg/types"
+	"github.com/eastore-project/eastore/pkg/utils"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ipfs/go-cid"
+)
+
+// Transition records a change in the lifecycle state of a deal
+type Transition struct {
+	ID   uint64
+	From dealdb.State
+	To   dealdb.State
+	Note string
+}
+
+// Options control how the tracker reconciles deals
+type Options struct {
+	// UpdateActivation calls updateActivationStatus for published pieces so that
+	// the contract picks up activations from the storage market actor
+	UpdateActivation bool
+	// Confirmations is the confirmation depth for updateActivationStatus transactions
+	Confirmations uint64
+}
+
+// Tracker walks the local deal database and updates each deal from the contract
+type Tracker struct {
+	client *contract.DealClient
+	// openDB opens the deal database, which is only kept open while it is read or
+	// written, so that other commands can use it during a sync
+	openDB func() (*dealdb.DB, error)
+	opts   Options
+}
+
+// New creates a tracker
+func New(client *contract.DealClient, openDB func() (*dealdb.DB, error), opts Options) *Tracker {
+	return &Tracker{client: client, openDB: openDB, opts: opts}
+}
+
+// Sync reconciles every non-terminal deal once and returns the state transitions made.
+// A deal that fails to sync keeps its state, with the error in its state note, and
+// the other deals are still synced; the errors are returned together at the end
+func (t *Tracker) Sync(ctx context.Context) ([]Transition, error) {
+	deals, err := t.list()
+	if err != nil {
+		return nil, err
+	}
+
+	head, err := t.client.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var transitions []Transition
+	var errs []error
+	for _, deal := range deals {
+		if deal.State.Terminal() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return transitions, err
+		}
+
+		from := deal.State
+		if err := t.syncDeal(ctx, deal, head); err != nil {
+			deal.State = from
+			deal.StateNote = fmt.Sprintf("sync failed: %v", err)
+			errs = append(errs, fmt.Errorf("failed to sync deal %d: %w", deal.ID, err))
+		}
+
+		if err := t.store(deal); err != nil {
+			return transitions, fmt.Errorf("failed to store deal %d: %w", deal.ID, err)
+		}
+
+		if deal.State != from {
+			transitions = append(transitions, Transition{
+				ID:   deal.ID,
+				From: from,
+				To:   deal.State,
+				Note: deal.StateNote,
+			})
+		}
+	}
+	return transitions, errors.Join(errs...)
+}
+
+func (t *Tracker) list() ([]*dealdb.Deal, error) {
+	db, err := t.openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return db.List()
+}
+
+// store writes the fields a sync owns back to the stored deal, leaving the fields
+// set by other commands, such as the erasure by shred, as they are now
+func (t *Tracker) store(deal *dealdb.Deal) error {
+	db, err := t.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(deal.ID, func(stored *dealdb.Deal) error {
+		stored.ProposalID = deal.ProposalID
+		stored.Status = deal.Status
+		stored.State = deal.State
+		stored.StateNote = deal.StateNote
+		stored.DealID = deal.DealID
+		stored.Provider = deal.Provider
+		stored.LastSyncedAt = time.Now().UTC()
+		return nil
+	})
+}
+
+// Watch syncs repeatedly at the given interval until the context is cancelled,
+// passing the transitions of each round to fn
+func (t *Tracker) Watch(ctx context.Context, interval time.Duration, fn func([]Transition, error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		fn(t.Sync(ctx))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncDeal updates a deal in place from the contract state at the given head epoch
+func (t *Tracker) syncDeal(ctx context.Context, deal *dealdb.Deal, head int64) error {
+	pieceCID, err := cid.Decode(deal.PieceCID)
+	if err != nil {
+		return fmt.Errorf("failed to decode piece CID: %w", err)
+	}
+
+	if deal.ProposalID == "" {
+		found, err := t.resolveProposal(ctx, deal, pieceCID.Bytes())
+		if err != nil || !found {
+			return err
+		}
+	}
+
+	_, known, err := t.client.GetDealRequest(ctx, common.HexToHash(deal.ProposalID))
+	if err != nil {
+		return err
+	}
+	if !known {
+		deal.State = dealdb.StateFailed
+		deal.StateNote = "proposal is not known to the contract"
+		return nil
+	}
+
+	status, err := t.client.PieceStatus(ctx, pieceCID.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// Without a refresh, the contract only learns of an activation when someone
+	// calls updateActivationStatus, so a published piece may well be active
+	refreshed := false
+	if status == types.StatusDealPublished && t.opts.UpdateActivation {
+		if status, err = t.updateActivation(ctx, pieceCID.Bytes()); err != nil {
+			return err
+		}
+		refreshed = true
+	}
+	deal.Status = status.String()
+
+	if status == types.StatusDealPublished || status == types.StatusDealActivated {
+		if deal.DealID, err = t.client.PieceDealID(ctx, pieceCID.Bytes()); err != nil {
+			return err
+		}
+		provider, ok, err := t.client.PieceProvider(ctx, pieceCID.Bytes())
+		if err != nil {
+			return err
+		}
+		if ok {
+			if deal.Provider, err = utils.FormatFilAddress(provider); err != nil {
+				return err
+			}
+		}
+	}
+
+	deal.State, deal.StateNote = nextState(status, deal, head, refreshed)
+	return nil
+}
+
+// resolveProposal finds the proposal ID of a deal that was submitted without waiting
+// for its receipt. It reports false if the transaction is not mined yet
+func (t *Tracker) resolveProposal(ctx context.Context, deal *dealdb.Deal, pieceCID []byte) (bool, error) {
+	txHash := common.HexToHash(deal.TxHash)
+
+	receipt, err := t.client.TransactionReceipt(ctx, txHash)
+	if errors.Is(err, ethereum.NotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if receipt.Status != ethtypes.ReceiptStatusSuccessful {
+		deal.State = dealdb.StateFailed
+		deal.StateNote = "proposal transaction reverted"
+		return false, nil
+	}
+
+	proposals, err := t.client.ProposalEvents(receipt)
+	if err != nil {
+		return false, err
+	}
+
+	// A batch transaction creates several proposals; match ours by piece CID
+	for _, proposal := range proposals {
+		request, ok, err := t.client.GetDealRequest(ctx, proposal.ID)
+		if err != nil {
+			return false, err
+		}
+		if ok && string(request.PieceCID) == string(pieceCID) {
+			deal.ProposalID = proposal.ID.Hex()
+			return true, nil
+		}
+	}
+
+	deal.State = dealdb.StateFailed
+	deal.StateNote = "no proposal for the piece in the submission transaction"
+	return false, nil
+}
+
+// updateActivation refreshes the contract's activation status for a piece and
+// returns the resulting status
+func (t *Tracker) updateActivation(ctx context.Context, pieceCID []byte) (types.DealStatus, error) {
+	txHash, err := t.client.UpdateActivationStatus(ctx, pieceCID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := t.client.WaitForSuccess(ctx, txHash, t.opts.Confirmations); err != nil {
+		return 0, fmt.Errorf("failed to update activation status: %w", err)
+	}
+	return t.client.PieceStatus(ctx, pieceCID)
+}
+
+// nextState derives the lifecycle state of a deal from the contract status of its
+// piece and the current head epoch. A published deal only fails at its start epoch if
+// its activation status was refreshed, since the contract does not track activations
+// by itself
+func nextState(status types.DealStatus, deal *dealdb.Deal, head int64, refreshed bool) (dealdb.State, string) {
+	switch status {
+	case types.StatusDealActivated:
+		if head >= deal.EndEpoch {
+			return dealdb.StateExpired, ""
+		}
+		return dealdb.StateActive, ""
+	case types.StatusDealTerminated:
+		if head >= deal.EndEpoch {
+			return dealdb.StateExpired, ""
+		}
+		return dealdb.StateFailed, "deal was terminated before its end epoch"
+	}
+
+	if status == types.StatusDealPublished {
+		if head < deal.StartEpoch {
+			return dealdb.StatePublished, ""
+		}
+		if !refreshed {
+			return dealdb.StatePublished, fmt.Sprintf("start epoch %d passed; the activation status was not refreshed, sync with --update-activation to check it", deal.StartEpoch)
+		}
+	}
+	if head >= deal.StartEpoch {
+		return dealdb.StateFailed, fmt.Sprintf("start epoch %d passed without activation", deal.StartEpoch)
+	}
+	return dealdb.StateProposed, ""
+}
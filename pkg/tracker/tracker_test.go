@@ -0,0 +1,104 @@
+package tracker
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eastore-project/eastore/pkg/dealdb"
+	"github.com/eastore-project/eastore/pkg/types"
+)
+
+func TestNextState(t *testing.T) {
+	deal := &dealdb.Deal{StartEpoch: 100, EndEpoch: 200}
+
+	tests := []struct {
+		name      string
+		status    types.DealStatus
+		head      int64
+		refreshed bool
+		want      dealdb.State
+		wantNote  bool
+	}{
+		{"proposed", types.StatusRequestSubmitted, 50, false, dealdb.StateProposed, false},
+		{"proposed past start", types.StatusRequestSubmitted, 100, false, dealdb.StateFailed, true},
+		{"published", types.StatusDealPublished, 50, false, dealdb.StatePublished, false},
+		{"published past start, not refreshed", types.StatusDealPublished, 150, false, dealdb.StatePublished, true},
+		{"published past start, refreshed", types.StatusDealPublished, 150, true, dealdb.StateFailed, true},
+		{"active", types.StatusDealActivated, 150, false, dealdb.StateActive, false},
+		{"active past end", types.StatusDealActivated, 200, false, dealdb.StateExpired, false},
+		{"terminated early", types.StatusDealTerminated, 150, false, dealdb.StateFailed, true},
+		{"terminated past end", types.StatusDealTerminated, 250, false, dealdb.StateExpired, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, note := nextState(tt.status, deal, tt.head, tt.refreshed)
+			if got != tt.want {
+				t.Fatalf("state = %s (%s), want %s", got, note, tt.want)
+			}
+			if (note != "") != tt.wantNote {
+				t.Fatalf("note = %q, want a note: %v", note, tt.wantNote)
+			}
+		})
+	}
+}
+
+func TestStoreKeepsOtherFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deals.db")
+	openDB := func() (*dealdb.DB, error) { return dealdb.Open(path) }
+
+	db, err := openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deal := &dealdb.Deal{PieceCID: "piece", State: dealdb.StateProposed}
+	if err := db.Add(deal); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The deal is erased by another command while it is being synced
+	synced := *deal
+	erasedAt := time.Now().UTC().Truncate(time.Second)
+	db, err = openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(deal.ID, func(d *dealdb.Deal) error {
+		d.ErasedAt = erasedAt
+		d.ErasureRecord = "record"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tracker opens the database itself, so it must be closed in between
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	synced.State = dealdb.StatePublished
+	synced.DealID = 7
+	tr := New(nil, openDB, Options{})
+	if err := tr.store(&synced); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = openDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	got, err := db.Get(deal.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.State != dealdb.StatePublished || got.DealID != 7 || got.LastSyncedAt.IsZero() {
+		t.Fatalf("synced fields were not stored: %+v", got)
+	}
+	if !got.ErasedAt.Equal(erasedAt) || got.ErasureRecord != "record" {
+		t.Fatalf("erasure was overwritten: %+v", got)
+	}
+}
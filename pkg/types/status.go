@@ -0,0 +1,32 @@
+package types
+
+import "fmt"
+
+// DealStatus mirrors the Status enum the DealClient contract keeps per piece
+type DealStatus uint8
+
+const (
+	StatusNone DealStatus = iota
+	StatusRequestSubmitted
+	StatusDealPublished
+	StatusDealActivated
+	StatusDealTerminated
+)
+
+// String returns the human-readable name of the status
+func (s DealStatus) String() string {
+	switch s {
+	case StatusNone:
+		return "None"
+	case StatusRequestSubmitted:
+		return "RequestSubmitted"
+	case StatusDealPublished:
+		return "DealPublished"
+	case StatusDealActivated:
+		return "DealActivated"
+	case StatusDealTerminated:
+		return "DealTerminated"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(s))
+	}
+}
@@ -0,0 +1,16 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/filecoin-project/go-address"
+)
+
+// FormatFilAddress renders the byte representation of a Filecoin address in its string form
+func FormatFilAddress(b []byte) (string, error) {
+	addr, err := address.NewFromBytes(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse Filecoin address: %w", err)
+	}
+	return addr.String(), nil
+}
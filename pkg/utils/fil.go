@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// attoFILPerFIL is the number of attoFIL in one FIL
+var attoFILPerFIL = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+// filUnits maps the accepted unit suffixes to their number of decimals relative to attoFIL
+var filUnits = []struct {
+	suffix   string
+	decimals int64
+}{
+	// Longer suffixes first so that "attofil" is not matched as "fil"
+	{"attofil", 0},
+	{"nanofil", 9},
+	{"fil", 18},
+}
+
+// ParseFIL parses a FIL amount such as "1.5", "1.5FIL", "2000nanoFIL" or
+// "100attoFIL" and returns it in attoFIL. A bare number is read as FIL
+func ParseFIL(s string) (*big.Int, error) {
+	amount := strings.TrimSpace(s)
+	decimals := int64(18)
+
+	lower := strings.ToLower(amount)
+	for _, unit := range filUnits {
+		if strings.HasSuffix(lower, unit.suffix) {
+			amount = strings.TrimSpace(amount[:len(amount)-len(unit.suffix)])
+			decimals = unit.decimals
+			break
+		}
+	}
+
+	r, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return nil, fmt.Errorf("invalid FIL amount %q", s)
+	}
+	if r.Sign() < 0 {
+		return nil, fmt.Errorf("FIL amount %q must not be negative", s)
+	}
+
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(decimals), nil)))
+	if !r.IsInt() {
+		return nil, fmt.Errorf("FIL amount %q has more precision than one attoFIL", s)
+	}
+	return r.Num(), nil
+}
+
+// FormatFIL renders an attoFIL amount in FIL, trimming trailing zeros
+func FormatFIL(attoFIL *big.Int) string {
+	r := new(big.Rat).SetFrac(attoFIL, attoFILPerFIL)
+	s := strings.TrimRight(r.FloatString(18), "0")
+	s = strings.TrimSuffix(s, ".")
+	return s + " FIL"
+}
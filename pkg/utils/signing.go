@@ -5,6 +5,7 @@ import (
 	"strings"
 
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 )
 
 // SignMessage signs a message with the provided private key and returns the signature
@@ -32,3 +33,27 @@ func SignMessage(privateKey, message string) ([]byte, error) {
 
 	return signature, nil
 }
+
+// SignTypedData signs EIP-712 typed data with the provided private key and returns the
+// signature, as eth_signTypedData_v4 would
+func SignTypedData(privateKey string, typedData apitypes.TypedData) ([]byte, error) {
+	privateKeyECDSA, err := crypto.HexToECDSA(strings.TrimPrefix(privateKey, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	hash, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash typed data: %w", err)
+	}
+
+	signature, err := crypto.Sign(hash, privateKeyECDSA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign typed data: %w", err)
+	}
+
+	// Adjust the 'v' value to Ethereum wallet standard (add 27)
+	signature[64] += 27
+
+	return signature, nil
+}